@@ -0,0 +1,431 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/discovery"
+	"github.com/urfave/cli/v2"
+)
+
+// loadConfig creates the configuration from the configuration file
+// and the CLI arguments. Without a configuration file, the arguments
+// define a single route; otherwise, only the arguments that are set
+// override the file values.
+func loadConfig(args *cli.Context) (*config.Config, error) {
+	c := &config.Config{}
+	fromFile := len(args.Path("config")) > 0
+	if fromFile {
+		var err error
+		if c, err = config.Load(args.Path("config")); err != nil {
+			return nil, err
+		}
+	}
+
+	// isSet reports whether the argument applies: all of them apply
+	// without configuration file, so their default values are used.
+	isSet := func(name string) bool {
+		return !fromFile || args.IsSet(name)
+	}
+
+	if args.Bool("debug") {
+		c.Log.Level = "debug"
+	}
+
+	if isSet("bind-addr") {
+		if len(c.Listeners) == 0 {
+			c.Listeners = []config.ListenerConfig{{}}
+		}
+		c.Listeners[0].Address = args.String("bind-addr")
+		c.Listeners[0].Name = c.Listeners[0].Address
+	}
+	if mode := args.String("bind-socket-mode"); len(mode) > 0 {
+		if len(c.Listeners) == 0 {
+			c.Listeners = []config.ListenerConfig{{}}
+		}
+		c.Listeners[0].SocketMode = mode
+	}
+
+	if cert, key := args.Path("tls-certificate"), args.Path("tls-key"); len(cert) > 0 || len(key) > 0 {
+		c.Listeners[0].TLS = &config.TLSConfig{Certificate: cert, Key: key}
+	}
+	if dir := args.Path("tls-directory"); len(dir) > 0 {
+		if c.Listeners[0].TLS == nil {
+			c.Listeners[0].TLS = &config.TLSConfig{}
+		}
+		c.Listeners[0].TLS.Directory = dir
+	}
+	if domains := args.StringSlice("acme-domain"); len(domains) > 0 {
+		if c.Listeners[0].TLS == nil {
+			c.Listeners[0].TLS = &config.TLSConfig{}
+		}
+		c.Listeners[0].TLS.ACME = &config.ACMEConfig{
+			Domains:      domains,
+			Email:        args.String("acme-email"),
+			Storage:      args.Path("acme-storage"),
+			DirectoryURL: args.String("acme-directory-url"),
+		}
+	}
+	if ca := args.Path("tls-client-ca"); len(ca) > 0 {
+		if c.Listeners[0].TLS == nil {
+			c.Listeners[0].TLS = &config.TLSConfig{}
+		}
+		c.Listeners[0].TLS.ClientAuth = &config.ClientAuthConfig{CA: ca, Mode: args.String("tls-client-auth")}
+	}
+	if maxAge := args.Duration("hsts-max-age"); maxAge > 0 {
+		c.Listeners[0].HSTS = &config.HSTSConfig{MaxAge: maxAge}
+	}
+
+	if addr := args.String("redirect-addr"); len(addr) > 0 && len(c.Listeners) > 0 {
+		redirect := &config.RedirectConfig{}
+		if _, port, err := net.SplitHostPort(c.Listeners[0].Address); err == nil {
+			redirect.Port, _ = strconv.Atoi(port)
+		}
+		c.Listeners = append(c.Listeners, config.ListenerConfig{Name: addr, Address: addr, Redirect: redirect})
+	}
+
+	if addr := args.String("admin-addr"); len(addr) > 0 {
+		if c.Admin == nil {
+			c.Admin = &config.AdminConfig{}
+		}
+		c.Admin.Address = addr
+	}
+	if token := args.String("admin-token"); len(token) > 0 && c.Admin != nil {
+		c.Admin.Token = token
+	}
+
+	if addr := args.String("metrics-addr"); len(addr) > 0 {
+		c.Metrics.Address = addr
+	}
+
+	if exporter := args.String("tracing-exporter"); len(exporter) > 0 {
+		c.Tracing.Exporter = exporter
+	}
+	if endpoint := args.String("tracing-endpoint"); len(endpoint) > 0 {
+		c.Tracing.Endpoint = endpoint
+	}
+	if args.IsSet("tracing-sample-rate") {
+		rate := args.Float64("tracing-sample-rate")
+		c.Tracing.SampleRate = &rate
+	}
+
+	if args.Bool("trust-request-id") {
+		c.RequestID.TrustIncoming = true
+	}
+
+	if format := args.String("access-log-format"); len(format) > 0 {
+		if c.AccessLog == nil {
+			c.AccessLog = &config.AccessLogConfig{}
+		}
+		c.AccessLog.Format = format
+	}
+	if path := args.Path("access-log-file"); len(path) > 0 {
+		if c.AccessLog == nil {
+			c.AccessLog = &config.AccessLogConfig{}
+		}
+		c.AccessLog.Output = "file"
+		c.AccessLog.File.Path = path
+	}
+
+	if args.Bool("insecure") {
+		c.Transport.InsecureSkipVerify = true
+	}
+
+	groups, err := applyUpstreamArgs(args, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if isSet("balancer") {
+		c.LoadBalancing.Algorithm = args.String("balancer")
+	}
+	if isSet("hash-key") {
+		c.LoadBalancing.HashKey = args.String("hash-key")
+	}
+	if isSet("hash-virtual-nodes") {
+		c.LoadBalancing.VirtualNodes = args.Int("hash-virtual-nodes")
+	}
+
+	if !fromFile {
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("a target server or an upstream group is required")
+		}
+
+		route := &config.RouteConfig{Name: "default", Path: "/"}
+		if len(groups) == 1 && len(args.StringSlice("split-override")) == 0 {
+			route.Upstream = groups[0]
+		} else {
+			// Without weights, the traffic is evenly split.
+			route.Split = make(map[string]int, len(groups))
+			for _, name := range groups {
+				route.Split[name] = 1
+				if len(args.StringSlice("split-weight")) > 0 {
+					route.Split[name] = 0
+				}
+			}
+		}
+		c.Routes = []*config.RouteConfig{route}
+	}
+
+	for _, route := range c.Routes {
+		if err := applyRouteArgs(args, route); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyMiddlewareArgs(args, &c.Middleware); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// applyUpstreamArgs applies the "target-server", "upstream-group",
+// "discovery-*" and "dns-*" CLI arguments. It returns the names of the
+// upstream groups they define.
+func applyUpstreamArgs(args *cli.Context, c *config.Config) ([]string, error) {
+	var names []string
+	targets := make(map[string][]config.TargetConfig)
+	addTarget := func(name, u string) {
+		if _, ok := targets[name]; !ok {
+			names = append(names, name)
+		}
+		targets[name] = append(targets[name], config.TargetConfig{URL: u})
+	}
+
+	if urlValue := args.Generic("target-server").(*URLGenericValue); urlValue.url != nil {
+		addTarget("default", urlValue.url.String())
+	}
+
+	for _, value := range args.StringSlice("upstream-group") {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid upstream-group value %q", value)
+		}
+		addTarget(parts[0], parts[1])
+	}
+
+	dns := make(map[string][]string)
+	for _, value := range args.StringSlice("dns-upstream") {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid dns-upstream value %q", value)
+		}
+		if _, ok := dns[parts[0]]; !ok {
+			names = append(names, parts[0])
+		}
+		dns[parts[0]] = append(dns[parts[0]], parts[1])
+	}
+
+	if c.Upstreams == nil {
+		c.Upstreams = make(map[string]*config.UpstreamConfig)
+	}
+
+	// The groups of the arguments replace the ones of the file.
+	for _, name := range names {
+		c.Upstreams[name] = &config.UpstreamConfig{Targets: targets[name], DNS: dns[name]}
+	}
+
+	if path := args.Path("discovery-file"); len(path) > 0 {
+		c.Discovery.File = path
+
+		// The discovered groups are part of the default route.
+		discovered, err := discovery.NewFile(path, 0).Groups()
+		if err != nil {
+			return nil, err
+		}
+
+		var discoveredNames []string
+		for name := range discovered {
+			if _, ok := c.Upstreams[name]; !ok {
+				discoveredNames = append(discoveredNames, name)
+			}
+		}
+		sort.Strings(discoveredNames)
+		names = append(names, discoveredNames...)
+	}
+
+	if args.IsSet("discovery-interval") {
+		c.Discovery.Interval = args.Duration("discovery-interval")
+	}
+	if args.IsSet("dns-server") {
+		c.Discovery.DNS.Server = args.String("dns-server")
+	}
+	if args.IsSet("dns-interval") {
+		c.Discovery.DNS.Interval = args.Duration("dns-interval")
+	}
+
+	return names, nil
+}
+
+// applyRouteArgs applies the "split-*", "affinity-*" and "mirror-*"
+// CLI arguments to a route.
+func applyRouteArgs(args *cli.Context, route *config.RouteConfig) error {
+	if len(route.Split) > 0 {
+		for _, value := range args.StringSlice("split-weight") {
+			parts := strings.SplitN(value, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid split-weight value %q", value)
+			}
+
+			weight, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid split-weight value %q: %w", value, err)
+			}
+
+			route.Split[parts[0]] = weight
+		}
+
+		for _, value := range args.StringSlice("split-override") {
+			o, err := parseOverride(value)
+			if err != nil {
+				return err
+			}
+			route.Overrides = append(route.Overrides, o)
+		}
+	}
+
+	if len(args.String("affinity-cookie")) > 0 {
+		httpOnly := args.Bool("affinity-http-only")
+		route.Affinity = &config.AffinityConfig{
+			Cookie:   args.String("affinity-cookie"),
+			Secret:   args.String("affinity-secret"),
+			TTL:      args.Duration("affinity-ttl"),
+			Secure:   args.Bool("affinity-secure"),
+			HTTPOnly: &httpOnly,
+			SameSite: args.String("affinity-same-site"),
+		}
+	}
+
+	if mirrorURL := args.Generic("mirror-target").(*URLGenericValue); mirrorURL.url != nil {
+		percentage := args.Float64("mirror-percentage")
+		route.Mirror = &config.MirrorConfig{
+			Target:        mirrorURL.url.String(),
+			Percentage:    &percentage,
+			MaxConcurrent: args.Int("mirror-max-concurrent"),
+		}
+
+		if args.Bool("mirror-compare") {
+			route.Mirror.Compare = &config.CompareConfig{
+				Headers:     args.StringSlice("mirror-compare-header"),
+				IgnorePaths: args.StringSlice("mirror-compare-ignore-path"),
+				Routes:      args.StringSlice("mirror-compare-route"),
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyMiddlewareArgs applies the middleware CLI arguments.
+func applyMiddlewareArgs(args *cli.Context, m *config.MiddlewareConfig) error {
+	if args.Bool("enable-compression") {
+		minSize := args.Int("compression-min-size")
+		m.Compression = &config.CompressionConfig{
+			ContentTypes: args.StringSlice("compression-content-type"),
+			MinSize:      &minSize,
+		}
+	}
+
+	if args.Bool("enable-cache") {
+		m.Cache = &config.CacheConfig{}
+	}
+
+	if path := args.Path("basic-auth-htpasswd"); len(path) > 0 {
+		m.BasicAuth = &config.BasicAuthConfig{Htpasswd: path}
+		for _, value := range args.StringSlice("basic-auth-route") {
+			parts := strings.SplitN(value, ":", 2)
+			route := config.BasicAuthRouteConfig{Prefix: parts[0]}
+			if len(parts) == 2 {
+				route.Realm = parts[1]
+			}
+			m.BasicAuth.Routes = append(m.BasicAuth.Routes, route)
+		}
+	}
+
+	if authURL := args.Generic("forward-auth-address").(*URLGenericValue); authURL.url != nil {
+		m.ForwardAuth = &config.ForwardAuthConfig{
+			Address:         authURL.url.String(),
+			RequestHeaders:  args.StringSlice("forward-auth-request-header"),
+			ResponseHeaders: args.StringSlice("forward-auth-response-header"),
+		}
+	}
+
+	if len(args.String("jwt-jwks")) > 0 {
+		m.JWT = &config.JWTConfig{
+			JWKS:          args.String("jwt-jwks"),
+			Refresh:       args.Duration("jwt-jwks-refresh"),
+			Issuer:        args.String("jwt-issuer"),
+			Audiences:     args.StringSlice("jwt-audience"),
+			ForwardClaims: make(map[string]string),
+			StripToken:    args.Bool("jwt-strip-token"),
+		}
+
+		for _, value := range args.StringSlice("jwt-require") {
+			parts := strings.SplitN(value, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid jwt-require value %q", value)
+			}
+			m.JWT.Require = append(m.JWT.Require, config.JWTRequirementConfig{Prefix: parts[0], Claims: []string{parts[1]}})
+		}
+
+		for _, value := range args.StringSlice("jwt-forward-claim") {
+			parts := strings.SplitN(value, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid jwt-forward-claim value %q", value)
+			}
+			m.JWT.ForwardClaims[parts[0]] = parts[1]
+		}
+	}
+
+	if len(args.StringSlice("cors-allowed-origin")) > 0 {
+		m.CORS = &config.CORSConfig{
+			AllowedOrigins:   args.StringSlice("cors-allowed-origin"),
+			AllowedMethods:   args.StringSlice("cors-allowed-method"),
+			AllowedHeaders:   args.StringSlice("cors-allowed-header"),
+			ExposedHeaders:   args.StringSlice("cors-exposed-header"),
+			AllowCredentials: args.Bool("cors-allow-credentials"),
+			MaxAge:           args.Duration("cors-max-age"),
+		}
+	}
+
+	return nil
+}
+
+// parseOverride parses a "<kind>:<name>[=<value>][@<group>]" split
+// override, where the kind is "header", "cookie" or "query".
+func parseOverride(value string) (config.OverrideConfig, error) {
+	var o config.OverrideConfig
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return o, fmt.Errorf("invalid split-override value %q", value)
+	}
+
+	kind, rest := parts[0], parts[1]
+	if pos := strings.LastIndex(rest, "@"); pos != -1 {
+		rest, o.Group = rest[:pos], rest[pos+1:]
+	}
+
+	name := rest
+	if pos := strings.Index(rest, "="); pos != -1 {
+		name, o.Value = rest[:pos], rest[pos+1:]
+	}
+
+	switch kind {
+	case "header":
+		o.Header = name
+	case "cookie":
+		o.Cookie = name
+	case "query":
+		o.Query = name
+	default:
+		return o, fmt.Errorf("invalid split-override kind %q", kind)
+	}
+
+	return o, nil
+}
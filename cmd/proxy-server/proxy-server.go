@@ -2,13 +2,27 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/acme"
+	"github.com/moutoum/http-reverse-proxy/pkg/admin"
 	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/certstore"
+	"github.com/moutoum/http-reverse-proxy/pkg/compress"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/discovery"
+	"github.com/moutoum/http-reverse-proxy/pkg/metrics"
 	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/moutoum/http-reverse-proxy/pkg/requestid"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -50,19 +64,97 @@ func main() {
 			Email: "maxence.moutoussamy1@gmail.com",
 		}},
 		Action: app,
+		Commands: []*cli.Command{{
+			Name:      "validate",
+			Usage:     "Check a configuration file",
+			ArgsUsage: "[config file]",
+			Action:    validate,
+		}},
 		Flags: []cli.Flag{
+			&cli.PathFlag{
+				Name:    "config",
+				Aliases: []string{"f"},
+				Usage:   "YAML, JSON or TOML (.toml) configuration file, the other flags override its values",
+				EnvVars: []string{"PROXY_CONFIG"},
+			},
+			&cli.DurationFlag{
+				Name:  "config-check-interval",
+				Usage: "Interval between two checks of the configuration file changes, 0 disables the reload on change",
+				Value: server.DefaultWatchInterval,
+			},
 			&cli.StringFlag{
 				Name:    "bind-addr",
 				Aliases: []string{"b"},
-				Usage:   "Binding address for the proxy server",
+				Usage:   "Binding address for the proxy server, or Unix socket path prefixed by \"unix:\" (e.g. \"unix:/run/proxy.sock\")",
 				Value:   ":80",
 			},
+			&cli.StringFlag{
+				Name:  "bind-socket-mode",
+				Usage: "Octal file mode of the Unix socket of --bind-addr (e.g. \"0660\")",
+			},
+			&cli.StringFlag{
+				Name:  "redirect-addr",
+				Usage: "Binding address of a plaintext listener redirecting the requests to the HTTPS --bind-addr",
+			},
+			&cli.DurationFlag{
+				Name:  "hsts-max-age",
+				Usage: "Max age of the Strict-Transport-Security header of the HTTPS --bind-addr responses",
+			},
+			&cli.StringFlag{
+				Name:  "admin-addr",
+				Usage: "Binding address of the admin API, apart from the proxied traffic",
+			},
+			&cli.StringFlag{
+				Name:    "admin-token",
+				Usage:   "Bearer token required by the admin API",
+				EnvVars: []string{"PROXY_ADMIN_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:  "metrics-addr",
+				Usage: "Binding address of the Prometheus metrics, apart from the proxied traffic",
+			},
+			&cli.StringFlag{
+				Name:  "tracing-exporter",
+				Usage: "Exporter of the trace spans: otlp or stdout (disabled when empty)",
+			},
+			&cli.StringFlag{
+				Name:  "tracing-endpoint",
+				Usage: "OTLP/HTTP traces endpoint of the collector (e.g. http://localhost:4318/v1/traces)",
+			},
+			&cli.Float64Flag{
+				Name:  "tracing-sample-rate",
+				Usage: "Fraction of the new traces recorded, from 0 to 1",
+				Value: 1,
+			},
+			&cli.BoolFlag{
+				Name:  "trust-request-id",
+				Usage: "Keep the X-Request-ID header sent by the clients instead of generating a new ID",
+			},
+			&cli.StringFlag{
+				Name:  "access-log-format",
+				Usage: "Format of the access log written to stdout: common, combined, json or logfmt (disabled when empty)",
+			},
+			&cli.PathFlag{
+				Name:  "access-log-file",
+				Usage: "File the access log is written to, instead of stdout",
+			},
 			&cli.GenericFlag{
-				Name:     "target-server",
-				Aliases:  []string{"t"},
-				Usage:    "Target server URL to use to forward requests",
-				Required: true,
-				Value:    &URLGenericValue{},
+				Name:    "target-server",
+				Aliases: []string{"t"},
+				Usage:   "Target server URL to use to forward requests",
+				Value:   &URLGenericValue{},
+			},
+			&cli.StringSliceFlag{
+				Name:  "upstream-group",
+				Usage: "Named upstream group member (e.g. \"canary=http://localhost:5052\"), the target server is the \"default\" group",
+			},
+			&cli.StringSliceFlag{
+				Name:  "split-weight",
+				Usage: "Traffic weight of an upstream group (e.g. \"canary=10\")",
+			},
+			&cli.StringSliceFlag{
+				Name:  "split-override",
+				Usage: "Force an upstream group with a request header, cookie or query parameter (e.g. \"header:X-Canary=true@canary\" or \"cookie:group\")",
 			},
 			&cli.BoolFlag{
 				Name:    "debug",
@@ -76,68 +168,399 @@ func main() {
 				Usage:   "Enable the cache feature",
 				Value:   false,
 			},
+			&cli.BoolFlag{
+				Name:  "enable-compression",
+				Usage: "Enable the response compression (gzip, br, zstd)",
+			},
+			&cli.IntFlag{
+				Name:  "compression-min-size",
+				Usage: "Minimum response size to be compressed",
+				Value: compress.DefaultMinSize,
+			},
+			&cli.StringSliceFlag{
+				Name:  "compression-content-type",
+				Usage: "Compressible content type prefix",
+				Value: cli.NewStringSlice(compress.DefaultContentTypes...),
+			},
 			&cli.PathFlag{
-				Name:  "tls-certificate",
+				Name:    "tls-certificate",
 				Aliases: []string{"crt"},
-				Usage: "TLS certificate",
+				Usage:   "TLS certificate",
 			},
 			&cli.PathFlag{
-				Name:  "tls-key",
+				Name:    "tls-key",
 				Aliases: []string{"key"},
-				Usage: "TLS key",
+				Usage:   "TLS key",
+			},
+			&cli.PathFlag{
+				Name:  "tls-directory",
+				Usage: "Directory of more TLS certificates and keys, selected by server name",
+			},
+			&cli.PathFlag{
+				Name:  "tls-client-ca",
+				Usage: "CA certificates verifying the client certificates",
+			},
+			&cli.StringFlag{
+				Name:  "tls-client-auth",
+				Usage: "Client certificate mode: optional (required by the routes with the client_cert middleware) or required",
+				Value: config.ClientAuthOptional,
+			},
+			&cli.StringSliceFlag{
+				Name:  "acme-domain",
+				Usage: "Domain whose certificate is obtained from the ACME CA",
+			},
+			&cli.StringFlag{
+				Name:  "acme-email",
+				Usage: "Contact email of the ACME account",
+			},
+			&cli.PathFlag{
+				Name:  "acme-storage",
+				Usage: "Directory of the ACME account key and certificates, can be shared by several instances",
+				Value: "acme",
+			},
+			&cli.StringFlag{
+				Name:  "acme-directory-url",
+				Usage: "ACME directory of the CA (e.g. a Pebble test server)",
+				Value: acme.DefaultDirectoryURL,
 			},
 			&cli.BoolFlag{
-				Name: "insecure",
+				Name:    "insecure",
 				Aliases: []string{"k"},
-				Usage: "Use to skip TLS authority verification",
+				Usage:   "Use to skip TLS authority verification",
+			},
+			&cli.PathFlag{
+				Name:  "discovery-file",
+				Usage: "YAML or JSON file listing the upstream groups, watched for changes",
+			},
+			&cli.DurationFlag{
+				Name:  "discovery-interval",
+				Usage: "Duration between two checks of the discovery file",
+				Value: discovery.DefaultCheckInterval,
+			},
+			&cli.StringSliceFlag{
+				Name:  "dns-upstream",
+				Usage: "Upstream group resolved from DNS, as <group>=<url> (A/AAAA records) or <group>=http+srv://<name> (SRV records)",
+			},
+			&cli.StringFlag{
+				Name:  "dns-server",
+				Usage: "DNS server address used to resolve the upstreams (system name server when empty)",
+			},
+			&cli.DurationFlag{
+				Name:  "dns-interval",
+				Usage: "Duration between two DNS resolutions (records TTL when zero)",
+			},
+			&cli.StringFlag{
+				Name:  "balancer",
+				Usage: "Load balancing algorithm of the upstream groups (round-robin or consistent-hash)",
+				Value: "round-robin",
+			},
+			&cli.StringFlag{
+				Name:  "hash-key",
+				Usage: "Consistent hashing key (path, ip, header:<name>, cookie:<name> or query:<name>)",
+				Value: "ip",
+			},
+			&cli.IntFlag{
+				Name:  "hash-virtual-nodes",
+				Usage: "Number of points of each upstream on the consistent hashing ring",
+				Value: proxy.DefaultVirtualNodes,
+			},
+			&cli.StringFlag{
+				Name:  "affinity-cookie",
+				Usage: "Cookie name used to enable the sticky sessions",
+			},
+			&cli.StringFlag{
+				Name:    "affinity-secret",
+				Usage:   "Secret used to sign the affinity cookie (required with the sticky sessions)",
+				EnvVars: []string{"PROXY_AFFINITY_SECRET"},
+			},
+			&cli.DurationFlag{
+				Name:  "affinity-ttl",
+				Usage: "Lifetime of the sticky sessions (session cookie when zero)",
+			},
+			&cli.BoolFlag{
+				Name:  "affinity-secure",
+				Usage: "Set the secure attribute of the affinity cookie",
+			},
+			&cli.BoolFlag{
+				Name:  "affinity-http-only",
+				Usage: "Set the httponly attribute of the affinity cookie",
+				Value: true,
+			},
+			&cli.StringFlag{
+				Name:  "affinity-same-site",
+				Usage: "SameSite attribute of the affinity cookie (lax, strict or none)",
+			},
+			&cli.GenericFlag{
+				Name:  "mirror-target",
+				Usage: "Shadow server URL that receives a copy of the requests",
+				Value: &URLGenericValue{},
+			},
+			&cli.Float64Flag{
+				Name:  "mirror-percentage",
+				Usage: "Percentage of the requests mirrored to the shadow server",
+				Value: 100,
+			},
+			&cli.IntFlag{
+				Name:  "mirror-max-concurrent",
+				Usage: "Maximum number of mirrored requests in flight",
+				Value: 100,
+			},
+			&cli.BoolFlag{
+				Name:  "mirror-compare",
+				Usage: "Compare the shadow responses with the primary ones",
+			},
+			&cli.StringSliceFlag{
+				Name:  "mirror-compare-header",
+				Usage: "Response header compared between the primary and shadow responses",
+			},
+			&cli.StringSliceFlag{
+				Name:  "mirror-compare-ignore-path",
+				Usage: "JSON body path ignored by the comparison (e.g. \"meta.timestamp\")",
+			},
+			&cli.StringSliceFlag{
+				Name:  "mirror-compare-route",
+				Usage: "Path prefix used to aggregate the comparison counters",
+			},
+			&cli.StringFlag{
+				Name:  "jwt-jwks",
+				Usage: "JWKS file path or URL used to enable the JWT authentication",
+			},
+			&cli.DurationFlag{
+				Name:  "jwt-jwks-refresh",
+				Usage: "Refresh interval of the JWKS keys",
+				Value: time.Hour,
+			},
+			&cli.StringFlag{
+				Name:  "jwt-issuer",
+				Usage: "Expected JWT issuer",
+			},
+			&cli.StringSliceFlag{
+				Name:  "jwt-audience",
+				Usage: "Accepted JWT audience",
+			},
+			&cli.StringSliceFlag{
+				Name:  "jwt-require",
+				Usage: "Required JWT claim for a path prefix (e.g. \"/admin:role=admin\")",
+			},
+			&cli.StringSliceFlag{
+				Name:  "jwt-forward-claim",
+				Usage: "Forward a JWT claim in a request header (e.g. \"sub:X-User\")",
+			},
+			&cli.BoolFlag{
+				Name:  "jwt-strip-token",
+				Usage: "Remove the JWT from the forwarded request",
+			},
+			&cli.GenericFlag{
+				Name:  "forward-auth-address",
+				Usage: "Auth service URL used to enable the forward authentication",
+				Value: &URLGenericValue{},
+			},
+			&cli.StringSliceFlag{
+				Name:  "forward-auth-request-header",
+				Usage: "Request header sent to the auth service",
+				Value: cli.NewStringSlice("Authorization", "Cookie"),
+			},
+			&cli.StringSliceFlag{
+				Name:  "forward-auth-response-header",
+				Usage: "Auth service response header copied to the forwarded request",
+			},
+			&cli.PathFlag{
+				Name:  "basic-auth-htpasswd",
+				Usage: "htpasswd file used to enable the Basic authentication",
+			},
+			&cli.StringSliceFlag{
+				Name:  "basic-auth-route",
+				Usage: "Path prefix protected by the Basic authentication with its realm (e.g. \"/admin:Admin area\")",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cors-allowed-origin",
+				Usage: "Origin allowed for the cross-origin requests (exact, \"https://*.domain\" or \"/regex/\")",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cors-allowed-method",
+				Usage: "Method allowed for the cross-origin requests",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cors-allowed-header",
+				Usage: "Request header allowed for the cross-origin requests",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cors-exposed-header",
+				Usage: "Response header exposed to the cross-origin requests",
+			},
+			&cli.BoolFlag{
+				Name:  "cors-allow-credentials",
+				Usage: "Allow the cross-origin requests to carry credentials (not with the \"*\" origin)",
+			},
+			&cli.DurationFlag{
+				Name:  "cors-max-age",
+				Usage: "Duration the preflight responses can be cached",
 			},
 		},
 	}
 
-	_ = app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		logrus.WithError(err).Fatal("Error while running proxy server")
+	}
 }
 
 func app(args *cli.Context) error {
-	if args.Bool("debug") {
-		logrus.SetLevel(logrus.DebugLevel)
-	}
+	logrus.AddHook(requestid.LogHook{})
 
-	urlValue := args.Generic("target-server").(*URLGenericValue)
+	// The cache and the metrics are kept across the reloads.
+	store := cache.NewInMemoryCache()
+	registry := metrics.NewRegistry()
+	m := metrics.New(registry)
+	m.WatchStore(store)
 
-	var opts []proxy.Option
-	if args.Bool("insecure") {
-		opts = append(opts, proxy.WithInsecure())
+	reloader, err := server.NewReloader(func() (*config.Config, error) {
+		return loadConfig(args)
+	}, server.WithCache(store), server.WithMetrics(m))
+	if err != nil {
+		return err
 	}
+	defer reloader.Close()
 
-	var h http.Handler = proxy.New(urlValue.url, opts...)
+	cfg := reloader.Config()
+	setLogLevel(cfg)
 
-	if args.Bool("enable-cache") {
-		h = cache.NewHandler(cache.NewInMemoryCache(), h)
+	reload := func() {
+		if err := reloader.Reload(); err != nil {
+			logrus.WithError(err).Error("Error while reloading configuration, keeping the previous one")
+			return
+		}
+		setLogLevel(reloader.Config())
 	}
 
-	s := http.Server{
-		Addr:    args.String("bind-addr"),
-		Handler: h,
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if path := args.Path("config"); len(path) > 0 && args.Duration("config-check-interval") > 0 {
+		go server.WatchFile(path, args.Duration("config-check-interval"), stop, reload)
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
 	go func() {
-		cert, key := args.Path("tls-certificate"), args.Path("tls-key")
-		if len(cert) > 0 && len(key) > 0 {
-			logrus.Infof("Start secured listening at %s", args.String("bind-addr"))
-			if err := s.ListenAndServeTLS(cert, key); err != nil && err != http.ErrServerClosed {
-				logrus.WithError(err).Error("Error while serving HTTP server")
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				logrus.Info("Reloading configuration")
+				reload()
 			}
-			return
 		}
+	}()
 
-		logrus.Infof("Start listening at %s", args.String("bind-addr"))
-		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logrus.WithError(err).Error("Error while serving HTTP server")
-			return
+	// The request IDs and the access log are set up on start only, as
+	// the listeners.
+	chain := func(h http.Handler) http.Handler { return requestIDHandler(cfg.RequestID, h) }
+	if a := cfg.AccessLog; a != nil {
+		format, out, err := server.OpenAccessLog(a)
+		if err != nil {
+			return err
 		}
-	}()
+		defer out.Close()
+		chain = func(h http.Handler) http.Handler {
+			return accesslog.NewHandler(format, out, requestIDHandler(cfg.RequestID, h))
+		}
+	}
 
-	c := make(chan os.Signal)
+	// The certificates of the TLS listeners are reloaded when their
+	// files change, and obtained with ACME when configured. The HTTP-01
+	// challenges are answered on the plaintext listeners.
+	listenerTLS := make(map[string]*server.ListenerTLS)
+	certificates := make(map[string]certstore.Lister)
+	var managers []*acme.Manager
+	for _, l := range cfg.Listeners {
+		if l.TLS == nil {
+			continue
+		}
+
+		t, err := server.NewListenerTLS(l.TLS)
+		if err != nil {
+			return err
+		}
+		listenerTLS[l.Name], certificates[l.Name] = t, t
+		if t.ACME != nil {
+			managers = append(managers, t.ACME)
+		}
+		go t.Watch(l.TLS.ReloadInterval, stop)
+	}
+	m.WatchCertificates(certificates)
+
+	servers := make([]*http.Server, len(cfg.Listeners))
+	for i := range cfg.Listeners {
+		l := cfg.Listeners[i]
+		ln, err := server.Listen(&l)
+		if err != nil {
+			return err
+		}
+
+		handler := server.ListenerHandler(cfg, &l, reloader.Listener(l.Name))
+		if l.TLS == nil && len(managers) > 0 {
+			handler = acme.HTTPChallenges(handler, managers...)
+		}
+
+		s := &http.Server{
+			Addr:    l.Address,
+			Handler: chain(handler),
+		}
+		if t, ok := listenerTLS[l.Name]; ok {
+			s.TLSConfig = t.Config()
+		}
+		servers[i] = s
+
+		go func() {
+			if l.TLS != nil {
+				logrus.Infof("Start secured listening at %s", l.Address)
+				if err := s.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+					logrus.WithError(err).Error("Error while serving HTTP server")
+				}
+				return
+			}
+
+			logrus.Infof("Start listening at %s", l.Address)
+			if err := s.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logrus.WithError(err).Error("Error while serving HTTP server")
+				return
+			}
+		}()
+	}
+
+	if a := cfg.Admin; a != nil {
+		s, err := adminServer(a, reloader, registry, certificates)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, s)
+
+		go func() {
+			logrus.Infof("Start admin API listening at %s", a.Address)
+			if err := serveAdmin(s, a); err != nil && err != http.ErrServerClosed {
+				logrus.WithError(err).Error("Error while serving admin API")
+			}
+		}()
+	}
+
+	if addr := cfg.Metrics.Address; len(addr) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		s := &http.Server{Addr: addr, Handler: mux}
+		servers = append(servers, s)
+
+		go func() {
+			logrus.Infof("Start metrics listening at %s", addr)
+			if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logrus.WithError(err).Error("Error while serving metrics")
+			}
+		}()
+	}
+
+	c := make(chan os.Signal, 1)
 	closingChan := make(chan interface{}, 1)
 
 	signal.Notify(c, os.Interrupt)
@@ -150,9 +573,11 @@ func app(args *cli.Context) error {
 	// Try to gracefully shut down the pending requests.
 	go func() {
 		logrus.Info("Waiting for connection to exit")
-		if err := s.Shutdown(ctx); err != nil {
-			logrus.WithError(err).Error("Error while shutting down server")
-			return
+		for _, s := range servers {
+			if err := s.Shutdown(ctx); err != nil {
+				logrus.WithError(err).Error("Error while shutting down server")
+				return
+			}
 		}
 
 		close(closingChan)
@@ -168,9 +593,113 @@ func app(args *cli.Context) error {
 		// We couldn't wait more time, and a second interrupt occurred
 		// to force stop the server.
 		cancel()
-		_ = s.Close()
+		for _, s := range servers {
+			_ = s.Close()
+		}
 		logrus.Info("Force shutdown.")
 	}
 
 	return nil
 }
+
+// adminServer creates the server of the admin API.
+func adminServer(a *config.AdminConfig, reloader *server.Reloader, registry *metrics.Registry, certificates map[string]certstore.Lister) (*http.Server, error) {
+	s := &http.Server{
+		Addr:    a.Address,
+		Handler: admin.NewHandler(reloader, admin.WithToken(a.Token), admin.WithMetrics(registry), admin.WithCertificates(certificates)),
+	}
+
+	if a.TLS != nil {
+		tlsConfig, err := admin.TLSConfig(a.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.TLSConfig = tlsConfig
+	}
+
+	return s, nil
+}
+
+// serveAdmin serves the admin API, with TLS when configured.
+func serveAdmin(s *http.Server, a *config.AdminConfig) error {
+	if a.TLS != nil {
+		return s.ListenAndServeTLS(a.TLS.Certificate, a.TLS.Key)
+	}
+	return s.ListenAndServe()
+}
+
+// requestIDHandler assigns the request IDs of the requests, configured
+// by the request_id section.
+func requestIDHandler(c config.RequestIDConfig, next http.Handler) http.Handler {
+	var opts []requestid.Option
+	if len(c.Header) > 0 {
+		opts = append(opts, requestid.WithHeader(c.Header))
+	}
+	if c.TrustIncoming {
+		opts = append(opts, requestid.WithTrustIncoming())
+	}
+	return requestid.NewHandler(next, opts...)
+}
+
+// setLogLevel applies the log level of the configuration.
+func setLogLevel(c *config.Config) {
+	if len(c.Log.Level) > 0 {
+		level, _ := logrus.ParseLevel(c.Log.Level)
+		logrus.SetLevel(level)
+	}
+}
+
+// validate checks the configuration file given as argument, or with
+// the "config" flag, and reports its errors with their line numbers.
+func validate(args *cli.Context) error {
+	path := args.Args().First()
+	if len(path) == 0 {
+		path = args.Path("config")
+	}
+
+	if len(path) == 0 {
+		return fmt.Errorf("a configuration file is required")
+	}
+
+	c, err := config.Load(path)
+	if err == nil {
+		err = c.Validate()
+	}
+
+	if errs, ok := err.(config.Errors); ok {
+		for _, e := range errs {
+			fmt.Fprintf(args.App.ErrWriter, "%s:%s\n", path, location(e))
+		}
+		return cli.Exit(fmt.Sprintf("%s: %d error(s)", path, len(errs)), 1)
+	}
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// The handler graph is built to check the referenced files.
+	graph, err := server.Build(c)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("%s: %v", path, err), 1)
+	}
+	graph.Close()
+
+	fmt.Fprintf(args.App.Writer, "%s: configuration is valid\n", path)
+	return nil
+}
+
+// location formats an error as "<line>:<column>: <path>: <message>".
+func location(e *config.Error) string {
+	var b strings.Builder
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		fmt.Fprintf(&b, "%d:%d:", e.Line, e.Column)
+	case e.Line > 0:
+		fmt.Fprintf(&b, "%d:", e.Line)
+	}
+	b.WriteString(" ")
+	if len(e.Path) > 0 {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
@@ -3,9 +3,14 @@ module github.com/moutoum/http-reverse-proxy
 go 1.15
 
 require (
+	github.com/andybalholm/brotli v1.0.1
+	github.com/klauspost/compress v1.11.4
+	github.com/pelletier/go-toml v1.9.5
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/objx v0.3.0 // indirect
 	github.com/stretchr/testify v1.6.1
 	github.com/urfave/cli/v2 v2.3.0
-	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/net v0.0.0-20201224014010-6772e930b67b
+	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -0,0 +1,73 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/requestid"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_AccessLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Cache-Control", "max-age=60")
+		_, _ = writer.Write([]byte("data"))
+	}))
+	defer upstream.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+upstreams:
+  api: {targets: [%q]}
+middleware:
+  cache: {}
+routes:
+  - path: /api
+    upstream: api
+access_log:
+  format: json
+  fields: [status, route, upstream_addr, cache_status, bytes_out, request_id]
+`, upstream.URL)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	graph, err := server.Build(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer graph.Close()
+
+	format, _, err := server.OpenAccessLog(c.AccessLog)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var out bytes.Buffer
+	h := accesslog.NewHandler(format, &out, requestid.NewHandler(graph, requestid.WithTrustIncoming()))
+	for i, path := range []string{"/api/data", "/api/data", "/unknown"} {
+		request := httptest.NewRequest("GET", path, nil)
+		request.Header.Set(requestid.DefaultHeader, fmt.Sprintf("req-%d", i))
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	target, _ := url.Parse(upstream.URL)
+	decoder := json.NewDecoder(&out)
+	for _, want := range []map[string]interface{}{
+		{"status": 200.0, "route": "/api", "upstream_addr": target.Host, "cache_status": "miss", "bytes_out": 4.0, "request_id": "req-0"},
+		{"status": 200.0, "route": "/api", "upstream_addr": "", "cache_status": "hit", "bytes_out": 4.0, "request_id": "req-1"},
+		{"status": 404.0, "route": "", "upstream_addr": "", "cache_status": "", "bytes_out": 32.0, "request_id": "req-2"},
+	} {
+		var line map[string]interface{}
+		if assert.NoError(t, decoder.Decode(&line)) {
+			assert.Equal(t, want, line)
+		}
+	}
+}
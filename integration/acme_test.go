@@ -0,0 +1,89 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_ACME obtains a certificate from a Pebble test CA, run with
+// PEBBLE_VA_ALWAYS_VALID=1 as its validation authority cannot reach the
+// test server. PEBBLE_DIRECTORY_URL is the Pebble directory (e.g.
+// https://localhost:14000/dir) and PEBBLE_CA_ROOT the certificate of its
+// HTTPS server (test/certs/pebble.minica.pem in the Pebble repository).
+func TestServer_ACME(t *testing.T) {
+	directoryURL, caRoot := os.Getenv("PEBBLE_DIRECTORY_URL"), os.Getenv("PEBBLE_CA_ROOT")
+	if len(directoryURL) == 0 || len(caRoot) == 0 {
+		t.Skip("PEBBLE_DIRECTORY_URL and PEBBLE_CA_ROOT are not set")
+	}
+
+	dir, err := ioutil.TempDir("", "acme")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+listeners:
+  - name: secure
+    address: :8443
+    tls:
+      acme:
+        domains: [acme.test]
+        email: admin@acme.test
+        directory_url: %q
+        ca_root: %q
+        storage: %q
+upstreams:
+  app: {targets: ["http://127.0.0.1:1"]}
+routes:
+  - upstream: app
+`, directoryURL, caRoot, dir)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	// certificate returns the certificate served for the domain by a
+	// new instance sharing the storage.
+	certificate := func() *x509.Certificate {
+		l, err := server.NewListenerTLS(c.Listeners[0].TLS)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+
+		s := httptest.NewUnstartedServer(http.NotFoundHandler())
+		s.TLS = l.Config()
+		s.StartTLS()
+		defer s.Close()
+
+		conn, err := tls.Dial("tcp", s.Listener.Addr().String(), &tls.Config{ServerName: "acme.test", InsecureSkipVerify: true})
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer conn.Close()
+
+		assert.Len(t, l.Certificates(), 1)
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+
+	issued := certificate()
+	if !assert.NotNil(t, issued) {
+		return
+	}
+	assert.Equal(t, []string{"acme.test"}, issued.DNSNames)
+	assert.NotEqual(t, issued.Subject.String(), issued.Issuer.String())
+
+	// The other instances load the stored certificate.
+	if loaded := certificate(); assert.NotNil(t, loaded) {
+		assert.Equal(t, issued.SerialNumber, loaded.SerialNumber)
+	}
+}
@@ -0,0 +1,95 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_StickyFallback(t *testing.T) {
+	named := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte(name))
+		}))
+	}
+	upstreams := map[string]*httptest.Server{"a": named("a"), "b": named("b")}
+	for _, s := range upstreams {
+		defer s.Close()
+	}
+
+	content := fmt.Sprintf(`
+upstreams:
+  default:
+    targets: [%q, %q]
+    load_balancing: {fail_timeout: 1m}
+routes:
+  - upstream: default
+    affinity: {cookie: session, secret: s3cr3t}
+`, upstreams["a"].URL, upstreams["b"].URL)
+	reloader, err := server.NewReloader(func() (*config.Config, error) {
+		c, err := config.Parse([]byte(content))
+		if err == nil {
+			err = c.Validate()
+		}
+		return c, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// get requests the route with the affinity cookie, if any, and
+	// returns the response status, body and new affinity cookie.
+	get := func(cookie *http.Cookie) (int, string, *http.Cookie) {
+		request := httptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		reloader.ServeHTTP(rec, request)
+
+		body, _ := ioutil.ReadAll(rec.Body)
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "session" {
+				return rec.Code, string(body), c
+			}
+		}
+		return rec.Code, string(body), nil
+	}
+
+	_, pinned, cookie := get(nil)
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+
+	// The sessions survive the reloads.
+	assert.NoError(t, reloader.Reload())
+	for i := 0; i < 3; i++ {
+		_, name, renewed := get(cookie)
+		assert.Equal(t, pinned, name)
+		assert.Nil(t, renewed)
+	}
+
+	// The failed upstream is skipped and the session is moved.
+	upstreams[pinned].Close()
+	status, _, _ := get(cookie)
+	assert.Equal(t, http.StatusBadGateway, status)
+
+	status, name, renewed := get(cookie)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotEqual(t, pinned, name)
+	if assert.NotNil(t, renewed) {
+		_, moved, _ := get(renewed)
+		assert.Equal(t, name, moved)
+	}
+
+	for _, u := range reloader.Graph().Pools()["default"].Upstreams() {
+		assert.Equal(t, u.URL.String() != upstreams[pinned].URL, u.Healthy(), u.URL.String())
+	}
+}
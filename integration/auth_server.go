@@ -0,0 +1,56 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+type AuthServer struct {
+	server *httptest.Server
+	tokens map[string]string
+}
+
+func NewAuthServer() *AuthServer {
+	a := &AuthServer{tokens: make(map[string]string)}
+	a.server = httptest.NewUnstartedServer(a)
+	return a
+}
+
+func (a *AuthServer) WithToken(token, user string) *AuthServer {
+	a.tokens[token] = user
+	return a
+}
+
+func (a *AuthServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	user, ok := a.tokens[request.Header.Get("Authorization")]
+	if ok {
+		writer.Header().Set("X-Auth-User", user)
+		writer.Header().Set("X-Auth-Method", request.Method)
+		writer.Header().Set("X-Auth-Uri", request.Header.Get("X-Forwarded-Uri"))
+		writer.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if request.Header.Get("Accept") == "text/html" {
+		http.Redirect(writer, request, "/login?rd="+url.QueryEscape(request.Header.Get("X-Forwarded-Uri")), http.StatusFound)
+		return
+	}
+
+	writer.Header().Set("WWW-Authenticate", `Bearer realm="test"`)
+	writer.WriteHeader(http.StatusUnauthorized)
+}
+
+func (a *AuthServer) Start() *AuthServer {
+	a.server.Start()
+	return a
+}
+
+func (a *AuthServer) Close() {
+	a.server.Close()
+}
+
+func (a *AuthServer) URL() *url.URL {
+	u, _ := url.Parse(a.server.URL)
+	return u
+}
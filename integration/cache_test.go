@@ -1,12 +1,17 @@
 package integration
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/compress"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -161,7 +166,9 @@ func TestCache_ClientControl(t *testing.T) {
 
 		t.Run("Available response", func(t *testing.T) {
 			// This call should store the response in cache.
-			if assert.HTTPSuccess(t, c.ServeHTTP, "GET", "/api/ok", nil) {
+			recorder := httptest.NewRecorder()
+			c.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/ok", nil))
+			if assert.Equal(t, http.StatusOK, recorder.Code) {
 				// And this one should use the cache.
 				recorder := httptest.NewRecorder()
 				req := httptest.NewRequest("GET", "/api/ok", nil)
@@ -239,4 +246,98 @@ func TestCache_ClientControl(t *testing.T) {
 	})
 
 	origin.AssertExpectations(t)
-}
\ No newline at end of file
+}
+
+func TestCache_CompressedVariants(t *testing.T) {
+	content := []byte(strings.Repeat("compressible content ", 200))
+	origin := NewMockHandler()
+	origin.DataRoute("/api/data", http.StatusOK, content, map[string]string{"Content-Type": "text/plain"}).Twice()
+
+	compressed, err := compress.NewHandler(origin)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	c := cache.NewHandler(cache.NewInMemoryCache(), compressed)
+
+	serve := func(acceptEncoding string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/data", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		c.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Each variant is fetched once from the origin server.
+	gzipped := serve("gzip")
+	assert.Equal(t, "gzip", gzipped.Header().Get("Content-Encoding"))
+	plain := serve("")
+	assert.Empty(t, plain.Header().Get("Content-Encoding"))
+	assert.Equal(t, content, plain.Body.Bytes())
+
+	// Then they are served from the cache.
+	recorder := serve("gzip")
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, gzipped.Body.Bytes(), recorder.Body.Bytes())
+	recorder = serve("")
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	origin.AssertExpectations(t)
+}
+
+func TestServer_CacheHosts(t *testing.T) {
+	// upstream returns its name, cacheable.
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Cache-Control", "public, max-age=60")
+			_, _ = fmt.Fprint(writer, name)
+		}))
+	}
+	a, b := upstream("a"), upstream("b")
+	defer a.Close()
+	defer b.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+upstreams:
+  a: {targets: [%q]}
+  b: {targets: [%q]}
+middleware:
+  cache: {}
+routes:
+  - name: tenant-a
+    host: a.example.com
+    path: /data
+    upstream: a
+  - name: tenant-b
+    host: b.example.com
+    path: /data
+    upstream: b
+`, a.URL, b.URL)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	graph, err := server.Build(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer graph.Close()
+
+	get := func(target string) string {
+		rec := httptest.NewRecorder()
+		graph.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	// Each host gets the response of its own upstream, from the cache
+	// the second time.
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "a", get("http://a.example.com/data"))
+		assert.Equal(t, "b", get("http://b.example.com/data"))
+	}
+	if inspector, ok := graph.Cache().(cache.Inspector); assert.True(t, ok) {
+		assert.Equal(t, 2, inspector.Stats().Entries)
+	}
+}
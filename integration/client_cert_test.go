@@ -0,0 +1,186 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/clientcert"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_ClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewCA("Internal CA")
+	if !assert.NoError(t, err) {
+		return
+	}
+	other, err := NewCA("Other CA")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, ca.WriteServerCertificate(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), time.Now().Add(time.Hour), "example.com"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.pem"), ca.PEM(), 0o600))
+
+	// clientCertificate issues a client certificate with a CA.
+	clientCertificate := func(ca *CA, name string, dnsNames ...string) tls.Certificate {
+		certPEM, keyPEM, err := ca.Issue(&x509.Certificate{Subject: pkix.Name{CommonName: name}, DNSNames: dnsNames})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+		return cert
+	}
+	billing := clientCertificate(ca, "billing", "billing.internal")
+	reports := clientCertificate(ca, "reports")
+	untrusted := clientCertificate(other, "billing")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		for _, name := range []string{"X-Client-Subject", "X-Client-Cert-Sans", "X-Client-Cert-Fingerprint"} {
+			writer.Header().Set("Received-"+name, request.Header.Get(name))
+		}
+	}))
+	defer upstream.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+listeners:
+  - name: web
+    address: :8080
+  - name: secure
+    address: :8443
+    tls:
+      certificate: %s
+      key: %s
+      client_auth:
+        ca: %s
+        headers: {subject: X-Client-Subject}
+  - name: internal
+    address: :9443
+    tls:
+      certificate: %s
+      key: %s
+      client_auth:
+        ca: %s
+        mode: required
+upstreams:
+  app: {targets: [%q]}
+routes:
+  - path: /billing
+    listeners: [secure, internal]
+    upstream: app
+    middleware:
+      client_cert: {sans: ["DNS:billing.internal"]}
+  - upstream: app
+`, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem"),
+		filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem"), upstream.URL)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	reloader, err := server.NewReloader(func() (*config.Config, error) { return c, nil })
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reloader.Close()
+
+	listeners := make(map[string]*httptest.Server)
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		s := httptest.NewUnstartedServer(server.ListenerHandler(c, l, reloader.Listener(l.Name)))
+		if l.TLS == nil {
+			s.Start()
+		} else {
+			lt, err := server.NewListenerTLS(l.TLS)
+			if !assert.NoError(t, err) {
+				return
+			}
+			s.TLS = lt.Config()
+			s.StartTLS()
+		}
+		defer s.Close()
+		listeners[l.Name] = s
+	}
+
+	// get requests a path of a listener with a client certificate, and
+	// a spoofed subject header. The certificate is sent even when its CA
+	// is not accepted by the server.
+	get := func(listener, path string, certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    ca.Pool(),
+				ServerName: "example.com",
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					if len(certs) == 0 {
+						return &tls.Certificate{}, nil
+					}
+					return &certs[0], nil
+				},
+			},
+		}}
+
+		request, _ := http.NewRequest("GET", listeners[listener].URL+path, nil)
+		request.Header.Set("X-Client-Subject", "CN=admin")
+		response, err := client.Do(request)
+		if err == nil {
+			_ = response.Body.Close()
+		}
+		return response, err
+	}
+
+	// The plaintext listener removes the headers of the other listeners.
+	if response, err := get("web", "/"); assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, response.Header.Get("Received-X-Client-Subject"))
+	}
+
+	// The optional client certificates are required by the /billing
+	// route, and forwarded by the secure listener.
+	if response, err := get("secure", "/"); assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, response.Header.Get("Received-X-Client-Subject"))
+	}
+	if response, err := get("secure", "/billing"); assert.NoError(t, err) {
+		assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	}
+	if response, err := get("secure", "/billing", reports); assert.NoError(t, err) {
+		assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	}
+	if response, err := get("secure", "/billing", billing); assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "CN=billing", response.Header.Get("Received-X-Client-Subject"))
+		assert.Equal(t, "DNS:billing.internal", response.Header.Get("Received-X-Client-Cert-Sans"))
+		assert.Equal(t, clientcert.Fingerprint(billing.Leaf), response.Header.Get("Received-X-Client-Cert-Fingerprint"))
+	}
+
+	// The certificates of other CAs are refused.
+	_, err = get("secure", "/", untrusted)
+	assert.Error(t, err)
+
+	// The internal listener requires a certificate for every route.
+	_, err = get("internal", "/")
+	assert.Error(t, err)
+	if response, err := get("internal", "/", reports); assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, clientcert.Fingerprint(reports.Leaf), response.Header.Get("Received-X-Client-Cert-Fingerprint"))
+	}
+}
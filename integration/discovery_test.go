@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/discovery"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy_FileDiscovery(t *testing.T) {
+	block := make(chan struct{})
+	first := newRecordingServer(http.StatusOK, block)
+	defer first.Close()
+	second := newRecordingServer(http.StatusCreated, nil)
+	defer second.Close()
+
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "upstreams.json")
+	write := func(u string, modTime time.Time) {
+		content := fmt.Sprintf(`{"groups": {"default": [%q]}}`, u)
+		if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(path, modTime, modTime)
+	}
+	write(first.URL().String(), time.Now().Add(-time.Minute))
+
+	provider := discovery.NewFile(path, 10*time.Millisecond)
+	groups, err := provider.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := proxy.NewPool()
+	pools := discovery.NewPools().Add("default", pool)
+	pools.Apply(groups)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go provider.Watch(stop, pools.Apply)
+
+	handler := proxy.NewBalanced(pool)
+
+	// A request is in-flight on the first upstream while it is removed.
+	inFlight := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/in-flight", nil))
+		inFlight <- rec.Code
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	write(second.URL().String(), time.Now())
+
+	assert.Eventually(t, func() bool {
+		upstreams := pool.Upstreams()
+		return len(upstreams) == 1 && upstreams[0].URL.String() == second.URL().String()
+	}, time.Second, 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/after", nil))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	close(block)
+	assert.Equal(t, http.StatusOK, <-inFlight)
+	assert.Equal(t, []string{"GET /in-flight "}, first.Bodies())
+	assert.Equal(t, []string{"GET /after "}, second.Bodies())
+}
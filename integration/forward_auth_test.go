@@ -0,0 +1,70 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/forwardauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardAuth(t *testing.T) {
+	authServer := NewAuthServer().WithToken("valid-token", "alice").Start()
+	defer authServer.Close()
+
+	var forwarded *http.Request
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forwarded = request
+	})
+
+	h := forwardauth.NewHandler(authServer.URL(), origin,
+		forwardauth.WithRequestHeaders("Authorization", "Accept"),
+		forwardauth.WithResponseHeaders("X-Auth-User", "X-Auth-Method", "X-Auth-Uri"),
+	)
+
+	serve := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		forwarded = nil
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Authorized request", func(t *testing.T) {
+		recorder := serve("POST", "/api/data?q=1", map[string]string{
+			"Authorization": "valid-token",
+			"X-Auth-User":   "spoofed",
+		})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		if assert.NotNil(t, forwarded) {
+			assert.Equal(t, []string{"alice"}, forwarded.Header.Values("X-Auth-User"))
+			assert.Equal(t, "POST", forwarded.Header.Get("X-Auth-Method"))
+			assert.Equal(t, "/api/data?q=1", forwarded.Header.Get("X-Auth-Uri"))
+		}
+	})
+
+	t.Run("Unauthorized request", func(t *testing.T) {
+		recorder := serve("GET", "/api/data", nil)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, `Bearer realm="test"`, recorder.Header().Get("WWW-Authenticate"))
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Login redirect", func(t *testing.T) {
+		recorder := serve("GET", "/dashboard", map[string]string{"Accept": "text/html"})
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		assert.Equal(t, "/login?rd=%2Fdashboard", recorder.Header().Get("Location"))
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Unreachable auth server", func(t *testing.T) {
+		h := forwardauth.NewHandler(&url.URL{Scheme: "http", Host: "127.0.0.1:1"}, origin)
+		assert.HTTPStatusCode(t, h.ServeHTTP, "GET", "/api/data", nil, http.StatusBadGateway)
+	})
+}
@@ -0,0 +1,93 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Listeners(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Strict-Transport-Security", "max-age=0")
+		_, _ = fmt.Fprint(writer, request.URL.Path)
+	}))
+	defer upstream.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+listeners:
+  - name: web
+    address: :8080
+    redirect: {port: 8443}
+  - name: secure
+    address: :8443
+    tls: {certificate: cert.pem, key: key.pem}
+    hsts: {max_age: 8760h, include_subdomains: true}
+  - name: internal
+    address: 127.0.0.1:9000
+upstreams:
+  app: {targets: [%q]}
+routes:
+  - path: /internal
+    upstream: app
+    listeners: [internal]
+  - path: /
+    upstream: app
+    listeners: [secure]
+`, upstream.URL)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	graph, err := server.Build(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer graph.Close()
+
+	handlers := make(map[string]http.Handler)
+	for i := range c.Listeners {
+		handlers[c.Listeners[i].Name] = server.ListenerHandler(c, &c.Listeners[i], graph.Listener(c.Listeners[i].Name))
+	}
+
+	serve := func(listener, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handlers[listener].ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec
+	}
+
+	redirects := []struct {
+		target   string
+		location string
+	}{
+		{target: "http://example.com:8080/items?page=2", location: "https://example.com:8443/items?page=2"},
+		{target: "http://example.com/items", location: "https://example.com:8443/items"},
+		{target: "http://[::1]:8080/items", location: "https://[::1]:8443/items"},
+		{target: "http://[::1]/items", location: "https://[::1]:8443/items"},
+	}
+	for _, r := range redirects {
+		rec := serve("web", r.target)
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code, r.target)
+		assert.Equal(t, r.location, rec.Header().Get("Location"), r.target)
+	}
+
+	rec := serve("secure", "https://example.com/items")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "/items", rec.Body.String())
+	assert.Equal(t, "max-age=31536000; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+
+	assert.Equal(t, http.StatusNotFound, serve("internal", "http://127.0.0.1:9000/items").Code)
+
+	rec = serve("internal", "http://127.0.0.1:9000/internal/stats")
+	assert.Equal(t, "/internal/stats", rec.Body.String())
+	assert.Equal(t, "max-age=0", rec.Header().Get("Strict-Transport-Security"))
+
+	// The graph itself serves the routes of all the listeners.
+	rec = httptest.NewRecorder()
+	graph.ServeHTTP(rec, httptest.NewRequest("GET", "/internal/stats", nil))
+	assert.Equal(t, "/internal/stats", rec.Body.String())
+}
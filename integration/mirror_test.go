@@ -0,0 +1,218 @@
+package integration
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	bodies []string
+	block  chan struct{}
+}
+
+func newRecordingServer(status int, block chan struct{}) *recordingServer {
+	r := &recordingServer{block: block}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if r.block != nil {
+			<-r.block
+		}
+
+		body, _ := ioutil.ReadAll(request.Body)
+		r.mu.Lock()
+		r.bodies = append(r.bodies, request.Method+" "+request.URL.RequestURI()+" "+string(body))
+		r.mu.Unlock()
+
+		writer.WriteHeader(status)
+	}))
+
+	return r
+}
+
+func (r *recordingServer) Bodies() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+func (r *recordingServer) URL() *url.URL {
+	u, _ := url.Parse(r.Server.URL)
+	return u
+}
+
+func TestProxy_Mirror(t *testing.T) {
+	t.Run("Mirror all requests", func(t *testing.T) {
+		primary := newRecordingServer(http.StatusOK, nil)
+		defer primary.Close()
+		shadow := newRecordingServer(http.StatusInternalServerError, nil)
+		defer shadow.Close()
+
+		p := proxy.New(primary.URL(), proxy.WithMirror(proxy.MirrorConfig{Target: shadow.URL(), Percentage: 100}))
+
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/data?q=1", strings.NewReader("payload")))
+
+		// The shadow response is discarded.
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, []string{"POST /api/data?q=1 payload"}, primary.Bodies())
+		assert.Eventually(t, func() bool {
+			return len(shadow.Bodies()) == 1 && shadow.Bodies()[0] == "POST /api/data?q=1 payload"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Mirror no request", func(t *testing.T) {
+		primary := newRecordingServer(http.StatusOK, nil)
+		defer primary.Close()
+		shadow := newRecordingServer(http.StatusOK, nil)
+		defer shadow.Close()
+
+		p := proxy.New(primary.URL(), proxy.WithMirror(proxy.MirrorConfig{Target: shadow.URL(), Percentage: 0}))
+		for i := 0; i < 10; i++ {
+			assert.HTTPSuccess(t, p.ServeHTTP, "GET", "/api/data", nil)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Len(t, primary.Bodies(), 10)
+		assert.Empty(t, shadow.Bodies())
+	})
+
+	t.Run("Slow shadow does not slow down the primary", func(t *testing.T) {
+		block := make(chan struct{})
+		primary := newRecordingServer(http.StatusOK, nil)
+		defer primary.Close()
+		shadow := newRecordingServer(http.StatusOK, block)
+		defer shadow.Close()
+		defer close(block)
+
+		p := proxy.New(primary.URL(), proxy.WithMirror(proxy.MirrorConfig{
+			Target:        shadow.URL(),
+			Percentage:    100,
+			MaxConcurrent: 2,
+		}))
+
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			assert.HTTPSuccess(t, p.ServeHTTP, "GET", "/api/data", nil)
+		}
+
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+		assert.Len(t, primary.Bodies(), 10)
+	})
+
+	t.Run("Saturated mirror streams the body", func(t *testing.T) {
+		block := make(chan struct{})
+		shadow := newRecordingServer(http.StatusOK, block)
+		defer shadow.Close()
+		defer close(block)
+
+		// The primary receives the request before the client sends its
+		// body, which cannot be buffered first.
+		received := make(chan struct{})
+		primary := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == "POST" {
+				close(received)
+			}
+			body, _ := ioutil.ReadAll(request.Body)
+			_, _ = writer.Write(body)
+		}))
+		defer primary.Close()
+		target, _ := url.Parse(primary.URL)
+
+		p := proxy.New(target, proxy.WithMirror(proxy.MirrorConfig{
+			Target:        shadow.URL(),
+			Percentage:    100,
+			MaxConcurrent: 1,
+		}))
+
+		// Takes the only slot.
+		assert.HTTPSuccess(t, p.ServeHTTP, "GET", "/api/data", nil)
+
+		reader, writer := io.Pipe()
+		go func() {
+			<-received
+			_, _ = writer.Write([]byte("streamed"))
+			_ = writer.Close()
+		}()
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/data", reader))
+			done <- recorder
+		}()
+
+		select {
+		case recorder := <-done:
+			assert.Equal(t, "streamed", recorder.Body.String())
+		case <-time.After(2 * time.Second):
+			_ = writer.Close()
+			t.Fatal("the body was buffered")
+		}
+	})
+
+	t.Run("Large bodies are not mirrored", func(t *testing.T) {
+		primary := newRecordingServer(http.StatusOK, nil)
+		defer primary.Close()
+		shadow := newRecordingServer(http.StatusOK, nil)
+		defer shadow.Close()
+
+		p := proxy.New(primary.URL(), proxy.WithMirror(proxy.MirrorConfig{
+			Target:      shadow.URL(),
+			Percentage:  100,
+			MaxBodySize: 4,
+		}))
+
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/data", strings.NewReader("large payload")))
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, []string{"POST /api/data large payload"}, primary.Bodies())
+		assert.Empty(t, shadow.Bodies())
+	})
+}
+
+func TestProxy_MirrorCompare(t *testing.T) {
+	primary := NewTargetServer().
+		WithRouteContent("/api/same", http.StatusOK, []byte(`{"id": 1, "date": "today"}`)).
+		WithRouteContent("/api/different", http.StatusOK, []byte(`{"id": 1}`)).
+		Start()
+	defer primary.Close()
+
+	shadow := NewTargetServer().
+		WithRouteContent("/api/same", http.StatusOK, []byte(`{"date": "tomorrow", "id": 1}`)).
+		WithRouteContent("/api/different", http.StatusOK, []byte(`{"id": 2}`)).
+		Start()
+	defer shadow.Close()
+
+	p := proxy.New(primary.URL(), proxy.WithMirror(proxy.MirrorConfig{
+		Target:     shadow.URL(),
+		Percentage: 100,
+		Compare: &proxy.CompareConfig{
+			IgnorePaths: []string{"date"},
+			Routes:      []string{"/api/same", "/api/different"},
+		},
+	}))
+
+	assert.HTTPBodyContains(t, p.ServeHTTP, "GET", "/api/same", nil, `"today"`)
+	assert.HTTPBodyContains(t, p.ServeHTTP, "GET", "/api/different", nil, `{"id": 1}`)
+
+	assert.Eventually(t, func() bool {
+		return reflect.DeepEqual(p.MirrorStats(), map[string]proxy.MirrorStats{
+			"/api/same":      {Matches: 1},
+			"/api/different": {Mismatches: 1},
+		})
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,182 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Reload(t *testing.T) {
+	block := make(chan struct{})
+	first := newRecordingServer(http.StatusOK, block)
+	defer first.Close()
+	second := newRecordingServer(http.StatusCreated, nil)
+	defer second.Close()
+
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxy.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	routeTo := func(u fmt.Stringer) string {
+		return fmt.Sprintf("upstreams:\n  default: {targets: [%q]}\nroutes:\n  - upstream: default\n", u)
+	}
+	write(routeTo(first.URL()))
+
+	reloader, err := server.NewReloader(func() (*config.Config, error) {
+		return config.Load(path)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// A request is in-flight on the first graph while it is replaced.
+	inFlight := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		reloader.ServeHTTP(rec, httptest.NewRequest("GET", "/in-flight", nil))
+		inFlight <- rec.Code
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	write(routeTo(second.URL()))
+	assert.NoError(t, reloader.Reload())
+
+	assert.HTTPStatusCode(t, reloader.ServeHTTP, "GET", "/after", nil, http.StatusCreated)
+
+	close(block)
+	assert.Equal(t, http.StatusOK, <-inFlight)
+	assert.Equal(t, []string{"GET /in-flight "}, first.Bodies())
+
+	t.Run("Failed reload", func(t *testing.T) {
+		write("routes:\n  - upstream: unknown\n")
+		assert.Error(t, reloader.Reload())
+
+		write("routes: [")
+		assert.Error(t, reloader.Reload())
+
+		assert.HTTPStatusCode(t, reloader.ServeHTTP, "GET", "/kept", nil, http.StatusCreated)
+		assert.Equal(t, []string{"GET /after ", "GET /kept "}, second.Bodies())
+	})
+}
+
+func TestServer_WatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxy.yaml")
+	if err := ioutil.WriteFile(path, []byte("routes: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go server.WatchFile(path, 10*time.Millisecond, stop, func() {
+		changed <- struct{}{}
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatal("unexpected change")
+	default:
+	}
+
+	if err := ioutil.WriteFile(path, []byte("routes: [{}]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+}
+
+func TestServer_ReloadKeepsDrained(t *testing.T) {
+	content := "upstreams:\n  default: {targets: [\"http://10.0.0.1\", \"http://10.0.0.2\"]}\nroutes:\n  - upstream: default\n"
+	reloader, err := server.NewReloader(func() (*config.Config, error) {
+		return config.Parse([]byte(content))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	reloader.Graph().Pools()["default"].Upstreams()[1].SetDrained(true)
+
+	content = "upstreams:\n  default: {targets: [\"http://10.0.0.2\", \"http://10.0.0.3\"]}\nroutes:\n  - upstream: default\n"
+	if assert.NoError(t, reloader.Reload()) {
+		upstreams := reloader.Graph().Pools()["default"].Upstreams()
+		assert.True(t, upstreams[0].Drained())
+		assert.False(t, upstreams[1].Drained())
+	}
+}
+
+func TestServer_ReloadTracer(t *testing.T) {
+	block := make(chan struct{})
+	upstream := newRecordingServer(http.StatusOK, block)
+	defer upstream.Close()
+
+	otlp := newCollector()
+	defer otlp.Close()
+
+	service := "before"
+	reloader, err := server.NewReloader(func() (*config.Config, error) {
+		return config.Parse([]byte(fmt.Sprintf(`
+upstreams:
+  default: {targets: [%q]}
+routes:
+  - upstream: default
+tracing:
+  exporter: otlp
+  endpoint: %s/v1/traces
+  service_name: %s
+`, upstream.URL(), otlp.URL, service)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The request is in-flight while the tracer is replaced.
+	inFlight := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		reloader.ServeHTTP(rec, httptest.NewRequest("GET", "/in-flight", nil))
+		inFlight <- rec.Code
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	service = "after"
+	assert.NoError(t, reloader.Reload())
+
+	close(block)
+	assert.Equal(t, http.StatusOK, <-inFlight)
+	reloader.Close()
+
+	// The spans of the in-flight request are exported by the previous
+	// tracer, closed once the request is done.
+	assert.Len(t, otlp.Spans(), 2)
+	assert.Equal(t, []string{"before"}, otlp.services)
+}
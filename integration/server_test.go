@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Routes(t *testing.T) {
+	api := NewTargetServer().
+		WithRouteContent("/api/data", http.StatusOK, []byte("api")).
+		Start()
+	defer api.Close()
+
+	web := NewTargetServer().
+		WithRouteContent("/", http.StatusOK, []byte("web")).
+		Start()
+	defer web.Close()
+
+	admin := NewTargetServer().
+		WithRouteContent("/", http.StatusOK, []byte("admin")).
+		Start()
+	defer admin.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+upstreams:
+  api: {targets: [%q]}
+  web: {targets: [%q]}
+  admin: {targets: [%q]}
+middleware:
+  cors:
+    allowed_origins: ["https://example.com"]
+routes:
+  - path: /
+    upstream: web
+  - path: /api
+    upstream: api
+    middleware:
+      cors: {disabled: true}
+  - host: admin.example.com
+    upstream: admin
+`, api.URL(), web.URL(), admin.URL())))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	graph, err := server.Build(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+	graph.Start()
+	defer graph.Close()
+
+	assert.Equal(t, []string{"admin.example.com/", "/api", "/"}, routeNames(graph))
+
+	tests := []struct {
+		name   string
+		host   string
+		path   string
+		status int
+		body   string
+		cors   bool
+	}{
+		{name: "Longest prefix", path: "/api/data", status: http.StatusOK, body: "api"},
+		{name: "Default route", path: "/index.html", status: http.StatusOK, body: "web", cors: true},
+		{name: "Host", host: "ADMIN.example.com:8080", path: "/api/data", status: http.StatusOK, body: "admin", cors: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if len(tt.host) > 0 {
+				request.Host = tt.host
+			}
+			request.Header.Set("Origin", "https://example.com")
+
+			rec := httptest.NewRecorder()
+			graph.ServeHTTP(rec, request)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.body, rec.Body.String())
+			assert.Equal(t, tt.cors, len(rec.Header().Get("Access-Control-Allow-Origin")) > 0)
+		})
+	}
+}
+
+func TestServer_NotFound(t *testing.T) {
+	c, err := config.Parse([]byte(`
+upstreams:
+  api: {targets: ["http://127.0.0.1:1"]}
+routes:
+  - path: /api
+    upstream: api
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	graph, err := server.Build(c)
+	if assert.NoError(t, err) {
+		defer graph.Close()
+		assert.HTTPStatusCode(t, graph.ServeHTTP, "GET", "/index.html", nil, http.StatusNotFound)
+	}
+}
+
+func routeNames(graph *server.Graph) []string {
+	var names []string
+	for _, route := range graph.Routes() {
+		names = append(names, route.Name)
+	}
+	return names
+}
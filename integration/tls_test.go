@@ -0,0 +1,148 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/admin"
+	"github.com/moutoum/http-reverse-proxy/pkg/certstore"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/metrics"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_CertificateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := NewCA("Test CA")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	certs := filepath.Join(dir, "certs")
+	assert.NoError(t, os.Mkdir(certs, 0o700))
+	assert.NoError(t, ca.WriteServerCertificate(filepath.Join(dir, "main.crt"), filepath.Join(dir, "main.key"), expiry, "example.com"))
+	assert.NoError(t, ca.WriteServerCertificate(filepath.Join(certs, "api.crt"), filepath.Join(certs, "api.key"), expiry, "api.example.com"))
+	assert.NoError(t, ca.WriteServerCertificate(filepath.Join(certs, "wildcard.crt"), filepath.Join(certs, "wildcard.key"), expiry, "*.example.com"))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = fmt.Fprint(writer, request.Host)
+	}))
+	defer upstream.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+listeners:
+  - name: secure
+    address: :8443
+    tls:
+      certificate: %s
+      key: %s
+      directory: %s
+      reload_interval: 10ms
+upstreams:
+  app: {targets: [%q]}
+routes:
+  - upstream: app
+`, filepath.Join(dir, "main.crt"), filepath.Join(dir, "main.key"), certs, upstream.URL)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	reloader, err := server.NewReloader(func() (*config.Config, error) { return c, nil })
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reloader.Close()
+
+	l := &c.Listeners[0]
+	store, err := server.CertificateStore(l.TLS)
+	if !assert.NoError(t, err) {
+		return
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go store.Watch(l.TLS.ReloadInterval, stop)
+
+	s := httptest.NewUnstartedServer(server.ListenerHandler(c, l, reloader.Listener(l.Name)))
+	s.TLS = &tls.Config{GetCertificate: store.GetCertificate}
+	s.StartTLS()
+	defer s.Close()
+
+	// certificate returns the names of the certificate served for a
+	// host, after checking it with the CA.
+	certificate := func(host string) []string {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.Pool()},
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, s.Listener.Addr().String())
+			},
+		}}
+
+		response, err := client.Get("https://" + host + "/")
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer response.Body.Close()
+
+		body, _ := ioutil.ReadAll(response.Body)
+		assert.Equal(t, host, string(body))
+		return response.TLS.PeerCertificates[0].DNSNames
+	}
+
+	assert.Equal(t, []string{"example.com"}, certificate("example.com"))
+	assert.Equal(t, []string{"api.example.com"}, certificate("api.example.com"))
+	assert.Equal(t, []string{"*.example.com"}, certificate("www.example.com"))
+
+	// A renewed certificate is served without restart.
+	renewed := expiry.Add(24 * time.Hour)
+	assert.NoError(t, ca.WriteServerCertificate(filepath.Join(certs, "www.crt"), filepath.Join(certs, "www.key"), renewed, "www.example.com"))
+	assert.Eventually(t, func() bool {
+		return len(store.Certificates()) == 4
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"www.example.com"}, certificate("www.example.com"))
+
+	stores := map[string]certstore.Lister{l.Name: store}
+
+	h := admin.NewHandler(reloader, admin.WithCertificates(stores))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/certificates", nil))
+
+	var certificates []struct {
+		Listener string
+		File     string
+		Names    []string
+		NotAfter time.Time `json:"not_after"`
+		Default  bool
+	}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &certificates)) && assert.Len(t, certificates, 4) {
+		assert.Equal(t, "secure", certificates[0].Listener)
+		assert.Equal(t, filepath.Join(dir, "main.crt"), certificates[0].File)
+		assert.True(t, certificates[0].Default)
+		assert.Equal(t, []string{"www.example.com"}, certificates[3].Names)
+		assert.True(t, renewed.Equal(certificates[3].NotAfter))
+	}
+
+	registry := metrics.NewRegistry()
+	metrics.New(registry).WatchCertificates(stores)
+	var b bytes.Buffer
+	_, _ = registry.WriteTo(&b)
+	assert.Contains(t, b.String(), fmt.Sprintf("tls_certificate_expiry_timestamp_seconds{listener=\"secure\",file=%q,names=\"api.example.com\"} %g\n",
+		filepath.Join(certs, "api.crt"), float64(expiry.Unix())))
+}
@@ -0,0 +1,147 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+// collectedSpan is the part of the OTLP spans checked by the tests.
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+}
+
+// collector is an OpenTelemetry collector stand-in, receiving the
+// OTLP/HTTP JSON traces.
+type collector struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	services []string
+	spans    []collectedSpan
+}
+
+func newCollector() *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []struct {
+						Key   string
+						Value struct{ StringValue string }
+					}
+				}
+				ScopeSpans []struct {
+					Spans []collectedSpan
+				}
+			}
+		}
+
+		if request.URL.Path != "/v1/traces" || json.NewDecoder(request.Body).Decode(&body) != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, rs := range body.ResourceSpans {
+			for _, attribute := range rs.Resource.Attributes {
+				if attribute.Key == "service.name" {
+					c.services = append(c.services, attribute.Value.StringValue)
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+
+	return c
+}
+
+func (c *collector) Spans() []collectedSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]collectedSpan(nil), c.spans...)
+}
+
+func TestServer_Tracing(t *testing.T) {
+	var upstreamHeaders http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		upstreamHeaders = request.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	otlp := newCollector()
+	defer otlp.Close()
+
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+upstreams:
+  api: {targets: [%q]}
+middleware:
+  cache: {}
+routes:
+  - path: /api
+    upstream: api
+tracing:
+  exporter: otlp
+  endpoint: %s/v1/traces
+  service_name: edge
+`, upstream.URL, otlp.URL)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	tracer, err := server.NewTracer(c.Tracing)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	graph, err := server.Build(c, server.WithTracer(tracer))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer graph.Close()
+
+	const clientTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest("GET", "/api/data", nil)
+	request.Header.Set("traceparent", "00-"+clientTrace+"-00f067aa0ba902b7-01")
+	graph.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.NoError(t, tracer.Close())
+
+	spans := otlp.Spans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+
+	byName := make(map[string]collectedSpan)
+	for _, span := range spans {
+		assert.Equal(t, clientTrace, span.TraceID)
+		byName[span.Name] = span
+	}
+
+	serverSpan, lookup, client := byName["GET /api"], byName["cache lookup"], byName["upstream GET"]
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.ParentSpanID)
+	assert.Equal(t, int(tracing.SpanKindServer), serverSpan.Kind)
+	assert.Equal(t, serverSpan.SpanID, lookup.ParentSpanID)
+	assert.Equal(t, serverSpan.SpanID, client.ParentSpanID)
+	assert.Equal(t, int(tracing.SpanKindClient), client.Kind)
+
+	assert.Equal(t, "00-"+clientTrace+"-"+client.SpanID+"-01", upstreamHeaders.Get("traceparent"))
+	assert.Equal(t, client.SpanID, upstreamHeaders.Get("X-B3-SpanId"))
+	assert.Equal(t, []string{"edge"}, otlp.services)
+}
@@ -0,0 +1,90 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_UnixSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// The upstream only listens on a Unix socket.
+	appSocket := filepath.Join(dir, "app.sock")
+	appListener, err := net.Listen("unix", appSocket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	app := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = fmt.Fprintf(writer, "%s %s", request.Host, request.URL.RequestURI())
+	})}
+	go func() { _ = app.Serve(appListener) }()
+	defer app.Close()
+
+	proxySocket := filepath.Join(dir, "proxy.sock")
+	c, err := config.Parse([]byte(fmt.Sprintf(`
+listeners:
+  - address: unix://%s
+    socket_mode: "0600"
+upstreams:
+  app: {targets: ["unix://%s"]}
+routes:
+  - path: /
+    upstream: app
+`, proxySocket, appSocket)))
+	if !assert.NoError(t, err) || !assert.NoError(t, c.Validate()) {
+		return
+	}
+
+	graph, err := server.Build(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer graph.Close()
+
+	ln, err := server.Listen(&c.Listeners[0])
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := &http.Server{Handler: graph}
+	go func() { _ = s.Serve(ln) }()
+	defer s.Close()
+
+	info, err := os.Stat(proxySocket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", proxySocket)
+		},
+	}}
+
+	response, err := client.Get("http://example.com/items?page=2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "example.com /items?page=2", string(body))
+
+	// The socket of a running listener is not replaced.
+	_, err = server.Listen(&c.Listeners[0])
+	assert.EqualError(t, err, "listen unix "+proxySocket+": address already in use")
+}
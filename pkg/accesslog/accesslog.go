@@ -0,0 +1,214 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is the access log record of a request. The handlers behind
+// the middleware complete it through the request context.
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	URI        string
+	Proto      string
+	Host       string
+	Referer    string
+	UserAgent  string
+	Status     int
+	BytesIn    int64
+	BytesOut   int64
+	Duration   time.Duration
+	TLSVersion string
+
+	// mu protects the fields set by the inner handlers, which may run
+	// in other goroutines.
+	mu              sync.Mutex
+	requestID       string
+	route           string
+	upstreamAddr    string
+	upstreamLatency time.Duration
+	cacheStatus     string
+}
+
+// entryKey is the context key of the entry.
+type entryKey struct{}
+
+// fromContext returns the entry of the request context, or nil.
+func fromContext(ctx context.Context) *Entry {
+	entry, _ := ctx.Value(entryKey{}).(*Entry)
+	return entry
+}
+
+// SetRequestID records the ID of the request.
+func SetRequestID(ctx context.Context, id string) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		e.requestID = id
+		e.mu.Unlock()
+	}
+}
+
+// SetRoute records the route handling the request.
+func SetRoute(ctx context.Context, route string) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		e.route = route
+		e.mu.Unlock()
+	}
+}
+
+// SetUpstream records the upstream address, and the time it took to
+// answer with the response headers.
+func SetUpstream(ctx context.Context, addr string, latency time.Duration) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		e.upstreamAddr, e.upstreamLatency = addr, latency
+		e.mu.Unlock()
+	}
+}
+
+// SetCacheStatus records the cache lookup status (e.g. "hit").
+func SetCacheStatus(ctx context.Context, status string) {
+	if e := fromContext(ctx); e != nil {
+		e.mu.Lock()
+		e.cacheStatus = status
+		e.mu.Unlock()
+	}
+}
+
+// Handler is a http.Handler writing an access log line for each
+// request.
+type Handler struct {
+	format Format
+	out    io.Writer
+	next   http.Handler
+
+	// mu serializes the writes of the lines.
+	mu sync.Mutex
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler creates an access log middleware writing the lines in
+// the given format to out.
+func NewHandler(format Format, out io.Writer, next http.Handler) *Handler {
+	return &Handler{format: format, out: out, next: next}
+}
+
+// ServeHTTP is the `http.Handler` interface implementation.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	entry := &Entry{
+		Time:       time.Now(),
+		RemoteAddr: request.RemoteAddr,
+		Method:     request.Method,
+		URI:        request.RequestURI,
+		Proto:      request.Proto,
+		Host:       request.Host,
+		Referer:    request.Referer(),
+		UserAgent:  request.UserAgent(),
+	}
+	if len(entry.URI) == 0 {
+		entry.URI = request.URL.RequestURI()
+	}
+	if request.TLS != nil {
+		entry.TLSVersion = tlsVersion(request.TLS.Version)
+	}
+
+	body := &countingBody{ReadCloser: request.Body}
+	if request.Body != nil && request.Body != http.NoBody {
+		request.Body = body
+	}
+
+	w := &responseWriter{ResponseWriter: writer}
+	h.next.ServeHTTP(w, request.WithContext(context.WithValue(request.Context(), entryKey{}, entry)))
+
+	entry.Duration = time.Since(entry.Time)
+	entry.Status = w.status
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	entry.BytesIn = body.n
+	entry.BytesOut = w.n
+
+	entry.mu.Lock()
+	line := h.format.Format(entry)
+	entry.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.out.Write(line); err != nil {
+		logrus.WithContext(request.Context()).WithError(err).Error("Error while writing access log")
+	}
+}
+
+// tlsVersion returns the name of a TLS version.
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	}
+	return "0x" + strconv.FormatUint(uint64(version), 16)
+}
+
+// countingBody counts the bytes read from the request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read is the `io.Reader` interface implementation.
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the status code and the size of the
+// response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+// WriteHeader is the "http.ResponseWriter" interface implementation.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write is the "http.ResponseWriter" interface implementation.
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush is the "http.Flusher" interface implementation.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,204 @@
+package accesslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEntry() *Entry {
+	return &Entry{
+		Time:            time.Date(2020, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		RemoteAddr:      "127.0.0.1:51234",
+		Method:          "GET",
+		URI:             "/apache_pb.gif?x=1",
+		Proto:           "HTTP/1.1",
+		Host:            "example.com",
+		Referer:         "http://www.example.com/start.html",
+		UserAgent:       "Mozilla/4.08",
+		Status:          200,
+		BytesIn:         0,
+		BytesOut:        2326,
+		Duration:        1500 * time.Millisecond,
+		requestID:       "abc",
+		route:           "api",
+		upstreamAddr:    "10.0.0.1:8080",
+		upstreamLatency: 250 * time.Millisecond,
+		cacheStatus:     "miss",
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		fields []string
+		want   string
+	}{
+		{
+			name:   "common",
+			format: "common",
+			want:   `127.0.0.1 - - [10/Oct/2020:13:55:36 -0700] "GET /apache_pb.gif?x=1 HTTP/1.1" 200 2326` + "\n",
+		},
+		{
+			name:   "combined",
+			format: "combined",
+			want:   `127.0.0.1 - - [10/Oct/2020:13:55:36 -0700] "GET /apache_pb.gif?x=1 HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"` + "\n",
+		},
+		{
+			name:   "combined with fields",
+			format: "",
+			fields: []string{"upstream_addr", "cache_status", "tls_version"},
+			want:   `127.0.0.1 - - [10/Oct/2020:13:55:36 -0700] "GET /apache_pb.gif?x=1 HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08" upstream_addr="10.0.0.1:8080" cache_status="miss" tls_version="-"` + "\n",
+		},
+		{
+			name:   "json",
+			format: "json",
+			fields: []string{"status", "upstream_latency", "route", "request_id"},
+			want:   `{"status":200,"upstream_latency":0.25,"route":"api","request_id":"abc"}` + "\n",
+		},
+		{
+			name:   "logfmt",
+			format: "logfmt",
+			fields: []string{"method", "uri", "duration", "user_agent", "tls_version"},
+			want:   `method=GET uri="/apache_pb.gif?x=1" duration=1.5 user_agent=Mozilla/4.08 tls_version=""` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, err := ParseFormat(test.format, test.fields)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, test.want, string(format.Format(newEntry())))
+		})
+	}
+}
+
+func TestFormats_AllFields(t *testing.T) {
+	format, err := ParseFormat("json", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var line map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(format.Format(newEntry()), &line)) {
+		return
+	}
+	for _, name := range Fields() {
+		assert.Contains(t, line, name)
+	}
+}
+
+func TestParseFormat_Errors(t *testing.T) {
+	_, err := ParseFormat("apache", nil)
+	assert.EqualError(t, err, `accesslog: unknown format "apache"`)
+
+	_, err = ParseFormat("json", []string{"status", "size"})
+	assert.EqualError(t, err, `accesslog: unknown field "size"`)
+}
+
+func TestHandler(t *testing.T) {
+	var out bytes.Buffer
+	format, _ := ParseFormat("json", []string{"method", "status", "bytes_in", "bytes_out", "route", "upstream_addr", "cache_status", "tls_version", "request_id"})
+
+	h := NewHandler(format, &out, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = ioutil.ReadAll(request.Body)
+		SetRequestID(request.Context(), "req-1")
+		SetRoute(request.Context(), "api")
+		SetUpstream(request.Context(), "10.0.0.1:8080", time.Millisecond)
+		SetCacheStatus(request.Context(), "bypass")
+		writer.WriteHeader(http.StatusCreated)
+		_, _ = writer.Write([]byte("created"))
+	}))
+
+	request := httptest.NewRequest("POST", "/items", strings.NewReader("name=item"))
+	request.TLS = &tls.ConnectionState{Version: tls.VersionTLS13}
+	h.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.Equal(t, `{"method":"POST","status":201,"bytes_in":9,"bytes_out":7,"route":"api","upstream_addr":"10.0.0.1:8080","cache_status":"bypass","tls_version":"TLS1.3","request_id":"req-1"}`+"\n", out.String())
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		_, err := f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	for name, want := range map[string]string{
+		path:        "line 4\n",
+		path + ".1": "line 3\n",
+		path + ".2": "line 2\n",
+	} {
+		data, err := ioutil.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFile_Failure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenRotatingFile(path, 10, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	// The backup cannot replace a non empty directory.
+	if !assert.NoError(t, os.MkdirAll(filepath.Join(path+".1", "busy"), 0o755)) {
+		return
+	}
+
+	_, err = f.Write([]byte("line 1\n"))
+	assert.NoError(t, err)
+	n, err := f.Write([]byte("line 2\n"))
+	assert.Error(t, err)
+	assert.Equal(t, 7, n)
+
+	// The rotation succeeds once the backup path is free.
+	assert.NoError(t, os.RemoveAll(path+".1"))
+	_, err = f.Write([]byte("line 3\n"))
+	assert.NoError(t, err)
+
+	for name, want := range map[string]string{
+		path:        "line 3\n",
+		path + ".1": "line 1\nline 2\n",
+	} {
+		data, err := ioutil.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+}
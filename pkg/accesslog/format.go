@@ -0,0 +1,219 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format encodes the entries as log lines.
+type Format interface {
+
+	// Format returns the line of an entry, with its trailing newline.
+	Format(e *Entry) []byte
+}
+
+// The format names.
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+	FormatLogfmt   = "logfmt"
+)
+
+// clfTime is the time layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// field is a named value of an entry.
+type field struct {
+	name  string
+	value func(e *Entry) interface{}
+}
+
+// fields are the available fields, in the default order of the JSON
+// and logfmt formats.
+var fields = []field{
+	{"time", func(e *Entry) interface{} { return e.Time.Format(time.RFC3339Nano) }},
+	{"remote_addr", func(e *Entry) interface{} { return e.RemoteAddr }},
+	{"method", func(e *Entry) interface{} { return e.Method }},
+	{"uri", func(e *Entry) interface{} { return e.URI }},
+	{"proto", func(e *Entry) interface{} { return e.Proto }},
+	{"host", func(e *Entry) interface{} { return e.Host }},
+	{"status", func(e *Entry) interface{} { return e.Status }},
+	{"bytes_in", func(e *Entry) interface{} { return e.BytesIn }},
+	{"bytes_out", func(e *Entry) interface{} { return e.BytesOut }},
+	{"duration", func(e *Entry) interface{} { return e.Duration.Seconds() }},
+	{"referer", func(e *Entry) interface{} { return e.Referer }},
+	{"user_agent", func(e *Entry) interface{} { return e.UserAgent }},
+	{"route", func(e *Entry) interface{} { return e.route }},
+	{"upstream_addr", func(e *Entry) interface{} { return e.upstreamAddr }},
+	{"upstream_latency", func(e *Entry) interface{} { return e.upstreamLatency.Seconds() }},
+	{"cache_status", func(e *Entry) interface{} { return e.cacheStatus }},
+	{"tls_version", func(e *Entry) interface{} { return e.TLSVersion }},
+	{"request_id", func(e *Entry) interface{} { return e.requestID }},
+}
+
+// Fields returns the names of the available fields.
+func Fields() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// ParseFormat returns the format of a name: "common", "combined",
+// "json" or "logfmt". The JSON and logfmt lines hold the given fields,
+// or all of them when empty; the given fields are appended to the
+// common and combined lines as key="value" pairs.
+func ParseFormat(name string, names []string) (Format, error) {
+	selected, err := selectFields(names)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(name) {
+	case "", FormatCombined:
+		return &clf{combined: true, extra: selected}, nil
+	case FormatCommon:
+		return &clf{extra: selected}, nil
+	case FormatJSON:
+		if len(selected) == 0 {
+			selected = fields
+		}
+		return jsonFormat(selected), nil
+	case FormatLogfmt:
+		if len(selected) == 0 {
+			selected = fields
+		}
+		return logfmt(selected), nil
+	}
+
+	return nil, fmt.Errorf("accesslog: unknown format %q", name)
+}
+
+// selectFields returns the fields of the given names.
+func selectFields(names []string) ([]field, error) {
+	var selected []field
+
+next:
+	for _, name := range names {
+		for _, f := range fields {
+			if f.name == name {
+				selected = append(selected, f)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("accesslog: unknown field %q", name)
+	}
+
+	return selected, nil
+}
+
+// clf is the Common Log Format, optionally combined with the referer
+// and the user agent.
+type clf struct {
+	combined bool
+	extra    []field
+}
+
+// Format is the `Format` interface implementation.
+func (f *clf) Format(e *Entry) []byte {
+	var buf bytes.Buffer
+
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	size := "-"
+	if e.BytesOut > 0 {
+		size = strconv.FormatInt(e.BytesOut, 10)
+	}
+
+	fmt.Fprintf(&buf, "%s - - [%s] \"%s %s %s\" %d %s",
+		orDash(host), e.Time.Format(clfTime), e.Method, e.URI, e.Proto, e.Status, size)
+
+	if f.combined {
+		fmt.Fprintf(&buf, " %s %s", strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)))
+	}
+
+	for _, field := range f.extra {
+		fmt.Fprintf(&buf, " %s=%s", field.name, strconv.Quote(orDash(formatValue(field.value(e)))))
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// jsonFormat writes the entries as JSON objects.
+type jsonFormat []field
+
+// Format is the `Format` interface implementation.
+func (f jsonFormat) Format(e *Entry) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(field.name)
+		value, _ := json.Marshal(field.value(e))
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
+
+// logfmt writes the entries as key=value pairs.
+type logfmt []field
+
+// Format is the `Format` interface implementation.
+func (f logfmt) Format(e *Entry) []byte {
+	var buf bytes.Buffer
+
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		value := formatValue(field.value(e))
+		if len(value) == 0 || strings.ContainsAny(value, " =\"\\") || !strconv.CanBackquote(value) {
+			value = strconv.Quote(value)
+		}
+
+		buf.WriteString(field.name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+// formatValue returns the text of a field value.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
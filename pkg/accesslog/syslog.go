@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package accesslog
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// DialSyslog connects to a syslog daemon, the local one when network
+// and address are empty. The lines are sent with the info severity
+// and the local0 facility.
+func DialSyslog(network, address, tag string) (io.WriteCloser, error) {
+	return syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_LOCAL0, tag)
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package accesslog
+
+import (
+	"errors"
+	"io"
+)
+
+// DialSyslog is not supported on this platform.
+func DialSyslog(network, address, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("accesslog: syslog is not supported on this platform")
+}
@@ -0,0 +1,125 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file rotated when it reaches a maximum size.
+// The rotated files are renamed with a numbered suffix, "access.log.1"
+// being the most recent one.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens a log file rotated when it reaches maxSize
+// bytes, keeping maxBackups rotated files. The file is never rotated
+// when maxSize is 0.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write is the `io.Writer` interface implementation.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// A failed rotation is reported, but the line is still written to
+	// the current file and the rotation is tried again at the next
+	// write.
+	var rotateErr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		rotateErr = f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("accesslog: rotation of %s failed: %w", f.path, rotateErr)
+	}
+	return n, err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the file in append mode.
+func (f *RotatingFile) open() error {
+	file, size, err := openFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.file, f.size = file, size
+	return nil
+}
+
+// openFile opens a file in append mode and returns its size.
+func openFile(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// rotate shifts the rotated files, dropping the oldest one, and opens
+// a new file. The current file is only closed once the new one is
+// open, so the logs keep being written to it when the rotation fails.
+func (f *RotatingFile) rotate() error {
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(backupName(f.path, i), backupName(f.path, i+1))
+		}
+		if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+
+	file, size, err := openFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	_ = f.file.Close()
+	f.file, f.size = file, size
+	return nil
+}
+
+func backupName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
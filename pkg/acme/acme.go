@@ -0,0 +1,243 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/certstore"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultDirectoryURL is the directory of the Let's Encrypt production
+// CA.
+const DefaultDirectoryURL = autocert.DefaultACMEDirectory
+
+// DefaultRenewBefore is the default time before the expiry of the
+// certificates they are renewed. It is also used when the configured
+// time is not above the renewal jitter of an hour.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// The challenge types.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// Manager obtains and renews the certificates of a set of domains
+// from an ACME CA, and stores them in a directory. The storage can be
+// shared by several instances: one of them at a time issues or renews
+// a certificate, the others load it from the storage.
+type Manager struct {
+	storage      string
+	domains      map[string]bool
+	email        string
+	directoryURL string
+	rootCAs      *x509.CertPool
+	renewBefore  time.Duration
+	http01       bool
+	tlsALPN01    bool
+
+	manager   *autocert.Manager
+	challenge http.Handler
+}
+
+// Option is a function used to modify
+// the manager behavior.
+type Option func(*Manager)
+
+// WithEmail sets the contact email of the ACME account.
+func WithEmail(email string) Option {
+	return func(m *Manager) {
+		m.email = email
+	}
+}
+
+// WithDirectoryURL sets the ACME directory of the CA (e.g. the one of
+// a Pebble test server).
+func WithDirectoryURL(url string) Option {
+	return func(m *Manager) {
+		m.directoryURL = url
+	}
+}
+
+// WithRootCAs sets the CAs trusted for the HTTPS connections to the
+// ACME directory, instead of the system ones.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(m *Manager) {
+		m.rootCAs = pool
+	}
+}
+
+// WithRenewBefore sets the time before the expiry of the certificates
+// they are renewed.
+func WithRenewBefore(d time.Duration) Option {
+	return func(m *Manager) {
+		m.renewBefore = d
+	}
+}
+
+// WithChallenges sets the challenge types answered to validate the
+// domains: ChallengeHTTP01, ChallengeTLSALPN01 or both (the default).
+// The TLS-ALPN-01 challenge is tried first.
+func WithChallenges(types ...string) Option {
+	return func(m *Manager) {
+		m.http01, m.tlsALPN01 = false, false
+		for _, t := range types {
+			switch t {
+			case ChallengeHTTP01:
+				m.http01 = true
+			case ChallengeTLSALPN01:
+				m.tlsALPN01 = true
+			}
+		}
+	}
+}
+
+// New creates a manager of the certificates of the domains, stored in
+// the given directory.
+func New(storage string, domains []string, opts ...Option) *Manager {
+	m := &Manager{
+		storage:      storage,
+		domains:      make(map[string]bool),
+		directoryURL: DefaultDirectoryURL,
+		renewBefore:  DefaultRenewBefore,
+		http01:       true,
+		tlsALPN01:    true,
+	}
+	for _, domain := range domains {
+		m.domains[strings.ToLower(domain)] = true
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.renewBefore <= renewJitter {
+		m.renewBefore = DefaultRenewBefore
+	}
+
+	client := &acme.Client{DirectoryURL: m.directoryURL}
+	if m.rootCAs != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: m.rootCAs}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	m.manager = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       newLockingCache(storage, m.renewBefore),
+		HostPolicy:  autocert.HostWhitelist(domains...),
+		RenewBefore: m.renewBefore,
+		Client:      client,
+		Email:       m.email,
+	}
+	if m.http01 {
+		m.challenge = m.manager.HTTPHandler(nil)
+	}
+
+	return m
+}
+
+// Handles reports whether the manager obtains the certificate of a
+// host.
+func (m *Manager) Handles(host string) bool {
+	return m.domains[domain(host)]
+}
+
+// GetCertificate returns the certificate of the server name of the
+// connection, obtaining it on the first connection, or answers the
+// TLS-ALPN-01 challenge.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.manager.GetCertificate(hello)
+}
+
+// IsChallenge reports whether a TLS connection answers a TLS-ALPN-01
+// challenge.
+func IsChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// NextProtos returns the ALPN protocols of the TLS listeners.
+func (m *Manager) NextProtos() []string {
+	if m.tlsALPN01 {
+		return []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+	return []string{"h2", "http/1.1"}
+}
+
+// Certificates describes the stored certificates of the domains.
+func (m *Manager) Certificates() []certstore.Info {
+	var infos []certstore.Info
+	for domain := range m.domains {
+		file := filepath.Join(m.storage, domain)
+		data, err := autocert.DirCache(m.storage).Get(context.Background(), domain)
+		if err != nil {
+			continue
+		}
+
+		if leaf := leafCertificate(data); leaf != nil {
+			infos = append(infos, certstore.Info{
+				File:      file,
+				Names:     leaf.DNSNames,
+				NotBefore: leaf.NotBefore,
+				NotAfter:  leaf.NotAfter,
+			})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].File < infos[j].File })
+	return infos
+}
+
+// HTTPChallenges answers the HTTP-01 challenges of the managers'
+// domains, and passes the other requests to next.
+func HTTPChallenges(next http.Handler, managers ...*Manager) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if strings.HasPrefix(request.URL.Path, "/.well-known/acme-challenge/") {
+			for _, m := range managers {
+				if m.challenge != nil && m.Handles(request.Host) {
+					// The host policy of the autocert manager expects the
+					// bare domain, without the port of the validation.
+					request = request.Clone(request.Context())
+					request.Host = domain(request.Host)
+					m.challenge.ServeHTTP(writer, request)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// domain returns the lower-cased domain of a host, without its port.
+func domain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// leafCertificate returns the first certificate of the PEM data, or
+// nil.
+func leafCertificate(data []byte) *x509.Certificate {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil
+		}
+		if block.Type == "CERTIFICATE" {
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil
+			}
+			return leaf
+		}
+	}
+}
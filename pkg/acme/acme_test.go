@@ -0,0 +1,176 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certificatePEM returns a stored certificate as written by the
+// autocert manager: the key followed by the certificate.
+func certificatePEM(t *testing.T, name string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func TestLockingCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Two instances sharing the storage.
+	first := newLockingCache(dir, DefaultRenewBefore)
+	second := newLockingCache(dir, DefaultRenewBefore)
+	ctx := context.Background()
+
+	// The first instance issues the missing certificate, the second
+	// one waits for it.
+	_, err = first.Get(ctx, "example.com")
+	assert.Equal(t, autocert.ErrCacheMiss, err)
+	assert.True(t, first.holds("example.com"))
+
+	timeout, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	_, err = second.Get(timeout, "example.com")
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	fresh := certificatePEM(t, "example.com", time.Now().Add(90*24*time.Hour))
+	done := make(chan []byte)
+	go func() {
+		data, err := second.Get(ctx, "example.com")
+		assert.NoError(t, err)
+		done <- data
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, first.Put(ctx, "example.com", fresh))
+	assert.False(t, first.holds("example.com"))
+	assert.Equal(t, fresh, <-done)
+	assert.False(t, second.holds("example.com"))
+
+	// A certificate not due for renewal yet is loaded without lock by
+	// both instances.
+	loaded := certificatePEM(t, "example.com", time.Now().Add(40*24*time.Hour))
+	assert.NoError(t, first.Put(ctx, "example.com", loaded))
+	data, err := first.Get(ctx, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, loaded, data)
+	assert.False(t, first.holds("example.com"))
+
+	timeout, cancel = context.WithTimeout(ctx, 150*time.Millisecond)
+	data, err = second.Get(timeout, "example.com")
+	cancel()
+	assert.NoError(t, err)
+	assert.Equal(t, loaded, data)
+	assert.False(t, second.holds("example.com"))
+
+	// A certificate due for renewal is locked by the instance reading
+	// it first, the following reads of the same instance go through.
+	due := certificatePEM(t, "example.com", time.Now().Add(20*24*time.Hour))
+	assert.NoError(t, first.Put(ctx, "example.com", due))
+	data, err = second.Get(ctx, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, due, data)
+	assert.True(t, second.holds("example.com"))
+	data, err = second.Get(ctx, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, due, data)
+	assert.NoError(t, second.Put(ctx, "example.com", fresh))
+	assert.False(t, second.holds("example.com"))
+
+	// The account key and the challenge tokens are not locked.
+	_, err = first.Get(ctx, "acme_account+key")
+	assert.Equal(t, autocert.ErrCacheMiss, err)
+	assert.False(t, first.holds("acme_account+key"))
+}
+
+func TestHTTPChallenges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	managers := []*Manager{
+		New(dir, []string{"example.com"}),
+		New(dir, []string{"example.org"}, WithChallenges(ChallengeTLSALPN01)),
+		New(dir, []string{"example.net"}, WithChallenges(ChallengeHTTP01)),
+	}
+	next := http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	})
+	h := HTTPChallenges(next, managers...)
+
+	serve := func(target string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec.Code
+	}
+
+	// The unknown tokens of the managed domains are not found, the
+	// other requests go through.
+	assert.Equal(t, http.StatusNotFound, serve("http://example.com/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusNotFound, serve("http://EXAMPLE.com:5002/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusTeapot, serve("http://example.org/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusNotFound, serve("http://example.net/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusTeapot, serve("http://example.io/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusTeapot, serve("http://example.com/index.html"))
+
+	assert.Equal(t, []string{"h2", "http/1.1", "acme-tls/1"}, managers[0].NextProtos())
+	assert.Equal(t, []string{"h2", "http/1.1", "acme-tls/1"}, managers[1].NextProtos())
+	assert.Equal(t, []string{"h2", "http/1.1"}, managers[2].NextProtos())
+}
+
+func TestManager_Certificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	expiry := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	assert.NoError(t, autocert.DirCache(dir).Put(context.Background(), "example.com", certificatePEM(t, "example.com", expiry)))
+
+	infos := New(dir, []string{"example.com", "example.org"}).Certificates()
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, []string{"example.com"}, infos[0].Names)
+		assert.True(t, expiry.Equal(infos[0].NotAfter))
+	}
+}
@@ -0,0 +1,137 @@
+package acme
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// lockTimeout is the maximum time a certificate lock is held, when its
+// issuance fails without storing a certificate.
+const lockTimeout = 10 * time.Minute
+
+// renewJitter is the maximum random time the autocert manager renews
+// the certificates before RenewBefore.
+const renewJitter = time.Hour
+
+// lockingCache is a directory cache whose certificates are issued and
+// renewed by one instance at a time.
+//
+// The autocert manager reads a certificate before issuing or renewing
+// it: when the stored certificate is missing or due for renewal, the
+// read waits for the lock of the certificate file, then reads it again
+// as another instance may have stored a new one meanwhile, in which
+// case the lock is released at once. Otherwise the lock is released
+// when the certificate is stored.
+//
+// The certificates read before their renewal time, e.g. when they are
+// loaded at startup, are returned without lock: the autocert manager
+// only schedules their renewal.
+type lockingCache struct {
+	autocert.DirCache
+	renewBefore time.Duration
+
+	mu   sync.Mutex
+	held map[string]*heldLock
+}
+
+// heldLock is the lock of a certificate being issued.
+type heldLock struct {
+	unlock  func()
+	timeout *time.Timer
+}
+
+// Static implementation checker.
+var _ autocert.Cache = (*lockingCache)(nil)
+
+func newLockingCache(dir string, renewBefore time.Duration) *lockingCache {
+	return &lockingCache{
+		DirCache:    autocert.DirCache(dir),
+		renewBefore: renewBefore,
+		held:        make(map[string]*heldLock),
+	}
+}
+
+// Get is the `autocert.Cache` interface implementation.
+func (c *lockingCache) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := c.DirCache.Get(ctx, name)
+	if !isCertificate(name) || c.holds(name) {
+		return data, err
+	}
+	if err == nil && !c.due(data) {
+		return data, nil
+	}
+	if err != nil && err != autocert.ErrCacheMiss {
+		return nil, err
+	}
+
+	unlock, err := lockFile(ctx, filepath.Join(string(c.DirCache), name+".lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = c.DirCache.Get(ctx, name)
+	if err == nil && !c.due(data) {
+		unlock()
+		return data, nil
+	}
+
+	c.hold(name, unlock)
+	return data, err
+}
+
+// Put is the `autocert.Cache` interface implementation.
+func (c *lockingCache) Put(ctx context.Context, name string, data []byte) error {
+	err := c.DirCache.Put(ctx, name, data)
+	c.release(name)
+	return err
+}
+
+// due reports whether the autocert manager renews a stored certificate
+// after reading it: its renewal timer fires up to the jitter before
+// RenewBefore, and it renews the certificates read from then on.
+func (c *lockingCache) due(data []byte) bool {
+	leaf := leafCertificate(data)
+	return leaf == nil || time.Until(leaf.NotAfter) <= c.renewBefore+renewJitter
+}
+
+// holds reports whether the lock of a certificate is held.
+func (c *lockingCache) holds(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.held[name]
+	return ok
+}
+
+// hold keeps the lock of a certificate until it is stored, or until
+// the lock timeout.
+func (c *lockingCache) hold(name string, unlock func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held[name] = &heldLock{
+		unlock:  unlock,
+		timeout: time.AfterFunc(lockTimeout, func() { c.release(name) }),
+	}
+}
+
+// release releases the lock of a certificate, if held.
+func (c *lockingCache) release(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lock, ok := c.held[name]; ok {
+		lock.timeout.Stop()
+		lock.unlock()
+		delete(c.held, name)
+	}
+}
+
+// isCertificate reports whether a cache entry is a domain certificate,
+// and not the account key or a challenge token.
+func isCertificate(name string) bool {
+	return !strings.Contains(name, "+") || strings.HasSuffix(name, "+rsa")
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package acme
+
+import "context"
+
+// lockFile does not lock across the processes on this platform: the
+// instances sharing a storage may issue the same certificate.
+func lockFile(context.Context, string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,475 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/certstore"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the secret values in the exposed configuration.
+const redacted = "<redacted>"
+
+// secretFields are the configuration fields never exposed.
+var secretFields = map[string]bool{
+	"secret": true,
+	"token":  true,
+}
+
+// Source provides the state exposed by the admin API. It is
+// implemented by server.Reloader.
+type Source interface {
+
+	// Config returns the current configuration.
+	Config() *config.Config
+
+	// Graph returns the current handler graph.
+	Graph() *server.Graph
+}
+
+// Handler is a http.Handler serving the admin API. It must be served
+// on its own listener, apart from the proxied traffic.
+//
+// The endpoints are:
+//
+//	GET  /config           current configuration, secrets redacted
+//	GET  /routes           routes, in matching order
+//	PUT  /routes/weights   changes the split weights of a route
+//	GET  /upstreams        upstream groups with the member states
+//	POST /upstreams/drain  takes an upstream out of the rotation
+//	POST /upstreams/enable puts a drained upstream back
+//	GET  /cache            cache statistics
+//	POST /cache/purge      removes cache entries
+//	GET  /certificates     listener certificates, with their expiry
+//	GET  /metrics          metrics, when enabled
+type Handler struct {
+	source       Source
+	token        string
+	metrics      http.Handler
+	certificates map[string]certstore.Lister
+	mux          *http.ServeMux
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Handler)(nil)
+
+// Option is a function used to modify
+// the handler behavior.
+type Option func(*Handler)
+
+// WithToken requires the requests to present the given bearer token
+// in the "Authorization" header.
+func WithToken(token string) Option {
+	return func(h *Handler) {
+		h.token = token
+	}
+}
+
+// WithMetrics serves the given metrics handler on "/metrics".
+func WithMetrics(metrics http.Handler) Option {
+	return func(h *Handler) {
+		h.metrics = metrics
+	}
+}
+
+// WithCertificates exposes the certificates of the listeners, by
+// listener name.
+func WithCertificates(listers map[string]certstore.Lister) Option {
+	return func(h *Handler) {
+		h.certificates = listers
+	}
+}
+
+// NewHandler creates the admin API handler.
+func NewHandler(source Source, opts ...Option) *Handler {
+	h := &Handler{source: source, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux.HandleFunc("/config", h.method(http.MethodGet, h.config))
+	h.mux.HandleFunc("/routes", h.method(http.MethodGet, h.routes))
+	h.mux.HandleFunc("/routes/weights", h.method(http.MethodPut, h.setWeights))
+	h.mux.HandleFunc("/upstreams", h.method(http.MethodGet, h.upstreams))
+	h.mux.HandleFunc("/upstreams/drain", h.method(http.MethodPost, h.drain(true)))
+	h.mux.HandleFunc("/upstreams/enable", h.method(http.MethodPost, h.drain(false)))
+	h.mux.HandleFunc("/mirror", h.method(http.MethodGet, h.mirrorStats))
+	h.mux.HandleFunc("/cache", h.method(http.MethodGet, h.cacheStats))
+	h.mux.HandleFunc("/cache/purge", h.method(http.MethodPost, h.purge))
+	h.mux.HandleFunc("/certificates", h.method(http.MethodGet, h.listCertificates))
+	if h.metrics != nil {
+		h.mux.HandleFunc("/metrics", h.method(http.MethodGet, h.metrics.ServeHTTP))
+	}
+	return h
+}
+
+// TLSConfig creates the TLS configuration of the admin listener. When
+// a client CA is set, the clients must present a certificate it
+// signed.
+func TLSConfig(c *config.AdminTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(c.ClientCA) == 0 {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("admin: no certificate found in %q", c.ClientCA)
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+// ServeHTTP is the `http.Handler` interface implementation.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if len(h.token) > 0 && !h.authorized(request) {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(writer, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+
+	h.mux.ServeHTTP(writer, request)
+}
+
+// authorized checks the bearer token of the request.
+func (h *Handler) authorized(request *http.Request) bool {
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// method rejects the requests with another method than the given one.
+func (h *Handler) method(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != method {
+			writer.Header().Set("Allow", method)
+			writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		next(writer, request)
+	}
+}
+
+// config writes the current configuration.
+func (h *Handler) config(writer http.ResponseWriter, _ *http.Request) {
+	// The configuration is converted through YAML to keep its field
+	// names.
+	data, err := yaml.Marshal(h.source.Config())
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var value map[string]interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		writeError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, redact(value))
+}
+
+// route is a route of the /routes endpoint.
+type route struct {
+	Name      string         `json:"name"`
+	Host      string         `json:"host,omitempty"`
+	Path      string         `json:"path"`
+	Listeners []string       `json:"listeners,omitempty"`
+	Upstream  string         `json:"upstream,omitempty"`
+	Split     map[string]int `json:"split,omitempty"`
+	Affinity  bool           `json:"affinity"`
+	Mirror    string         `json:"mirror,omitempty"`
+	Overrides int            `json:"overrides,omitempty"`
+}
+
+// routes writes the routes in matching order.
+func (h *Handler) routes(writer http.ResponseWriter, _ *http.Request) {
+	configs := make(map[string]*config.RouteConfig)
+	for _, rc := range h.source.Config().Routes {
+		configs[rc.Name] = rc
+	}
+
+	routes := []route{}
+	for _, r := range h.source.Graph().Routes() {
+		item := route{Name: r.Name, Host: r.Host, Path: r.Path, Listeners: r.Listeners}
+		if rc, ok := configs[r.Name]; ok {
+			item.Upstream = rc.Upstream
+			item.Affinity = rc.Affinity != nil
+			item.Overrides = len(rc.Overrides)
+			if rc.Mirror != nil {
+				item.Mirror = rc.Mirror.Target
+			}
+		}
+		if r.Split != nil {
+			item.Split = r.Split.Weights()
+		}
+		routes = append(routes, item)
+	}
+
+	writeJSON(writer, http.StatusOK, routes)
+}
+
+// setWeights changes the split weights of the route given by the
+// "route" query parameter, from a JSON object of weights by upstream
+// group. The groups missing from the object keep their weight. The
+// weights are reset to the configured ones by the next reload.
+func (h *Handler) setWeights(writer http.ResponseWriter, request *http.Request) {
+	name := request.URL.Query().Get("route")
+	if len(name) == 0 {
+		writeError(writer, http.StatusBadRequest, "route parameter is required")
+		return
+	}
+
+	var split *proxy.Split
+	found := false
+	for _, r := range h.source.Graph().Routes() {
+		if r.Name == name {
+			split, found = r.Split, true
+			break
+		}
+	}
+	if !found {
+		writeError(writer, http.StatusNotFound, fmt.Sprintf("unknown route %q", name))
+		return
+	}
+	if split == nil {
+		writeError(writer, http.StatusBadRequest, fmt.Sprintf("route %q has no split", name))
+		return
+	}
+
+	var weights map[string]int
+	if err := json.NewDecoder(request.Body).Decode(&weights); err != nil {
+		writeError(writer, http.StatusBadRequest, fmt.Sprintf("invalid weights: %v", err))
+		return
+	}
+	if err := split.SetWeights(weights); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	current := split.Weights()
+	logrus.WithFields(logrus.Fields{"route": name, "weights": current}).Info("Split weights changed")
+	writeJSON(writer, http.StatusOK, current)
+}
+
+// upstream is an upstream of the /upstreams endpoint.
+type upstream struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+
+	// Healthy is false when the upstream is down or its circuit breaker
+	// is open.
+	Healthy bool `json:"healthy"`
+
+	// Breaker is the state of the circuit breaker: "closed", "open" or
+	// "half-open".
+	Breaker string `json:"breaker"`
+	Drained bool   `json:"drained"`
+}
+
+// describe returns the state of an upstream.
+func describe(u *proxy.Upstream) upstream {
+	weight := u.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	return upstream{
+		URL:     u.URL.String(),
+		Weight:  weight,
+		Healthy: u.Healthy(),
+		Breaker: u.Breaker().String(),
+		Drained: u.Drained(),
+	}
+}
+
+// upstreams writes the members of the upstream groups.
+func (h *Handler) upstreams(writer http.ResponseWriter, _ *http.Request) {
+	groups := make(map[string][]upstream)
+	for name, pool := range h.source.Graph().Pools() {
+		members := []upstream{}
+		for _, u := range pool.Upstreams() {
+			members = append(members, describe(u))
+		}
+		groups[name] = members
+	}
+
+	writeJSON(writer, http.StatusOK, groups)
+}
+
+// drain returns the handler draining or enabling the upstream given
+// by the "url" query parameter, in the group given by the "group"
+// query parameter or in all the groups.
+func (h *Handler) drain(drained bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		target := request.URL.Query().Get("url")
+		group := request.URL.Query().Get("group")
+		if len(target) == 0 {
+			writeError(writer, http.StatusBadRequest, "url parameter is required")
+			return
+		}
+
+		pools := h.source.Graph().Pools()
+		if len(group) > 0 {
+			if _, ok := pools[group]; !ok {
+				writeError(writer, http.StatusNotFound, fmt.Sprintf("unknown upstream group %q", group))
+				return
+			}
+		}
+
+		var updated []upstream
+		for name, pool := range pools {
+			if len(group) > 0 && name != group {
+				continue
+			}
+
+			for _, u := range pool.Upstreams() {
+				if u.URL.String() != target {
+					continue
+				}
+
+				u.SetDrained(drained)
+				updated = append(updated, describe(u))
+			}
+		}
+
+		if len(updated) == 0 {
+			writeError(writer, http.StatusNotFound, fmt.Sprintf("unknown upstream %q", target))
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{"upstream": target, "drained": drained}).Info("Upstream state changed")
+		writeJSON(writer, http.StatusOK, updated)
+	}
+}
+
+// mirrorStats writes the shadow comparison counters of the routes
+// comparing their mirrored responses, by route name then compared
+// path prefix. The counters start again at each reload.
+func (h *Handler) mirrorStats(writer http.ResponseWriter, _ *http.Request) {
+	routes := make(map[string]map[string]proxy.MirrorStats)
+	for _, r := range h.source.Graph().Routes() {
+		if stats := r.Proxy.MirrorStats(); stats != nil {
+			routes[r.Name] = stats
+		}
+	}
+
+	writeJSON(writer, http.StatusOK, routes)
+}
+
+// cacheStats writes the statistics of the cache store.
+func (h *Handler) cacheStats(writer http.ResponseWriter, _ *http.Request) {
+	inspector, ok := h.source.Graph().Cache().(cache.Inspector)
+	if !ok {
+		writeError(writer, http.StatusNotImplemented, "cache statistics are not supported by the store")
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, inspector.Stats())
+}
+
+// certificate is a certificate of the /certificates endpoint.
+type certificate struct {
+	Listener string `json:"listener"`
+	certstore.Info
+}
+
+// listCertificates writes the certificates of the listeners, ordered
+// by listener name.
+func (h *Handler) listCertificates(writer http.ResponseWriter, _ *http.Request) {
+	listeners := make([]string, 0, len(h.certificates))
+	for name := range h.certificates {
+		listeners = append(listeners, name)
+	}
+	sort.Strings(listeners)
+
+	certificates := []certificate{}
+	for _, name := range listeners {
+		for _, info := range h.certificates[name].Certificates() {
+			certificates = append(certificates, certificate{Listener: name, Info: info})
+		}
+	}
+
+	writeJSON(writer, http.StatusOK, certificates)
+}
+
+// purge removes the cache entries whose path starts with the "prefix"
+// query parameter, or all the entries.
+func (h *Handler) purge(writer http.ResponseWriter, request *http.Request) {
+	inspector, ok := h.source.Graph().Cache().(cache.Inspector)
+	if !ok {
+		writeError(writer, http.StatusNotImplemented, "purge is not supported by the store")
+		return
+	}
+
+	prefix := request.URL.Query().Get("prefix")
+	purged := inspector.Purge(prefix)
+
+	logrus.WithFields(logrus.Fields{"prefix": prefix, "purged": purged}).Info("Cache purged")
+	writeJSON(writer, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// redact replaces the secret values of a decoded configuration.
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if secretFields[key] {
+				if s, ok := child.(string); ok && len(s) > 0 {
+					v[key] = redacted
+				}
+				continue
+			}
+			v[key] = redact(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redact(child)
+		}
+	}
+
+	return value
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		logrus.WithError(err).Error("Error while writing admin response")
+	}
+}
+
+// writeError writes a JSON error response.
+func writeError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, map[string]string{"error": message})
+}
@@ -0,0 +1,270 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/moutoum/http-reverse-proxy/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// staticSource is a Source with a fixed configuration.
+type staticSource struct {
+	config *config.Config
+	graph  *server.Graph
+}
+
+func (s *staticSource) Config() *config.Config { return s.config }
+func (s *staticSource) Graph() *server.Graph   { return s.graph }
+
+func newSource(t *testing.T, store cache.Cache) *staticSource {
+	c, err := config.Parse([]byte(`
+upstreams:
+  web: {targets: ["http://10.0.0.1", "http://10.0.0.2"]}
+  canary: {targets: ["http://10.0.0.2"]}
+routes:
+  - path: /api
+    split: {web: 9, canary: 1}
+    affinity: {cookie: session, secret: s3cr3t}
+  - upstream: web
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := server.Build(c, server.WithCache(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(graph.Close)
+
+	return &staticSource{config: c, graph: graph}
+}
+
+func serve(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	if len(token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, request)
+	return rec
+}
+
+func TestHandler_Token(t *testing.T) {
+	h := NewHandler(newSource(t, cache.NewInMemoryCache()), WithToken("t0ken"))
+
+	rec := serve(h, http.MethodGet, "/routes", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer realm="admin"`, rec.Header().Get("WWW-Authenticate"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/routes", "wrong").Code)
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/routes", "t0ken").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(h, http.MethodPost, "/routes", "t0ken").Code)
+}
+
+func TestHandler_Config(t *testing.T) {
+	h := NewHandler(newSource(t, cache.NewInMemoryCache()))
+
+	rec := serve(h, http.MethodGet, "/config", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"cookie": "session"`)
+	assert.Contains(t, rec.Body.String(), `"secret": "<redacted>"`)
+	assert.NotContains(t, rec.Body.String(), "s3cr3t")
+}
+
+func TestHandler_Routes(t *testing.T) {
+	h := NewHandler(newSource(t, cache.NewInMemoryCache()))
+
+	var routes []route
+	rec := serve(h, http.MethodGet, "/routes", "")
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &routes)) {
+		assert.Equal(t, []route{
+			{Name: "/api", Path: "/api", Split: map[string]int{"web": 9, "canary": 1}, Affinity: true},
+			{Name: "/", Path: "/", Upstream: "web"},
+		}, routes)
+	}
+}
+
+func TestHandler_SetWeights(t *testing.T) {
+	source := newSource(t, cache.NewInMemoryCache())
+	h := NewHandler(source)
+
+	put := func(route, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPut, "/routes/weights?route="+route, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := put("/api", `{"web": 0}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"web": 0, "canary": 1}`, rec.Body.String())
+
+	// The requests only go to the canary group.
+	for i := 0; i < 10; i++ {
+		u, err := source.graph.Routes()[0].Split.Select(nil, httptest.NewRequest(http.MethodGet, "/api", nil))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "http://10.0.0.2", u.URL.String())
+		}
+	}
+
+	var routes []route
+	if assert.NoError(t, json.Unmarshal(serve(h, http.MethodGet, "/routes", "").Body.Bytes(), &routes)) {
+		assert.Equal(t, map[string]int{"web": 0, "canary": 1}, routes[0].Split)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, put("/api", `{"unknown": 1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/api", `{"web": -1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/api", `not json`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("/", `{"web": 1}`).Code, "the route has no split")
+	assert.Equal(t, http.StatusNotFound, put("/other", `{"web": 1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put("", `{"web": 1}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(h, http.MethodGet, "/routes/weights?route=/api", "").Code)
+	assert.Equal(t, map[string]int{"web": 0, "canary": 1}, source.graph.Routes()[0].Split.Weights(), "the failed updates change nothing")
+}
+
+func TestHandler_Drain(t *testing.T) {
+	source := newSource(t, cache.NewInMemoryCache())
+	h := NewHandler(source)
+
+	rec := serve(h, http.MethodPost, "/upstreams/drain?url=http://10.0.0.2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var groups map[string][]upstream
+	rec = serve(h, http.MethodGet, "/upstreams", "")
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &groups)) {
+		assert.Equal(t, map[string][]upstream{
+			"web": {
+				{URL: "http://10.0.0.1", Weight: 1, Healthy: true, Breaker: "closed"},
+				{URL: "http://10.0.0.2", Weight: 1, Healthy: true, Breaker: "closed", Drained: true},
+			},
+			"canary": {
+				{URL: "http://10.0.0.2", Weight: 1, Healthy: true, Breaker: "closed", Drained: true},
+			},
+		}, groups)
+	}
+
+	rec = serve(h, http.MethodPost, "/upstreams/enable?group=canary&url=http://10.0.0.2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, source.graph.Pools()["canary"].Upstreams()[0].Drained())
+	assert.True(t, source.graph.Pools()["web"].Upstreams()[1].Drained())
+
+	assert.Equal(t, http.StatusBadRequest, serve(h, http.MethodPost, "/upstreams/drain", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodPost, "/upstreams/drain?url=http://10.0.0.3", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodPost, "/upstreams/drain?group=x&url=http://10.0.0.1", "").Code)
+}
+
+func TestHandler_UpstreamHealth(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	c, err := config.Parse([]byte(`
+upstreams:
+  web:
+    targets: ["` + down.URL + `"]
+    load_balancing: {max_fails: 2, fail_timeout: 1m}
+routes:
+  - upstream: web
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := server.Build(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.Close()
+	h := NewHandler(&staticSource{config: c, graph: graph})
+
+	// state returns the reported state of the upstream.
+	state := func() upstream {
+		var groups map[string][]upstream
+		rec := serve(h, http.MethodGet, "/upstreams", "")
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &groups)) || !assert.Len(t, groups["web"], 1) {
+			t.FailNow()
+		}
+		return groups["web"][0]
+	}
+
+	assert.True(t, state().Healthy)
+	assert.Equal(t, "closed", state().Breaker)
+
+	// The breaker opens after the second failure.
+	assert.Equal(t, http.StatusBadGateway, serve(graph, http.MethodGet, "/", "").Code)
+	assert.Equal(t, "closed", state().Breaker)
+	assert.Equal(t, http.StatusBadGateway, serve(graph, http.MethodGet, "/", "").Code)
+	assert.False(t, state().Healthy)
+	assert.Equal(t, "open", state().Breaker)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(graph, http.MethodGet, "/", "").Code)
+}
+
+func TestHandler_Mirror(t *testing.T) {
+	respond := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte(body))
+		}))
+	}
+	primary, shadow := respond(`{"id": 1}`), respond(`{"id": 2}`)
+	defer primary.Close()
+	defer shadow.Close()
+
+	c, err := config.Parse([]byte(`
+upstreams:
+  web: {targets: ["` + primary.URL + `"]}
+routes:
+  - path: /api
+    upstream: web
+    mirror:
+      target: ` + shadow.URL + `
+      compare: {routes: [/api/users]}
+  - upstream: web
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := server.Build(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.Close()
+	h := NewHandler(&staticSource{config: c, graph: graph})
+
+	serve(graph, http.MethodGet, "/api/users/1", "")
+	serve(graph, http.MethodGet, "/", "")
+
+	assert.Eventually(t, func() bool {
+		var routes map[string]map[string]proxy.MirrorStats
+		rec := serve(h, http.MethodGet, "/mirror", "")
+		return json.Unmarshal(rec.Body.Bytes(), &routes) == nil &&
+			reflect.DeepEqual(routes, map[string]map[string]proxy.MirrorStats{"/api": {"/api/users": {Mismatches: 1}}})
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_Cache(t *testing.T) {
+	store := cache.NewInMemoryCache()
+	store.Store("/api/a", &cache.Resource{Status: http.StatusOK, Body: []byte("abc"), Date: time.Now()})
+	store.Store("/api/b", &cache.Resource{Status: http.StatusOK, Body: []byte("de"), Date: time.Now()})
+	store.Store("/index.html", &cache.Resource{Status: http.StatusOK, Body: []byte("f"), Date: time.Now()})
+
+	h := NewHandler(newSource(t, store))
+
+	rec := serve(h, http.MethodGet, "/cache", "")
+	assert.JSONEq(t, `{"entries": 3, "bytes": 6}`, rec.Body.String())
+
+	rec = serve(h, http.MethodPost, "/cache/purge?prefix=/api", "")
+	assert.JSONEq(t, `{"purged": 2}`, rec.Body.String())
+
+	rec = serve(h, http.MethodGet, "/cache", "")
+	assert.JSONEq(t, `{"entries": 1, "bytes": 1}`, rec.Body.String())
+}
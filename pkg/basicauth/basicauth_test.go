@@ -0,0 +1,144 @@
+package basicauth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testHtpasswd = `# Test users
+bcrypt:$2a$04$meX9Lc5SURU/S/SqC0nbvOp.iFXKySmmUssmQgJ5CHQmVkzzgRmCm
+sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+apr1:$apr1$salt$VEpBc9VHGUKwI9.yg13Iu0
+`
+
+func writeHtpasswd(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, ".htpasswd")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func Test_apr1(t *testing.T) {
+	assert.Equal(t, "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/", apr1([]byte("myPassword"), []byte("r31.....")))
+	assert.Equal(t, "$apr1$salt$VEpBc9VHGUKwI9.yg13Iu0", apr1([]byte("secret"), []byte("salt")))
+}
+
+func TestFile_Authenticate(t *testing.T) {
+	f, err := NewFile(writeHtpasswd(t, testHtpasswd))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		name           string
+		user, password string
+		want           bool
+	}{
+		{name: "Valid bcrypt", user: "bcrypt", password: "secret", want: true},
+		{name: "Invalid bcrypt", user: "bcrypt", password: "wrong"},
+		{name: "Valid SHA", user: "sha", password: "secret", want: true},
+		{name: "Invalid SHA", user: "sha", password: "wrong"},
+		{name: "Valid APR1", user: "apr1", password: "secret", want: true},
+		{name: "Invalid APR1", user: "apr1", password: "wrong"},
+		{name: "Unknown user", user: "unknown", password: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.Authenticate(tt.user, tt.password))
+		})
+	}
+}
+
+func TestFile_Reload(t *testing.T) {
+	path := writeHtpasswd(t, testHtpasswd)
+	f, err := NewFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	f.checkInterval = 0
+
+	assert.True(t, f.Authenticate("sha", "secret"))
+
+	if err := ioutil.WriteFile(path, []byte("other:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, future, future)
+
+	assert.False(t, f.Authenticate("sha", "secret"))
+	assert.True(t, f.Authenticate("other", "secret"))
+
+	// An invalid file keeps the previous credentials.
+	if err := ioutil.WriteFile(path, []byte("invalid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	_ = os.Chtimes(path, future, future)
+
+	assert.True(t, f.Authenticate("other", "secret"))
+}
+
+func TestHandler(t *testing.T) {
+	f, err := NewFile(writeHtpasswd(t, testHtpasswd))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var forwarded *http.Request
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forwarded = request
+	})
+
+	h := NewHandler(f, origin, WithRoute("/admin", "Admin area"))
+
+	serve := func(path, user, password string) *httptest.ResponseRecorder {
+		forwarded = nil
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Not protected route", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("/public", "", "").Code)
+		assert.NotNil(t, forwarded)
+	})
+
+	t.Run("Missing credentials", func(t *testing.T) {
+		recorder := serve("/admin", "", "")
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, `Basic realm="Admin area", charset="UTF-8"`, recorder.Header().Get("WWW-Authenticate"))
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Invalid credentials", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve("/admin", "sha", "wrong").Code)
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Valid credentials", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("/admin/users", "sha", "secret").Code)
+		if assert.NotNil(t, forwarded) {
+			assert.Empty(t, forwarded.Header.Get("Authorization"))
+		}
+	})
+}
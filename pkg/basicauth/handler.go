@@ -0,0 +1,113 @@
+package basicauth
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRealm is the realm used when no route realm matches.
+const DefaultRealm = "Restricted"
+
+// Handler is a http.Handler that is used as a middleware
+// to protect the origin handler with the HTTP Basic
+// authentication.
+type Handler struct {
+
+	// Users contains the accepted credentials.
+	Users *File
+
+	// Origin is the http handler that will have the authentication
+	// feature in front of it.
+	Origin http.Handler
+
+	// routes contains the protected path prefixes and their realm.
+	// When empty, every request is protected with the default realm.
+	routes []route
+}
+
+// route represents a protected path prefix.
+type route struct {
+	prefix string
+	realm  string
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithRoute protects the requests whose path starts with the prefix,
+// using the given realm. Once a route is configured, the requests
+// matching no route are not protected anymore.
+func WithRoute(prefix, realm string) Option {
+	return func(h *Handler) {
+		h.routes = append(h.routes, route{prefix: prefix, realm: realm})
+
+		// Longest prefixes first, so the most specific route wins.
+		sort.SliceStable(h.routes, func(i, j int) bool {
+			return len(h.routes[i].prefix) > len(h.routes[j].prefix)
+		})
+	}
+}
+
+// NewHandler creates a Basic authentication middleware from an
+// htpasswd file and a http.Handler.
+func NewHandler(users *File, o http.Handler, opts ...Option) *Handler {
+	h := &Handler{
+		Users:  users,
+		Origin: o,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// ServeHTTP adds the authentication in front of the origin handler.
+//
+// The credentials are removed from the request before it is
+// forwarded to the origin handler.
+//
+// More details can be found here: https://tools.ietf.org/html/rfc7617
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	realm, protected := h.realm(request)
+	if !protected {
+		h.Origin.ServeHTTP(writer, request)
+		return
+	}
+
+	user, password, ok := request.BasicAuth()
+	if !ok || !h.Users.Authenticate(user, password) {
+		if ok {
+			logrus.WithContext(request.Context()).WithField("user", user).WithField("resource", request.URL.RequestURI()).Debug("Invalid credentials")
+		}
+		writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm))
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	outgoingRequest := request.Clone(request.Context())
+	outgoingRequest.Header.Del("Authorization")
+
+	h.Origin.ServeHTTP(writer, outgoingRequest)
+}
+
+// realm returns the realm of the most specific route matching the
+// request path, and false if the request is not protected.
+func (h *Handler) realm(request *http.Request) (string, bool) {
+	if len(h.routes) == 0 {
+		return DefaultRealm, true
+	}
+
+	for _, r := range h.routes {
+		if strings.HasPrefix(request.URL.Path, r.prefix) {
+			return r.realm, true
+		}
+	}
+
+	return "", false
+}
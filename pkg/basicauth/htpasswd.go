@@ -0,0 +1,265 @@
+package basicauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when the user is unknown, so an
+// unknown user costs as much time as a wrong password.
+const dummyHash = "$2a$10$HmiPijVX5RtiBiTp6cPUxeVij0xP3yTwHy4PxcqIZwUp5TcfmDdcm"
+
+// File is a set of credentials loaded from an htpasswd file.
+//
+// The supported hash formats are bcrypt ("$2y$"), SHA1 ("{SHA}")
+// and Apache MD5 ("$apr1$"). The file is reloaded when its
+// modification time changes.
+type File struct {
+
+	// path is the htpasswd file location.
+	path string
+
+	// checkInterval is the minimum duration between two checks of
+	// the file modification time.
+	checkInterval time.Duration
+
+	mu        sync.RWMutex
+	users     map[string]string
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewFile loads the htpasswd file located at the given path.
+func NewFile(path string) (*File, error) {
+	f := &File{
+		path:          path,
+		checkInterval: time.Second,
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Authenticate checks the given credentials.
+func (f *File) Authenticate(user, password string) bool {
+	f.reloadIfChanged()
+
+	f.mu.RLock()
+	hash, ok := f.users[user]
+	f.mu.RUnlock()
+
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return false
+	}
+
+	return checkPassword(hash, password)
+}
+
+// reloadIfChanged reloads the file when its modification time
+// changed since the last load. On failure, the previous credentials
+// are kept.
+func (f *File) reloadIfChanged() {
+	f.mu.RLock()
+	recent := time.Since(f.checkedAt) < f.checkInterval
+	f.mu.RUnlock()
+
+	if recent {
+		return
+	}
+
+	f.mu.Lock()
+	f.checkedAt = time.Now()
+	modTime := f.modTime
+	f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		logrus.WithError(err).WithField("path", f.path).Error("Error while checking htpasswd file")
+		return
+	}
+
+	if info.ModTime().Equal(modTime) {
+		return
+	}
+
+	if err := f.load(info.ModTime()); err != nil {
+		logrus.WithError(err).WithField("path", f.path).Error("Error while reloading htpasswd file")
+		return
+	}
+
+	logrus.WithField("path", f.path).Info("Reloaded htpasswd file")
+}
+
+// load reads and parses the htpasswd file.
+func (f *File) load(modTime time.Time) error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.users = users
+	f.modTime = modTime
+	f.checkedAt = time.Now()
+	f.mu.Unlock()
+
+	return nil
+}
+
+// parseHtpasswd parses the "user:hash" lines of an htpasswd file.
+// Empty lines and comments are ignored.
+func parseHtpasswd(data []byte) (map[string]string, error) {
+	users := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		pos := strings.Index(text, ":")
+		if pos <= 0 {
+			return nil, fmt.Errorf("basicauth: invalid htpasswd entry at line %d", line)
+		}
+
+		users[text[:pos]] = text[pos+1:]
+	}
+
+	return users, scanner.Err()
+}
+
+// checkPassword compares the password with the hash in constant time.
+func checkPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+
+	case strings.HasPrefix(hash, apr1Magic):
+		parts := strings.SplitN(strings.TrimPrefix(hash, apr1Magic), "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		computed := apr1([]byte(password), []byte(parts[0]))
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	}
+
+	return false
+}
+
+// apr1Magic is the prefix of the Apache MD5 hashes.
+const apr1Magic = "$apr1$"
+
+// apr1Alphabet is the crypt base64 alphabet.
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 computes the Apache variant of the MD5 crypt hash.
+//
+// More details can be found here: https://httpd.apache.org/docs/2.4/misc/password_encryptions.html
+func apr1(password, salt []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	alternateSum := alternate.Sum(nil)
+
+	d := md5.New()
+	d.Write(password)
+	d.Write([]byte(apr1Magic))
+	d.Write(salt)
+
+	for i := len(password); i > 0; i -= 16 {
+		n := i
+		if n > 16 {
+			n = 16
+		}
+		d.Write(alternateSum[:n])
+	}
+
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(password[:1])
+		}
+	}
+
+	sum := d.Sum(nil)
+
+	// Slows down the brute force attacks.
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(password)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var buf strings.Builder
+	buf.WriteString(apr1Magic)
+	buf.Write(salt)
+	buf.WriteByte('$')
+
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			buf.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[group[0]])<<16|uint(sum[group[1]])<<8|uint(sum[group[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+
+	return buf.String()
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,6 +20,29 @@ type Cache interface {
 	Store(key string, resource *Resource)
 }
 
+// Stats are the statistics of a cache store.
+type Stats struct {
+
+	// Entries is the number of stored responses.
+	Entries int `json:"entries"`
+
+	// Bytes is the size of the stored response bodies.
+	Bytes int64 `json:"bytes"`
+}
+
+// Inspector is implemented by the cache stores able to report their
+// statistics and to remove entries.
+type Inspector interface {
+
+	// Stats returns the statistics of the store.
+	Stats() Stats
+
+	// Purge removes the entries of the resources whose path starts
+	// with the given prefix, and returns their number. An empty prefix
+	// removes all the entries.
+	Purge(prefix string) int
+}
+
 // Resource represents a cache entry that stores the
 // cached response status.
 type Resource struct {
@@ -37,6 +61,11 @@ type Resource struct {
 
 	// cc is the cache control parsed header.
 	cc *CacheControl
+
+	// variants contains the request headers listed in the "Vary"
+	// header of the origin response. When set, the resource is only an
+	// index and the actual responses are stored by variant.
+	variants []string
 }
 
 // Age returns the current age of the resource depending on
@@ -47,18 +76,41 @@ func (r *Resource) Age() time.Duration {
 
 // InMemoryCache is a cache that stores the resources in the
 // program memory.
+//
+// It implements the Inspector interface.
 type InMemoryCache struct {
 
 	// store is a map that represents the resources indexed by
 	// a key.
 	store *sync.Map
+
+	// hooks receives the evictions.
+	hooks Hooks
+}
+
+// StoreOption is a function used to modify
+// the in memory cache behavior.
+type StoreOption func(*InMemoryCache)
+
+// WithStoreHooks sends the evictions of the store to the given hooks.
+func WithStoreHooks(hooks Hooks) StoreOption {
+	return func(i *InMemoryCache) {
+		i.hooks = hooks
+	}
 }
 
 // NewInMemoryCache creates an in memory cache.
-func NewInMemoryCache() *InMemoryCache {
-	return &InMemoryCache{
+func NewInMemoryCache(opts ...StoreOption) *InMemoryCache {
+	i := &InMemoryCache{
 		store: &sync.Map{},
+		hooks: NopHooks{},
+	}
+
+	for _, opt := range opts {
+		opt(i)
 	}
+
+	return i
 }
 
 // Get is the `Cache` interface implementation.
@@ -75,3 +127,35 @@ func (i *InMemoryCache) Get(key string) *Resource {
 func (i *InMemoryCache) Store(key string, resource *Resource) {
 	i.store.Store(key, resource)
 }
+
+// Stats is the `Inspector` interface implementation.
+func (i *InMemoryCache) Stats() Stats {
+	var stats Stats
+	i.store.Range(func(_, value interface{}) bool {
+		resource := value.(*Resource)
+		if len(resource.variants) == 0 {
+			stats.Entries++
+			stats.Bytes += int64(len(resource.Body))
+		}
+		return true
+	})
+
+	return stats
+}
+
+// Purge is the `Inspector` interface implementation.
+func (i *InMemoryCache) Purge(prefix string) int {
+	purged := 0
+	i.store.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			i.store.Delete(key)
+			if resource := value.(*Resource); len(resource.variants) == 0 {
+				i.hooks.OnCacheEvict(key.(string), resource)
+				purged++
+			}
+		}
+		return true
+	})
+
+	return purged
+}
@@ -1,6 +1,9 @@
 package cache
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
 
 // Request is a wrapper around a http.Request.
 // It helps to deal with the cache mechanisms.
@@ -52,8 +55,26 @@ func (r *Request) IsCacheable() bool {
 	return true
 }
 
+// variantKey generates an unique identifier for the wrapped request
+// that also depends on the values of the given request headers.
+func (r *Request) variantKey(headers []string) string {
+	var b strings.Builder
+	b.WriteString(r.key)
+
+	for _, header := range headers {
+		b.WriteString("\n")
+		b.WriteString(header)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.request.Header.Values(header), ","))
+	}
+
+	return b.String()
+}
+
 // generateRequestKey generates an unique identifier
-// from a http.Request.
+// from a http.Request. The key starts with the path, so the
+// entries can be purged by path prefix, and holds the host, so
+// the virtual hosts do not share their entries.
 func generateRequestKey(r *http.Request) string {
-	return r.URL.RequestURI() + r.URL.RawQuery
+	return r.URL.RequestURI() + "\nHost:" + strings.ToLower(r.Host)
 }
@@ -29,4 +29,16 @@ func TestInMemoryCache_Store(t *testing.T) {
 	r, ok := cache.store.Load("test-key")
 	assert.True(t, ok)
 	assert.Equal(t, resource, r)
-}
\ No newline at end of file
+}
+
+func TestResourceWriter_Resource(t *testing.T) {
+	t.Run("Empty response", func(t *testing.T) {
+		assert.Equal(t, 200, NewResourceWriter().Resource().Status)
+	})
+
+	t.Run("Written status", func(t *testing.T) {
+		rw := NewResourceWriter()
+		rw.WriteHeader(404)
+		assert.Equal(t, 404, rw.Resource().Status)
+	})
+}
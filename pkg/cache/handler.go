@@ -1,11 +1,16 @@
 package cache
 
 import (
+	"context"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 
-	"github.com/sirupsen/logrus"
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
 )
 
 // cacheableStatus is the default list of the
@@ -34,15 +39,33 @@ type Handler struct {
 	// help to have customized response status for the current cache
 	// instance.
 	cacheableStatus map[int]bool
+
+	// metrics records the lookups when set.
+	metrics Metrics
+
+	// tracer creates the lookup spans when set.
+	tracer *tracing.Tracer
+
+	// hooks receives the lookup and store events.
+	hooks Hooks
+
+	// logger writes the debug messages.
+	logger logging.Logger
+
+	// namespace separates the entries of the handler from the ones
+	// of the other handlers sharing the cache storage.
+	namespace string
 }
 
 // NewHandler creates a cache middle instance from a cache storage
 // behavior and a http.Handler.
-func NewHandler(c Cache, o http.Handler) *Handler {
+func NewHandler(c Cache, o http.Handler, opts ...Option) *Handler {
 	h := &Handler{
 		Cache:           c,
 		Origin:          o,
 		cacheableStatus: make(map[int]bool, len(cacheableStatus)),
+		hooks:           NopHooks{},
+		logger:          logging.Default(),
 	}
 
 	// Deep copy of the cacheableStatus global variable.
@@ -50,6 +73,10 @@ func NewHandler(c Cache, o http.Handler) *Handler {
 		h.cacheableStatus[k] = v
 	}
 
+	for _, opt := range opts {
+		opt(h)
+	}
+
 	return h
 }
 
@@ -63,12 +90,22 @@ func NewHandler(c Cache, o http.Handler) *Handler {
 //
 // More details can be found here: https://tools.ietf.org/html/rfc7234
 func (h *Handler) ServeHTTP(writer http.ResponseWriter, r *http.Request) {
+	// The span covers the lookup only, it is ended with its status.
+	_, span := h.tracer.Start(r.Context(), "cache lookup", tracing.SpanKindInternal,
+		tracing.String("cache.key", r.URL.RequestURI()),
+	)
+	defer span.End()
+
 	request := NewRequest(r)
+	if len(h.namespace) > 0 {
+		request.key += "\nNamespace:" + h.namespace
+	}
 
 	// If the incoming request is not cacheable for some reasons,
 	// we directly forward the request to the origin server.
 	if !request.IsCacheable() {
-		logrus.Debug("Not cacheable")
+		h.logger.Log(r.Context(), logging.LevelDebug, "Not cacheable", nil)
+		h.lookup(r.Context(), span, LookupBypass)
 		h.Origin.ServeHTTP(writer, request.request)
 		return
 	}
@@ -94,21 +131,30 @@ func (h *Handler) ServeHTTP(writer http.ResponseWriter, r *http.Request) {
 			}
 
 			if resource.Age() < acceptedMaxAge {
-				logrus.Debug("Forwarding resource to client")
+				h.logger.Log(r.Context(), logging.LevelDebug, "Forwarding resource to client", nil)
+				h.lookup(r.Context(), span, LookupHit)
+				h.hooks.OnCacheHit(r, resource)
 				forwardResource(resource, writer)
 				return
 			}
 
+			h.lookup(r.Context(), span, LookupStale)
+
 			// TODO: Smooth validation and update resource freshness (304, ETag...).
 
 		} else {
-			logrus.Debug("Forwarding resource to client")
+			h.logger.Log(r.Context(), logging.LevelDebug, "Forwarding resource to client", nil)
+			h.lookup(r.Context(), span, LookupHit)
+			h.hooks.OnCacheHit(r, resource)
 			forwardResource(resource, writer)
 			return
 		}
+	} else {
+		h.lookup(r.Context(), span, LookupMiss)
 	}
 
-	logrus.WithField("resource", request.request.URL.RequestURI()).Debug("No resources matched in cache")
+	h.logger.Log(r.Context(), logging.LevelDebug, "No resources matched in cache", logging.Fields{"resource": request.request.URL.RequestURI()})
+	h.hooks.OnCacheMiss(r)
 
 	// Only cached is a client option that force the request to use the
 	// cached response. So, if the resource is not available, we send back
@@ -121,6 +167,17 @@ func (h *Handler) ServeHTTP(writer http.ResponseWriter, r *http.Request) {
 	h.forwardToOrigin(writer, request)
 }
 
+// lookup records the cache status of a request, and ends its span.
+func (h *Handler) lookup(ctx context.Context, span *tracing.Span, status LookupStatus) {
+	span.SetAttributes(tracing.String("cache.status", string(status)))
+	span.End()
+	accesslog.SetCacheStatus(ctx, string(status))
+
+	if h.metrics != nil {
+		h.metrics.Lookup(status)
+	}
+}
+
 // forwardToOrigin sends the request to the origin server and try
 // to save the response in the cache.
 func (h *Handler) forwardToOrigin(writer http.ResponseWriter, request *Request) {
@@ -133,14 +190,32 @@ func (h *Handler) forwardToOrigin(writer http.ResponseWriter, request *Request)
 		return
 	}
 
-	logrus.WithField("resource", request.request.URL.RequestURI()).Debug("Storing resource in cache")
-	h.Cache.Store(request.key, resource)
+	h.logger.Log(request.request.Context(), logging.LevelDebug, "Storing resource in cache", logging.Fields{"resource": request.request.URL.RequestURI()})
+
+	variants := varyHeaders(resource.Headers)
+	if len(variants) == 0 {
+		h.Cache.Store(request.key, resource)
+		h.hooks.OnCacheStore(request.key, resource)
+		return
+	}
+
+	// The response depends on some request headers, so each variant is
+	// stored separately and indexed by the request key.
+	key := request.variantKey(variants)
+	h.Cache.Store(request.key, &Resource{Date: resource.Date, cc: resource.cc, variants: variants})
+	h.Cache.Store(key, resource)
+	h.hooks.OnCacheStore(key, resource)
 }
 
 // load gets the resource from the cache that matches the
 // given request.
 func (h *Handler) load(request *Request) *Resource {
-	return h.Cache.Get(request.key)
+	resource := h.Cache.Get(request.key)
+	if resource == nil || len(resource.variants) == 0 {
+		return resource
+	}
+
+	return h.Cache.Get(request.variantKey(resource.variants))
 }
 
 // isResourceCacheable checks if the given response resource can be
@@ -165,9 +240,44 @@ func (h *Handler) isResourceCacheable(resource *Resource) bool {
 		return false
 	}
 
+	// A response that varies on everything cannot be served again.
+	for _, header := range varyHeaders(resource.Headers) {
+		if header == "*" {
+			return false
+		}
+	}
+
 	return true
 }
 
+// varyHeaders returns the canonical request header names listed in
+// the "Vary" response header.
+func varyHeaders(headers http.Header) []string {
+	var names []string
+	for _, value := range headers.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if len(name) > 0 && !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// contains checks if the value is present in the values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
 // forwardResource pipe the given resource to the given writer.
 // It adds the cache HTTP headers if needed (e.g "Age").
 func forwardResource(r *Resource, writer http.ResponseWriter) {
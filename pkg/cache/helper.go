@@ -28,7 +28,7 @@ func (r *ResourceWriter) Header() http.Header {
 
 // Write is the "http.ResponseWriter" interface implementation.
 func (r *ResourceWriter) Write(bytes []byte) (int, error) {
-	r.body = bytes
+	r.body = append(r.body, bytes...)
 
 	if r.status == 0 {
 		r.status = http.StatusOK
@@ -46,8 +46,15 @@ func (r *ResourceWriter) WriteHeader(statusCode int) {
 // NOTE: This function has to be used only when the origin
 //       server finished to write the response into.
 func (r *ResourceWriter) Resource() *Resource {
+	// An origin that neither writes a header nor a body answers with
+	// an empty 200 response.
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
 	return &Resource{
-		Status:  r.status,
+		Status:  status,
 		Headers: r.headers,
 		Body:    r.body,
 		Date:    time.Now(),
@@ -0,0 +1,50 @@
+package cache
+
+import "net/http"
+
+// Hooks receives the cache events, to build a custom instrumentation.
+// The calls are made in the goroutine of the request, so they must not
+// block.
+//
+// NopHooks can be embedded to implement only some of the methods.
+type Hooks interface {
+
+	// OnCacheHit is called when a request is answered from the cache.
+	OnCacheHit(request *http.Request, resource *Resource)
+
+	// OnCacheMiss is called when a request is forwarded to the origin
+	// server, no fresh response being stored.
+	OnCacheMiss(request *http.Request)
+
+	// OnCacheStore is called when a response is stored.
+	OnCacheStore(key string, resource *Resource)
+
+	// OnCacheEvict is called when a response is removed from the
+	// store.
+	OnCacheEvict(key string, resource *Resource)
+}
+
+// NopHooks is a Hooks implementation doing nothing.
+type NopHooks struct{}
+
+// Static implementation checker.
+var _ Hooks = NopHooks{}
+
+// OnCacheHit is the `Hooks` interface implementation.
+func (NopHooks) OnCacheHit(*http.Request, *Resource) {}
+
+// OnCacheMiss is the `Hooks` interface implementation.
+func (NopHooks) OnCacheMiss(*http.Request) {}
+
+// OnCacheStore is the `Hooks` interface implementation.
+func (NopHooks) OnCacheStore(string, *Resource) {}
+
+// OnCacheEvict is the `Hooks` interface implementation.
+func (NopHooks) OnCacheEvict(string, *Resource) {}
+
+// WithHooks sends the lookup and store events to the given hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(h *Handler) {
+		h.hooks = hooks
+	}
+}
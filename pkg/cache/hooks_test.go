@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingHooks records the names of the cache events.
+type recordingHooks struct {
+	NopHooks
+	events []string
+}
+
+func (r *recordingHooks) OnCacheHit(request *http.Request, resource *Resource) {
+	r.events = append(r.events, "hit "+request.URL.Path)
+}
+
+func (r *recordingHooks) OnCacheMiss(request *http.Request) {
+	r.events = append(r.events, "miss "+request.URL.Path)
+}
+
+func (r *recordingHooks) OnCacheStore(key string, resource *Resource) {
+	r.events = append(r.events, "store "+key)
+}
+
+func (r *recordingHooks) OnCacheEvict(key string, resource *Resource) {
+	r.events = append(r.events, "evict "+key)
+}
+
+func TestHandler_Hooks(t *testing.T) {
+	hooks := &recordingHooks{}
+	store := NewInMemoryCache(WithStoreHooks(hooks))
+
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = writer.Write([]byte("data"))
+	})
+	h := NewHandler(store, origin, WithHooks(hooks), WithLogger(logging.Discard))
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/data", nil))
+	}
+	assert.Equal(t, 1, store.Purge("/data"))
+
+	key := NewRequest(httptest.NewRequest("GET", "/data", nil)).key
+	assert.Equal(t, []string{"miss /data", "store " + key, "hit /data", "evict " + key}, hooks.events)
+}
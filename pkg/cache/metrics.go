@@ -0,0 +1,34 @@
+package cache
+
+// LookupStatus is the outcome of the cache lookup of a request.
+type LookupStatus string
+
+const (
+	// LookupHit is a request answered from the cache.
+	LookupHit LookupStatus = "hit"
+
+	// LookupMiss is a request without cached response.
+	LookupMiss LookupStatus = "miss"
+
+	// LookupStale is a request whose cached response is too old, and
+	// is forwarded to the origin server.
+	LookupStale LookupStatus = "stale"
+
+	// LookupBypass is a request that cannot be cached, and is directly
+	// forwarded to the origin server.
+	LookupBypass LookupStatus = "bypass"
+)
+
+// Metrics records the cache lookups.
+type Metrics interface {
+
+	// Lookup is called with the cache status of each request.
+	Lookup(status LookupStatus)
+}
+
+// WithMetrics records the lookups in the given metrics.
+func WithMetrics(m Metrics) Option {
+	return func(h *Handler) {
+		h.metrics = m
+	}
+}
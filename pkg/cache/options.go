@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+)
+
+// Option is a function used to modify
+// the handler behavior.
+type Option func(*Handler)
+
+// WithTracer creates a span for the lookup of each request.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(h *Handler) {
+		h.tracer = t
+	}
+}
+
+// WithNamespace separates the entries of the handler from the ones of
+// the other handlers sharing its cache storage (e.g. the route name).
+func WithNamespace(namespace string) Option {
+	return func(h *Handler) {
+		h.namespace = namespace
+	}
+}
+
+// WithLogger sets the logger of the handler, the standard logrus
+// logger by default.
+func WithLogger(l logging.Logger) Option {
+	return func(h *Handler) {
+		h.logger = l
+	}
+}
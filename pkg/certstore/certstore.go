@@ -0,0 +1,340 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWatchInterval is the default interval between two checks of
+// the certificate files.
+const DefaultWatchInterval = 5 * time.Second
+
+// Info describes a loaded certificate.
+type Info struct {
+
+	// File is the certificate file.
+	File string `json:"file"`
+
+	// Names are the DNS names and IP addresses of the certificate, or
+	// its common name when it has none.
+	Names []string `json:"names"`
+
+	// NotBefore and NotAfter bound the validity of the certificate.
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+
+	// Default reports whether the certificate is served to the clients
+	// without or with an unknown server name.
+	Default bool `json:"default"`
+}
+
+// Lister lists certificates, e.g. the ones of a listener.
+type Lister interface {
+
+	// Certificates describes the certificates.
+	Certificates() []Info
+}
+
+// pair is a certificate file with its key file.
+type pair struct {
+	certificate string
+	key         string
+}
+
+// entry is a loaded certificate.
+type entry struct {
+	info        Info
+	certificate *tls.Certificate
+}
+
+// Store holds the certificates of a listener. It is safe for
+// concurrent use.
+type Store struct {
+	pairs       []pair
+	directories []string
+	defaultName string
+
+	mu       sync.RWMutex
+	entries  []*entry
+	names    map[string]*entry
+	fallback *entry
+}
+
+// Static implementation checker.
+var _ Lister = (*Store)(nil)
+
+// Option is a function used to modify
+// the store behavior.
+type Option func(*Store)
+
+// WithCertificate adds a certificate and key pair of PEM files.
+func WithCertificate(certificate, key string) Option {
+	return func(s *Store) {
+		s.pairs = append(s.pairs, pair{certificate: certificate, key: key})
+	}
+}
+
+// WithDirectory adds the certificates of a directory: the "name.crt"
+// or "name.pem" files with their "name.key" file, or a "name.pem" file
+// holding both the certificate and the key.
+func WithDirectory(dir string) Option {
+	return func(s *Store) {
+		s.directories = append(s.directories, dir)
+	}
+}
+
+// WithDefault sets the server name whose certificate is served to the
+// clients without or with an unknown server name. The first loaded
+// certificate is served otherwise.
+func WithDefault(name string) Option {
+	return func(s *Store) {
+		s.defaultName = strings.ToLower(name)
+	}
+}
+
+// New creates a store and loads its certificates.
+func New(opts ...Option) (*Store, error) {
+	s := &Store{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load reads all the certificates. On error, the current certificates
+// are kept.
+func (s *Store) Load() error {
+	pairs, err := s.files()
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		return errors.New("certstore: no certificate found")
+	}
+
+	entries := make([]*entry, 0, len(pairs))
+	names := make(map[string]*entry)
+	for _, p := range pairs {
+		e, err := load(p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+
+		// The certificate expiring last wins, to allow the overlapping
+		// of a renewed certificate with the previous one.
+		for _, name := range e.info.Names {
+			if current, ok := names[name]; !ok || e.info.NotAfter.After(current.info.NotAfter) {
+				names[name] = e
+			}
+		}
+	}
+
+	fallback := entries[0]
+	if len(s.defaultName) > 0 {
+		if fallback = lookup(names, s.defaultName); fallback == nil {
+			return fmt.Errorf("certstore: no certificate for the default name %q", s.defaultName)
+		}
+	}
+	fallback.info.Default = true
+
+	s.mu.Lock()
+	s.entries, s.names, s.fallback = entries, names, fallback
+	s.mu.Unlock()
+	return nil
+}
+
+// files returns the certificate and key pairs to load, the ones of the
+// directories in name order.
+func (s *Store) files() ([]pair, error) {
+	pairs := append([]pair(nil), s.pairs...)
+
+	for _, dir := range s.directories {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("certstore: %w", err)
+		}
+
+		present := make(map[string]bool)
+		for _, f := range files {
+			present[f.Name()] = true
+		}
+
+		for _, f := range files {
+			ext := filepath.Ext(f.Name())
+			if f.IsDir() || (ext != ".crt" && ext != ".pem") {
+				continue
+			}
+
+			key := strings.TrimSuffix(f.Name(), ext) + ".key"
+			switch {
+			case present[key]:
+			case ext == ".pem":
+				key = f.Name()
+			default:
+				return nil, fmt.Errorf("certstore: no key file for %q", filepath.Join(dir, f.Name()))
+			}
+
+			pairs = append(pairs, pair{certificate: filepath.Join(dir, f.Name()), key: filepath.Join(dir, key)})
+		}
+	}
+
+	return pairs, nil
+}
+
+// load reads a certificate and key pair.
+func load(p pair) (*entry, error) {
+	certificate, err := tls.LoadX509KeyPair(p.certificate, p.key)
+	if err != nil {
+		return nil, fmt.Errorf("certstore: %s: %w", p.certificate, err)
+	}
+
+	leaf := certificate.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("certstore: %s: %w", p.certificate, err)
+		}
+		certificate.Leaf = leaf
+	}
+
+	return &entry{
+		info: Info{
+			File:      p.certificate,
+			Names:     names(leaf),
+			NotBefore: leaf.NotBefore,
+			NotAfter:  leaf.NotAfter,
+		},
+		certificate: &certificate,
+	}, nil
+}
+
+// names returns the lower-cased names of a certificate.
+func names(leaf *x509.Certificate) []string {
+	var list []string
+	for _, name := range leaf.DNSNames {
+		list = append(list, strings.ToLower(name))
+	}
+	for _, ip := range leaf.IPAddresses {
+		list = append(list, ip.String())
+	}
+	if len(list) == 0 && len(leaf.Subject.CommonName) > 0 {
+		list = append(list, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return list
+}
+
+// lookup returns the certificate of a server name, or of its wildcard
+// name, or nil.
+func lookup(names map[string]*entry, name string) *entry {
+	if e, ok := names[name]; ok {
+		return e
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if e, ok := names["*"+name[i:]]; ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// GetCertificate returns the certificate of the server name of the
+// connection, the default one when the name is unknown. It is meant to
+// be the `tls.Config.GetCertificate` function.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if e := lookup(s.names, name); e != nil {
+		return e.certificate, nil
+	}
+	return s.fallback.certificate, nil
+}
+
+// Certificates describes the loaded certificates, in loading order.
+func (s *Store) Certificates() []Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]Info, len(s.entries))
+	for i, e := range s.entries {
+		infos[i] = e.info
+		infos[i].Names = append([]string(nil), e.info.Names...)
+	}
+	return infos
+}
+
+// Watch reloads the certificates each time a file changes, is added
+// or removed, until stop is closed. After a failed reload, the
+// previous certificates are served until the next change.
+func (s *Store) Watch(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	state := s.state()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := s.state()
+		if current == state {
+			continue
+		}
+		state = current
+
+		if err := s.Load(); err != nil {
+			logrus.WithError(err).Error("Error while reloading certificates, keeping the previous ones")
+			continue
+		}
+		logrus.WithField("certificates", len(s.Certificates())).Info("Certificates reloaded")
+	}
+}
+
+// state returns the names, sizes and modification times of the
+// watched files.
+func (s *Store) state() string {
+	var files []string
+	for _, p := range s.pairs {
+		files = append(files, p.certificate, p.key)
+	}
+	for _, dir := range s.directories {
+		list, _ := ioutil.ReadDir(dir)
+		for _, f := range list {
+			files = append(files, filepath.Join(dir, f.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	for _, file := range files {
+		b.WriteString(file)
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, ":%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
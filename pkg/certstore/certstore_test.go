@@ -0,0 +1,172 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes a self-signed certificate of the names, and
+// its key, to the given files. The key is appended to the certificate
+// file when both are the same.
+func writeCertificate(t *testing.T, certFile, keyFile string, notAfter time.Time, names ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if certFile == keyFile {
+		certPEM = append(certPEM, keyPEM...)
+	} else if err := ioutil.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serverName returns the first name of the certificate served for a
+// server name.
+func serverName(s *Store, name string) string {
+	certificate, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		return err.Error()
+	}
+	return certificate.Leaf.DNSNames[0]
+}
+
+func TestStore_GetCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	expiry := time.Now().Add(24 * time.Hour)
+	writeCertificate(t, filepath.Join(dir, "main.crt"), filepath.Join(dir, "main.key"), expiry, "example.com")
+	certs := filepath.Join(dir, "certs")
+	if !assert.NoError(t, os.Mkdir(certs, 0o700)) {
+		return
+	}
+	writeCertificate(t, filepath.Join(certs, "api.crt"), filepath.Join(certs, "api.key"), expiry, "api.example.com")
+	writeCertificate(t, filepath.Join(certs, "wildcard.pem"), filepath.Join(certs, "wildcard.pem"), expiry, "*.example.com")
+	writeCertificate(t, filepath.Join(certs, "other.pem"), filepath.Join(certs, "other.key"), expiry, "example.org")
+
+	s, err := New(WithCertificate(filepath.Join(dir, "main.crt"), filepath.Join(dir, "main.key")), WithDirectory(certs))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "example.com", serverName(s, "example.com"))
+	assert.Equal(t, "api.example.com", serverName(s, "API.example.com."))
+	assert.Equal(t, "*.example.com", serverName(s, "www.example.com"))
+	assert.Equal(t, "example.org", serverName(s, "example.org"))
+	assert.Equal(t, "example.com", serverName(s, "a.b.example.com"))
+	assert.Equal(t, "example.com", serverName(s, ""))
+
+	infos := s.Certificates()
+	if assert.Len(t, infos, 4) {
+		assert.Equal(t, filepath.Join(dir, "main.crt"), infos[0].File)
+		assert.True(t, infos[0].Default)
+		assert.Equal(t, []string{"api.example.com"}, infos[1].Names)
+		assert.Equal(t, expiry.Unix(), infos[1].NotAfter.Unix())
+		assert.False(t, infos[1].Default)
+	}
+
+	s, err = New(WithDirectory(certs), WithDefault("example.org"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "example.org", serverName(s, "unknown.test"))
+	}
+
+	_, err = New(WithDirectory(certs), WithDefault("example.net"))
+	assert.EqualError(t, err, `certstore: no certificate for the default name "example.net"`)
+
+	empty := filepath.Join(dir, "empty")
+	if assert.NoError(t, os.Mkdir(empty, 0o700)) {
+		_, err = New(WithDirectory(empty))
+		assert.EqualError(t, err, `certstore: no certificate found`)
+	}
+}
+
+func TestStore_LatestExpiryWins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	renewed := time.Now().Add(90 * 24 * time.Hour)
+	writeCertificate(t, filepath.Join(dir, "a.pem"), filepath.Join(dir, "a.pem"), renewed, "example.com")
+	writeCertificate(t, filepath.Join(dir, "b.pem"), filepath.Join(dir, "b.pem"), time.Now().Add(time.Hour), "example.com")
+
+	s, err := New(WithDirectory(dir))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	certificate, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, renewed.Unix(), certificate.Leaf.NotAfter.Unix())
+	}
+}
+
+func TestStore_Watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	writeCertificate(t, filepath.Join(dir, "a.pem"), filepath.Join(dir, "a.pem"), time.Now().Add(time.Hour), "a.example.com")
+
+	s, err := New(WithDirectory(dir))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Watch(10*time.Millisecond, stop)
+
+	// A certificate without its key is not loaded, until the key is
+	// written.
+	writeCertificate(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.tmp"), time.Now().Add(time.Hour), "b.example.com")
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, s.Certificates(), 1)
+
+	assert.NoError(t, os.Rename(filepath.Join(dir, "b.tmp"), filepath.Join(dir, "b.key")))
+	assert.Eventually(t, func() bool {
+		return len(s.Certificates()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "b.example.com", serverName(s, "b.example.com"))
+}
@@ -0,0 +1,215 @@
+package clientcert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The default request headers of the client certificate.
+const (
+	DefaultSubjectHeader     = "X-Client-Cert-Subject"
+	DefaultSANsHeader        = "X-Client-Cert-SANs"
+	DefaultFingerprintHeader = "X-Client-Cert-Fingerprint"
+)
+
+// Headers are the request headers the verified client certificate is
+// forwarded to the upstreams in.
+type Headers struct {
+
+	// Subject holds the subject distinguished name (e.g.
+	// "CN=billing,O=Example").
+	Subject string
+
+	// SANs holds the subject alternative names, comma separated and
+	// prefixed by their type (e.g. "DNS:billing.internal,
+	// URI:spiffe://example.com/billing").
+	SANs string
+
+	// Fingerprint holds the hexadecimal SHA-256 fingerprint of the
+	// certificate.
+	Fingerprint string
+}
+
+// DefaultHeaders are the headers used when none is configured.
+var DefaultHeaders = Headers{
+	Subject:     DefaultSubjectHeader,
+	SANs:        DefaultSANsHeader,
+	Fingerprint: DefaultFingerprintHeader,
+}
+
+// Forward sets the headers of the verified client certificate of the
+// requests before passing them to next. The headers sent by the
+// clients are removed first, along with the strip ones (e.g. the
+// headers of the other listeners), so the upstreams can trust them.
+func Forward(headers Headers, next http.Handler, strip ...string) http.Handler {
+	names := append([]string{headers.Subject, headers.SANs, headers.Fingerprint}, strip...)
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		cert := Certificate(request)
+		if cert == nil && !hasAny(request.Header, names) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		outgoingRequest := request.Clone(request.Context())
+		for _, name := range names {
+			outgoingRequest.Header.Del(name)
+		}
+		if cert != nil {
+			outgoingRequest.Header.Set(headers.Subject, cert.Subject.String())
+			if sans := SANs(cert); len(sans) > 0 {
+				outgoingRequest.Header.Set(headers.SANs, strings.Join(sans, ", "))
+			}
+			outgoingRequest.Header.Set(headers.Fingerprint, Fingerprint(cert))
+		}
+
+		next.ServeHTTP(writer, outgoingRequest)
+	})
+}
+
+// Certificate returns the verified client certificate of a request, or
+// nil.
+func Certificate(request *http.Request) *x509.Certificate {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return request.TLS.VerifiedChains[0][0]
+}
+
+// SANs returns the subject alternative names of a certificate,
+// prefixed by their type: "DNS:", "email:", "IP:" or "URI:".
+func SANs(cert *x509.Certificate) []string {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return sans
+}
+
+// Fingerprint returns the hexadecimal SHA-256 fingerprint of a
+// certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Handler is a http.Handler that is used as a middleware
+// to require a verified client certificate.
+//
+// The requests without certificate, or whose certificate matches none
+// of the allowed names, are rejected with a 403 Forbidden status.
+type Handler struct {
+
+	// Origin is the http handler that will have the authentication
+	// feature in front of it.
+	Origin http.Handler
+
+	// commonNames are the allowed subject common names.
+	commonNames map[string]bool
+
+	// sans are the allowed subject alternative names, with their type
+	// prefix.
+	sans map[string]bool
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithCommonNames allows the certificates with one of the subject
+// common names.
+func WithCommonNames(names ...string) Option {
+	return func(h *Handler) {
+		for _, name := range names {
+			h.commonNames[name] = true
+		}
+	}
+}
+
+// WithSANs allows the certificates with one of the subject
+// alternative names, written with their type prefix (e.g.
+// "DNS:billing.internal"), see SANs.
+func WithSANs(sans ...string) Option {
+	return func(h *Handler) {
+		for _, san := range sans {
+			h.sans[san] = true
+		}
+	}
+}
+
+// NewHandler creates a client certificate middleware from a
+// http.Handler. Without allowed names, any verified certificate is
+// accepted.
+func NewHandler(o http.Handler, opts ...Option) *Handler {
+	h := &Handler{
+		Origin:      o,
+		commonNames: make(map[string]bool),
+		sans:        make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// ServeHTTP adds the authentication in front of the origin handler.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	cert := Certificate(request)
+	if cert == nil {
+		logrus.WithContext(request.Context()).WithField("resource", request.URL.RequestURI()).Debug("Missing client certificate")
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !h.allowed(cert) {
+		logrus.WithContext(request.Context()).WithField("subject", cert.Subject.String()).WithField("resource", request.URL.RequestURI()).Debug("Client certificate not allowed")
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h.Origin.ServeHTTP(writer, request)
+}
+
+// allowed reports whether the certificate has one of the allowed
+// names.
+func (h *Handler) allowed(cert *x509.Certificate) bool {
+	if len(h.commonNames) == 0 && len(h.sans) == 0 {
+		return true
+	}
+
+	if h.commonNames[cert.Subject.CommonName] {
+		return true
+	}
+	for _, san := range SANs(cert) {
+		if h.sans[san] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAny reports whether one of the headers is set.
+func hasAny(header http.Header, names []string) bool {
+	for _, name := range names {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package clientcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func certificate(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// request returns a request verified with the certificate, if any.
+func request(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	if cert != nil {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return r
+}
+
+func TestForward(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/billing")
+	cert := certificate(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames:       []string{"billing.internal"},
+		EmailAddresses: []string{"billing@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+	})
+
+	var received http.Header
+	h := Forward(Headers{Subject: "X-Subject", SANs: "X-SANs", Fingerprint: "X-Fingerprint"},
+		http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+			received = request.Header
+		}), "X-Client-Cert-Subject")
+
+	spoofed := request(nil)
+	spoofed.Header.Set("X-Subject", "CN=admin")
+	spoofed.Header.Set("X-Fingerprint", "00")
+	spoofed.Header.Set("X-Client-Cert-Subject", "CN=admin")
+	spoofed.Header.Set("X-Client-Cert-SANs", "DNS:admin.internal")
+	h.ServeHTTP(httptest.NewRecorder(), spoofed)
+	assert.Equal(t, http.Header{"X-Client-Cert-Sans": {"DNS:admin.internal"}}, received)
+	assert.Equal(t, "CN=admin", spoofed.Header.Get("X-Subject"), "the incoming request is unchanged")
+
+	verified := request(cert)
+	verified.Header.Set("X-SANs", "DNS:admin.internal")
+	h.ServeHTTP(httptest.NewRecorder(), verified)
+	assert.Equal(t, "CN=billing,O=Example", received.Get("X-Subject"))
+	assert.Equal(t, "DNS:billing.internal, email:billing@example.com, IP:10.0.0.1, URI:spiffe://example.com/billing", received.Get("X-SANs"))
+	assert.Equal(t, Fingerprint(cert), received.Get("X-Fingerprint"))
+	assert.Len(t, Fingerprint(cert), 64)
+}
+
+func TestHandler(t *testing.T) {
+	billing := certificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})
+	reports := certificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "reports"}, DNSNames: []string{"reports.internal"}})
+	other := certificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"other.internal"}})
+
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		cert    *x509.Certificate
+		want    int
+	}{
+		{name: "Missing certificate", handler: NewHandler(origin), want: http.StatusForbidden},
+		{name: "Any certificate", handler: NewHandler(origin), cert: other, want: http.StatusNoContent},
+		{name: "Common name", handler: NewHandler(origin, WithCommonNames("billing"), WithSANs("DNS:reports.internal")), cert: billing, want: http.StatusNoContent},
+		{name: "SAN", handler: NewHandler(origin, WithCommonNames("billing"), WithSANs("DNS:reports.internal")), cert: reports, want: http.StatusNoContent},
+		{name: "Not allowed", handler: NewHandler(origin, WithCommonNames("billing"), WithSANs("DNS:reports.internal")), cert: other, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, request(tt.cert))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	// Without TLS.
+	rec := httptest.NewRecorder()
+	NewHandler(origin).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Nil(t, Certificate(&http.Request{TLS: &tls.ConnectionState{}}))
+}
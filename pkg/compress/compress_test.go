@@ -0,0 +1,171 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_negotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "Empty header", acceptEncoding: "", want: ""},
+		{name: "Single coding", acceptEncoding: "gzip", want: Gzip},
+		{name: "Server preference", acceptEncoding: "gzip, deflate, br, zstd", want: Brotli},
+		{name: "Client quality", acceptEncoding: "br;q=0.5, gzip", want: Gzip},
+		{name: "Refused coding", acceptEncoding: "br;q=0, zstd;q=0, gzip;q=0", want: ""},
+		{name: "Wildcard", acceptEncoding: "*", want: Brotli},
+		{name: "Wildcard with refused coding", acceptEncoding: "br;q=0, *;q=0.1", want: Zstd},
+		{name: "Unsupported coding", acceptEncoding: "deflate", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiate(tt.acceptEncoding, DefaultEncodings))
+		})
+	}
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	var data []byte
+	var err error
+
+	switch encoding {
+	case Gzip:
+		r, e := gzip.NewReader(bytes.NewReader(body))
+		if e != nil {
+			t.Fatal(e)
+		}
+		data, err = ioutil.ReadAll(r)
+	case Brotli:
+		data, err = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+	case Zstd:
+		r, e := zstd.NewReader(bytes.NewReader(body))
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer r.Close()
+		data, err = ioutil.ReadAll(r)
+	default:
+		data = body
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestHandler(t *testing.T) {
+	large := strings.Repeat("compressible content ", 200)
+
+	origin := http.NewServeMux()
+	origin.HandleFunc("/large", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain")
+		writer.Header().Set("ETag", `"v1"`)
+		// Written in several chunks.
+		_, _ = writer.Write([]byte(large[:100]))
+		_, _ = writer.Write([]byte(large[100:]))
+	})
+	origin.HandleFunc("/small", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain")
+		_, _ = writer.Write([]byte("small"))
+	})
+	origin.HandleFunc("/image", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "image/png")
+		_, _ = writer.Write([]byte(large))
+	})
+	origin.HandleFunc("/encoded", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain")
+		writer.Header().Set("Content-Encoding", "deflate")
+		_, _ = writer.Write([]byte(large))
+	})
+
+	h, err := NewHandler(origin)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	for _, encoding := range DefaultEncodings {
+		t.Run("Compressed with "+encoding, func(t *testing.T) {
+			recorder := serve("/large", encoding)
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, encoding, recorder.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+			assert.Equal(t, `"v1-`+encoding+`"`, recorder.Header().Get("ETag"))
+			assert.Less(t, recorder.Body.Len(), len(large))
+			assert.Equal(t, large, decode(t, encoding, recorder.Body.Bytes()))
+		})
+	}
+
+	t.Run("Not accepted coding", func(t *testing.T) {
+		recorder := serve("/large", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		assert.Equal(t, `"v1"`, recorder.Header().Get("ETag"))
+		assert.Equal(t, large, recorder.Body.String())
+	})
+
+	t.Run("Below minimum size", func(t *testing.T) {
+		recorder := serve("/small", "gzip")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "small", recorder.Body.String())
+	})
+
+	t.Run("Not compressible content type", func(t *testing.T) {
+		recorder := serve("/image", "gzip")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Empty(t, recorder.Header().Get("Vary"))
+	})
+
+	t.Run("Already encoded", func(t *testing.T) {
+		recorder := serve("/encoded", "gzip")
+		assert.Equal(t, "deflate", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, large, recorder.Body.String())
+	})
+}
+
+func TestHandler_Encoders(t *testing.T) {
+	_, err := NewHandler(http.NotFoundHandler(), WithEncodings(Gzip, "deflate"))
+	assert.Equal(t, ErrUnsupportedEncoding, err)
+
+	h, err := NewHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain")
+		_, _ = writer.Write([]byte(strings.Repeat(request.URL.Path, 200)))
+	}))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The pooled encoders do not leak the content of the previous
+	// responses.
+	for _, encoding := range DefaultEncodings {
+		for _, path := range []string{"/first", "/second", "/third"} {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			h.ServeHTTP(recorder, req)
+			assert.Equal(t, strings.Repeat(path, 200), decode(t, encoding, recorder.Body.Bytes()), encoding)
+		}
+	}
+}
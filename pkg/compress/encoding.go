@@ -0,0 +1,121 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content codings.
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// DefaultEncodings is the default server preference order. It is
+// used to choose between the codings accepted with the same quality.
+var DefaultEncodings = []string{Brotli, Zstd, Gzip}
+
+// encoder is a compressing writer that can be reused.
+type encoder interface {
+	io.WriteCloser
+
+	// Reset discards the state of the writer, and makes it write to w.
+	Reset(w io.Writer)
+}
+
+// encoders pools the compressing writers by coding, so their buffers
+// are reused by the responses.
+var encoders = map[string]*sync.Pool{
+	Gzip: {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(ioutil.Discard, gzip.DefaultCompression)
+		return w
+	}},
+	Brotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(ioutil.Discard, brotli.DefaultCompression)
+	}},
+	Zstd: {New: func() interface{} {
+		w, _ := zstd.NewWriter(ioutil.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return w
+	}},
+}
+
+// getEncoder returns a compressing writer for the given coding,
+// writing to w. It has to be given back with putEncoder once closed.
+func getEncoder(encoding string, w io.Writer) (encoder, error) {
+	pool, ok := encoders[encoding]
+	if !ok {
+		return nil, ErrUnsupportedEncoding
+	}
+
+	e := pool.Get().(encoder)
+	e.Reset(w)
+	return e, nil
+}
+
+// putEncoder gives a closed compressing writer back to its pool.
+func putEncoder(encoding string, e encoder) {
+	// Releases the response writer.
+	e.Reset(ioutil.Discard)
+	encoders[encoding].Put(e)
+}
+
+// negotiate chooses the coding to use from the "Accept-Encoding"
+// request header. It returns an empty string if none of the supported
+// codings is accepted.
+//
+// More details can be found here: https://tools.ietf.org/html/rfc7231#section-5.3.4
+func negotiate(acceptEncoding string, supported []string) string {
+	if len(acceptEncoding) == 0 {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(coding) == 0 {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := qualities[coding]
+		if !ok {
+			q = wildcard
+		}
+
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
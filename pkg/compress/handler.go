@@ -0,0 +1,305 @@
+package compress
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnsupportedEncoding is returned when a content coding is not
+// supported by the handler.
+var ErrUnsupportedEncoding = errors.New("compress: unsupported encoding")
+
+// DefaultContentTypes is the default list of the compressible
+// content type prefixes.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+// DefaultMinSize is the default minimum response size to be
+// compressed.
+const DefaultMinSize = 1024
+
+// Handler is a http.Handler that is used as a middleware
+// to compress the origin responses.
+//
+// The content coding is negotiated from the "Accept-Encoding"
+// request header. When it is placed behind a cache.Handler, the
+// compressed variants are cached separately thanks to the "Vary"
+// response header.
+type Handler struct {
+
+	// Origin is the http handler whose responses are compressed.
+	Origin http.Handler
+
+	// encodings is the supported codings in the server preference
+	// order.
+	encodings []string
+
+	// contentTypes is the list of the compressible content type
+	// prefixes.
+	contentTypes []string
+
+	// minSize is the minimum response size to be compressed.
+	minSize int
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithEncodings sets the supported codings in the server preference
+// order.
+func WithEncodings(encodings ...string) Option {
+	return func(h *Handler) {
+		h.encodings = encodings
+	}
+}
+
+// WithContentTypes sets the compressible content type prefixes.
+func WithContentTypes(contentTypes ...string) Option {
+	return func(h *Handler) {
+		h.contentTypes = contentTypes
+	}
+}
+
+// WithMinSize sets the minimum response size to be compressed.
+func WithMinSize(size int) Option {
+	return func(h *Handler) {
+		h.minSize = size
+	}
+}
+
+// NewHandler creates a compression middleware in front of the given
+// http.Handler. It returns an error if one of the codings is not
+// supported.
+func NewHandler(o http.Handler, opts ...Option) (*Handler, error) {
+	h := &Handler{
+		Origin:       o,
+		encodings:    DefaultEncodings,
+		contentTypes: DefaultContentTypes,
+		minSize:      DefaultMinSize,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	for _, encoding := range h.encodings {
+		if _, ok := encoders[encoding]; !ok {
+			return nil, ErrUnsupportedEncoding
+		}
+	}
+
+	return h, nil
+}
+
+// ServeHTTP compresses the origin response when the client accepts
+// one of the supported codings.
+//
+// The response is compressed only if it has a compressible content
+// type, it is not already encoded and its size is above the minimum
+// size. The "Content-Length", "Vary" and "ETag" headers are updated
+// accordingly.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	encoding := ""
+	if request.Method != http.MethodHead {
+		encoding = negotiate(request.Header.Get("Accept-Encoding"), h.encodings)
+	}
+
+	cw := &compressWriter{
+		ResponseWriter: writer,
+		handler:        h,
+		encoding:       encoding,
+	}
+
+	h.Origin.ServeHTTP(cw, request)
+
+	if err := cw.close(); err != nil {
+		logrus.WithContext(request.Context()).WithError(err).Error("Error while compressing response")
+	}
+}
+
+// isCompressible checks if the content type can be compressed.
+func (h *Handler) isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range h.contentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressWriter buffers the beginning of the response until it is
+// able to decide whether it has to be compressed or not.
+type compressWriter struct {
+	http.ResponseWriter
+
+	handler  *Handler
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder encoder
+}
+
+// WriteHeader is the "http.ResponseWriter" interface implementation.
+// The status is sent once the compression has been decided.
+func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.status == 0 {
+		c.status = statusCode
+	}
+}
+
+// Write is the "http.ResponseWriter" interface implementation.
+func (c *compressWriter) Write(bytes []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	if !c.decided {
+		c.buf = append(c.buf, bytes...)
+		if len(c.buf) < c.handler.minSize {
+			return len(bytes), nil
+		}
+
+		if err := c.decide(); err != nil {
+			return 0, err
+		}
+
+		return len(bytes), nil
+	}
+
+	if c.encoder != nil {
+		return c.encoder.Write(bytes)
+	}
+
+	return c.ResponseWriter.Write(bytes)
+}
+
+// Flush is the "http.Flusher" interface implementation.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if err := c.decide(); err != nil {
+			logrus.WithError(err).Error("Error while compressing response")
+			return
+		}
+	}
+
+	if f, ok := c.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide chooses whether the response is compressed, sends the headers
+// and the buffered content.
+func (c *compressWriter) decide() error {
+	c.decided = true
+
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	headers := c.Header()
+
+	// The compression is disabled for the bodiless and partial responses.
+	bodiless := c.status < 200 || c.status == http.StatusNoContent ||
+		c.status == http.StatusNotModified || c.status == http.StatusPartialContent
+
+	if !bodiless && len(headers.Get("Content-Type")) == 0 && len(c.buf) > 0 {
+		// Prevents the server to sniff the compressed content.
+		headers.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+
+	compressible := !bodiless && len(headers.Get("Content-Encoding")) == 0 &&
+		c.handler.isCompressible(headers.Get("Content-Type"))
+
+	if compressible {
+		headers.Add("Vary", "Accept-Encoding")
+	}
+
+	size := len(c.buf)
+	if length, err := strconv.Atoi(headers.Get("Content-Length")); err == nil {
+		size = length
+	}
+
+	if compressible && len(c.encoding) > 0 && size >= c.handler.minSize {
+		encoder, err := getEncoder(c.encoding, c.ResponseWriter)
+		if err != nil {
+			return err
+		}
+
+		c.encoder = encoder
+		headers.Del("Content-Length")
+		headers.Del("Accept-Ranges")
+		headers.Set("Content-Encoding", c.encoding)
+		if etag := headers.Get("ETag"); len(etag) > 0 {
+			headers.Set("ETag", encodedETag(etag, c.encoding))
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	buf := c.buf
+	c.buf = nil
+
+	if c.encoder != nil {
+		_, err := c.encoder.Write(buf)
+		return err
+	}
+
+	_, err := c.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends the remaining buffered content and terminates the
+// compressed stream.
+func (c *compressWriter) close() error {
+	if c.status == 0 {
+		// The origin handler did not write anything.
+		return nil
+	}
+
+	if !c.decided {
+		if err := c.decide(); err != nil {
+			return err
+		}
+	}
+
+	if c.encoder != nil {
+		err := c.encoder.Close()
+		putEncoder(c.encoding, c.encoder)
+		c.encoder = nil
+		return err
+	}
+
+	return nil
+}
+
+// encodedETag derives the entity tag of the encoded representation
+// from the original one, so both representations are not mistaken.
+func encodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
@@ -0,0 +1,715 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultAddress is the address of the listener used when the
+// configuration does not declare any.
+const DefaultAddress = ":80"
+
+// UnixPrefix prefixes the addresses of the Unix socket listeners.
+const UnixPrefix = "unix:"
+
+// Config is the proxy server configuration.
+//
+// It is read from a YAML or JSON file, see Load.
+type Config struct {
+
+	// Log configures the logging.
+	Log LogConfig `yaml:"log"`
+
+	// Listeners are the addresses the server listens on.
+	Listeners []ListenerConfig `yaml:"listeners"`
+
+	// Admin enables the admin API on its own listener.
+	Admin *AdminConfig `yaml:"admin"`
+
+	// Metrics configures the metrics listener.
+	Metrics MetricsConfig `yaml:"metrics"`
+
+	// Tracing configures the distributed tracing.
+	Tracing TracingConfig `yaml:"tracing"`
+
+	// RequestID configures the IDs given to the requests.
+	RequestID RequestIDConfig `yaml:"request_id"`
+
+	// AccessLog enables the access log of the listeners.
+	AccessLog *AccessLogConfig `yaml:"access_log"`
+
+	// Upstreams are the named upstream groups the routes forward to.
+	Upstreams map[string]*UpstreamConfig `yaml:"upstreams"`
+
+	// LoadBalancing is the default load balancing of the upstream
+	// groups.
+	LoadBalancing LoadBalancingConfig `yaml:"load_balancing"`
+
+	// Discovery configures the dynamic upstream discovery.
+	Discovery DiscoveryConfig `yaml:"discovery"`
+
+	// Transport configures the connections to the upstreams.
+	Transport TransportConfig `yaml:"transport"`
+
+	// Middleware is the middleware applied to all the routes.
+	Middleware MiddlewareConfig `yaml:"middleware"`
+
+	// Routes dispatch the requests to the upstream groups.
+	Routes []*RouteConfig `yaml:"routes"`
+
+	// positions maps the configuration paths to their nodes in the
+	// file, to locate the validation errors.
+	positions map[string]*yaml.Node
+}
+
+// LogConfig configures the logging.
+type LogConfig struct {
+
+	// Level is the logrus log level ("info" when empty).
+	Level string `yaml:"level"`
+}
+
+// ListenerConfig is an address the server listens on.
+type ListenerConfig struct {
+
+	// Name identifies the listener in the logs.
+	Name string `yaml:"name"`
+
+	// Address is the listening address (e.g. ":443"), or the path of a
+	// Unix socket prefixed by "unix:" (e.g. "unix:/run/proxy.sock").
+	Address string `yaml:"address"`
+
+	// SocketMode is the octal file mode of the Unix socket (e.g.
+	// "0660"), the umask applying when empty.
+	SocketMode string `yaml:"socket_mode"`
+
+	// TLS enables HTTPS on the listener.
+	TLS *TLSConfig `yaml:"tls"`
+
+	// HSTS sets the Strict-Transport-Security header of the responses
+	// of a TLS listener.
+	HSTS *HSTSConfig `yaml:"hsts"`
+
+	// Redirect makes a plaintext listener redirect all the requests to
+	// HTTPS, instead of serving routes.
+	Redirect *RedirectConfig `yaml:"redirect"`
+}
+
+// HSTSConfig configures the HTTP Strict Transport Security header.
+type HSTSConfig struct {
+
+	// MaxAge is the time the browsers only use HTTPS for the host.
+	MaxAge time.Duration `yaml:"max_age"`
+
+	// IncludeSubdomains applies the policy to the subdomains.
+	IncludeSubdomains bool `yaml:"include_subdomains"`
+
+	// Preload allows the host in the browsers preload lists.
+	Preload bool `yaml:"preload"`
+}
+
+// RedirectConfig configures the redirect to HTTPS of a plaintext
+// listener.
+type RedirectConfig struct {
+
+	// Status is the redirect status code, 301, 302, 303, 307 or 308
+	// (308 when 0).
+	Status int `yaml:"status"`
+
+	// Port is the HTTPS port of the redirect location (443 when 0).
+	Port int `yaml:"port"`
+}
+
+// Listener returns the listener of the given name, or nil.
+func (c *Config) Listener(name string) *ListenerConfig {
+	for i := range c.Listeners {
+		if c.Listeners[i].Name == name {
+			return &c.Listeners[i]
+		}
+	}
+	return nil
+}
+
+// SocketPath returns the path of a Unix socket listener, or an empty
+// string for the TCP listeners.
+func (l *ListenerConfig) SocketPath() string {
+	if !strings.HasPrefix(l.Address, UnixPrefix) {
+		return ""
+	}
+
+	// Both "unix:/path" and "unix:///path" are accepted.
+	return strings.TrimPrefix(strings.TrimPrefix(l.Address, UnixPrefix), "//")
+}
+
+// FileMode returns the parsed socket mode.
+func (l *ListenerConfig) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q", l.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// TLSConfig configures the TLS of a listener. The certificate of a
+// connection is selected by its server name (SNI) among all the
+// configured certificates.
+type TLSConfig struct {
+
+	// Certificate is the PEM certificate file.
+	Certificate string `yaml:"certificate"`
+
+	// Key is the PEM private key file.
+	Key string `yaml:"key"`
+
+	// Certificates are more certificate and key pairs.
+	Certificates []CertificateConfig `yaml:"certificates"`
+
+	// Directory holds more certificates: the "name.crt" or "name.pem"
+	// files with their "name.key" file, or "name.pem" files holding
+	// both the certificate and the key.
+	Directory string `yaml:"directory"`
+
+	// Default is the server name whose certificate is served to the
+	// clients without or with an unknown server name, the first
+	// certificate when empty.
+	Default string `yaml:"default"`
+
+	// ReloadInterval is the interval between two checks of the
+	// certificate file changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	// ACME obtains the certificates of domains from an ACME CA.
+	ACME *ACMEConfig `yaml:"acme"`
+
+	// ClientAuth verifies the client certificates.
+	ClientAuth *ClientAuthConfig `yaml:"client_auth"`
+}
+
+// The client authentication modes.
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequired = "required"
+)
+
+// ClientAuthConfig configures the client certificates of a TLS
+// listener. The verified certificates are forwarded to the upstreams
+// in request headers, see clientcert.Headers.
+type ClientAuthConfig struct {
+
+	// CA is the PEM file of the authorities the client certificates
+	// are verified with.
+	CA string `yaml:"ca"`
+
+	// Mode is "optional" (the default): the clients may send a
+	// certificate, required by the routes with the client_cert
+	// middleware; or "required": the connections without a valid
+	// certificate are refused.
+	Mode string `yaml:"mode"`
+
+	// Headers renames the forwarded certificate headers.
+	Headers ClientCertHeadersConfig `yaml:"headers"`
+}
+
+// ClientCertHeadersConfig names the request headers of the client
+// certificate, the default ones when empty.
+type ClientCertHeadersConfig struct {
+	Subject     string `yaml:"subject"`
+	SANs        string `yaml:"sans"`
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+// ACMEConfig configures the automatic certificates of a TLS listener.
+type ACMEConfig struct {
+
+	// Domains are the names whose certificates are obtained.
+	Domains []string `yaml:"domains"`
+
+	// Email is the contact email of the ACME account.
+	Email string `yaml:"email"`
+
+	// DirectoryURL is the ACME directory of the CA (Let's Encrypt when
+	// empty).
+	DirectoryURL string `yaml:"directory_url"`
+
+	// CARoot is the PEM file of the CAs trusted for the connections to
+	// the directory (e.g. the Pebble one), the system ones when empty.
+	CARoot string `yaml:"ca_root"`
+
+	// Storage is the directory of the account key and the certificates.
+	// It can be shared by several instances.
+	Storage string `yaml:"storage"`
+
+	// RenewBefore is the time before the expiry of the certificates
+	// they are renewed (30 days when 0).
+	RenewBefore time.Duration `yaml:"renew_before"`
+
+	// Challenges are the challenge types answered, "http-01" and
+	// "tls-alpn-01" (both when empty).
+	Challenges []string `yaml:"challenges"`
+}
+
+// CertificateConfig is a certificate and key pair.
+type CertificateConfig struct {
+
+	// Certificate is the PEM certificate file.
+	Certificate string `yaml:"certificate"`
+
+	// Key is the PEM private key file.
+	Key string `yaml:"key"`
+}
+
+// AdminConfig configures the admin API listener. The requests are
+// authenticated with a bearer token, a client certificate, or both.
+type AdminConfig struct {
+
+	// Address is the listening address. It must not be one of the
+	// public listeners.
+	Address string `yaml:"address"`
+
+	// Token is the expected bearer token.
+	Token string `yaml:"token"`
+
+	// TLS enables HTTPS on the admin listener.
+	TLS *AdminTLSConfig `yaml:"tls"`
+}
+
+// MetricsConfig configures the metrics exposition. The metrics are
+// served by the admin API, and on their own listener when an address
+// is set.
+type MetricsConfig struct {
+
+	// Address is the listening address of the unauthenticated metrics
+	// listener. It must not be one of the public listeners.
+	Address string `yaml:"address"`
+}
+
+// TracingConfig configures the distributed tracing. It is disabled
+// without exporter.
+type TracingConfig struct {
+
+	// Exporter is where the spans are sent: "otlp" or "stdout".
+	Exporter string `yaml:"exporter"`
+
+	// Endpoint is the OTLP/HTTP traces endpoint of the collector
+	// (e.g. "http://localhost:4318/v1/traces").
+	Endpoint string `yaml:"endpoint"`
+
+	// Headers are added to the export requests.
+	Headers map[string]string `yaml:"headers"`
+
+	// ServiceName names the proxy in the traces ("proxy-server" when
+	// empty).
+	ServiceName string `yaml:"service_name"`
+
+	// SampleRate is the fraction of the new traces recorded, from 0 to
+	// 1 (1 when unset). The traces started by the clients follow their
+	// sampling decision.
+	SampleRate *float64 `yaml:"sample_rate"`
+
+	// Propagation are the header formats read and written: "w3c" and
+	// "b3" (both when empty).
+	Propagation []string `yaml:"propagation"`
+}
+
+// RequestIDConfig configures the request IDs. Each request gets a
+// UUIDv7, forwarded to the upstreams and returned to the client.
+type RequestIDConfig struct {
+
+	// Header is the request and response header holding the ID
+	// ("X-Request-ID" when empty).
+	Header string `yaml:"header"`
+
+	// TrustIncoming keeps the valid IDs sent by the clients, e.g. by a
+	// load balancer in front of the proxy.
+	TrustIncoming bool `yaml:"trust_incoming"`
+}
+
+// AccessLogConfig configures the access log, written for each request
+// received by the listeners.
+type AccessLogConfig struct {
+
+	// Format is the line format: "common", "combined", "json" or
+	// "logfmt" ("combined" when empty).
+	Format string `yaml:"format"`
+
+	// Fields are the fields of the JSON and logfmt lines (all of them
+	// when empty), or the fields appended to the common and combined
+	// lines.
+	Fields []string `yaml:"fields"`
+
+	// Output is where the lines are written: "stdout", "file" or
+	// "syslog" ("stdout" when empty).
+	Output string `yaml:"output"`
+
+	// File configures the "file" output.
+	File AccessLogFileConfig `yaml:"file"`
+
+	// Syslog configures the "syslog" output.
+	Syslog AccessLogSyslogConfig `yaml:"syslog"`
+}
+
+// AccessLogFileConfig configures the access log file.
+type AccessLogFileConfig struct {
+
+	// Path is the log file.
+	Path string `yaml:"path"`
+
+	// MaxSize is the size in megabytes above which the file is rotated
+	// (never rotated when 0).
+	MaxSize int `yaml:"max_size"`
+
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// AccessLogSyslogConfig configures the syslog daemon receiving the
+// access log.
+type AccessLogSyslogConfig struct {
+
+	// Network is "udp", "tcp" or "unix", the local daemon being used
+	// when empty.
+	Network string `yaml:"network"`
+
+	// Address is the daemon address (e.g. "localhost:514").
+	Address string `yaml:"address"`
+
+	// Tag prefixes the messages ("proxy-server" when empty).
+	Tag string `yaml:"tag"`
+}
+
+// AdminTLSConfig configures the TLS of the admin listener.
+type AdminTLSConfig struct {
+
+	// Certificate is the PEM certificate file.
+	Certificate string `yaml:"certificate"`
+
+	// Key is the PEM private key file.
+	Key string `yaml:"key"`
+
+	// ClientCA is the PEM file of the authorities the client
+	// certificates are verified with. When set, a valid client
+	// certificate is required.
+	ClientCA string `yaml:"client_ca"`
+}
+
+// UpstreamConfig is a named group of upstream servers.
+type UpstreamConfig struct {
+
+	// Targets are the static members of the group.
+	Targets []TargetConfig `yaml:"targets"`
+
+	// DNS are the URLs resolved into the group members, see
+	// discovery.DNS.
+	DNS []string `yaml:"dns"`
+
+	// LoadBalancing overrides the default load balancing.
+	LoadBalancing *LoadBalancingConfig `yaml:"load_balancing"`
+}
+
+// TargetConfig is an upstream server, written either as a URL or as
+// a mapping with a weight.
+type TargetConfig struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// UnmarshalYAML is the `yaml.Unmarshaler` interface implementation.
+func (t *TargetConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&t.URL)
+	}
+
+	type plain TargetConfig
+	return value.Decode((*plain)(t))
+}
+
+// LoadBalancingConfig configures how the requests are distributed
+// between the members of an upstream group.
+type LoadBalancingConfig struct {
+
+	// Algorithm is "round-robin" (default) or "consistent-hash".
+	Algorithm string `yaml:"algorithm"`
+
+	// HashKey is the consistent hashing key, see proxy.ParseHashKey.
+	HashKey string `yaml:"hash_key"`
+
+	// VirtualNodes is the number of points of each upstream on the
+	// consistent hashing ring.
+	VirtualNodes int `yaml:"virtual_nodes"`
+
+	// MaxFails is the number of consecutive requests a member could
+	// not answer opening its circuit breaker, 1 by default.
+	MaxFails int `yaml:"max_fails"`
+
+	// FailTimeout is how long the open breakers take their members out
+	// of the rotation, see proxy.Pool.WithFailTimeout. The breakers are
+	// disabled when zero.
+	FailTimeout time.Duration `yaml:"fail_timeout"`
+}
+
+// DiscoveryConfig configures the dynamic upstream discovery.
+type DiscoveryConfig struct {
+
+	// File is a discovery file whose groups replace the upstream
+	// targets, see discovery.File.
+	File string `yaml:"file"`
+
+	// Interval is the duration between two checks of the file.
+	Interval time.Duration `yaml:"interval"`
+
+	// DNS configures the resolution of the upstream DNS targets.
+	DNS DNSConfig `yaml:"dns"`
+}
+
+// DNSConfig configures the DNS resolution of the upstreams.
+type DNSConfig struct {
+
+	// Server is the DNS server address (system name server when
+	// empty).
+	Server string `yaml:"server"`
+
+	// Interval is the duration between two resolutions (records TTL
+	// when zero).
+	Interval time.Duration `yaml:"interval"`
+}
+
+// TransportConfig configures the connections to the upstreams.
+type TransportConfig struct {
+
+	// InsecureSkipVerify disables the upstream TLS certificate
+	// verification.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// RouteConfig dispatches the matching requests to an upstream group,
+// or splits them between several groups.
+type RouteConfig struct {
+
+	// Name identifies the route (the path when empty).
+	Name string `yaml:"name"`
+
+	// Host is the request host matched by the route. Any host matches
+	// when empty.
+	Host string `yaml:"host"`
+
+	// Path is the request path prefix matched by the route ("/" when
+	// empty). The longest matching prefix wins.
+	Path string `yaml:"path"`
+
+	// Listeners are the names of the listeners serving the route, all
+	// of them when empty.
+	Listeners []string `yaml:"listeners"`
+
+	// Upstream is the upstream group handling the requests.
+	Upstream string `yaml:"upstream"`
+
+	// Split distributes the requests between upstream groups by weight.
+	Split map[string]int `yaml:"split"`
+
+	// Overrides force a split group for the matching requests.
+	Overrides []OverrideConfig `yaml:"overrides"`
+
+	// Affinity enables the cookie-based sticky sessions.
+	Affinity *AffinityConfig `yaml:"affinity"`
+
+	// Mirror copies the requests to a shadow server.
+	Mirror *MirrorConfig `yaml:"mirror"`
+
+	// Middleware overrides the global middleware for the route.
+	Middleware MiddlewareConfig `yaml:"middleware"`
+}
+
+// servedBy reports whether the route is served by the named listener.
+func (r *RouteConfig) servedBy(listener string) bool {
+	if len(r.Listeners) == 0 {
+		return true
+	}
+	for _, name := range r.Listeners {
+		if name == listener {
+			return true
+		}
+	}
+	return false
+}
+
+// OverrideConfig forces a split group, see proxy.Override.
+type OverrideConfig struct {
+	Header string `yaml:"header"`
+	Cookie string `yaml:"cookie"`
+	Query  string `yaml:"query"`
+	Value  string `yaml:"value"`
+	Group  string `yaml:"group"`
+}
+
+// AffinityConfig configures the sticky sessions, see
+// proxy.AffinityConfig.
+type AffinityConfig struct {
+	Cookie   string        `yaml:"cookie"`
+	Secret   string        `yaml:"secret"`
+	TTL      time.Duration `yaml:"ttl"`
+	Path     string        `yaml:"path"`
+	Domain   string        `yaml:"domain"`
+	Secure   bool          `yaml:"secure"`
+	HTTPOnly *bool         `yaml:"http_only"`
+
+	// SameSite is "lax", "strict" or "none".
+	SameSite string `yaml:"same_site"`
+}
+
+// MirrorConfig configures the traffic mirroring, see
+// proxy.MirrorConfig.
+type MirrorConfig struct {
+	Target        string         `yaml:"target"`
+	Percentage    *float64       `yaml:"percentage"`
+	MaxConcurrent int            `yaml:"max_concurrent"`
+	MaxBodySize   int64          `yaml:"max_body_size"`
+	Timeout       time.Duration  `yaml:"timeout"`
+	Compare       *CompareConfig `yaml:"compare"`
+}
+
+// CompareConfig configures the shadow response comparison, see
+// proxy.CompareConfig.
+type CompareConfig struct {
+	Headers     []string `yaml:"headers"`
+	IgnorePaths []string `yaml:"ignore_paths"`
+	Routes      []string `yaml:"routes"`
+	MaxBodySize int64    `yaml:"max_body_size"`
+}
+
+// MiddlewareConfig enables the middleware. A middleware is enabled
+// when its section is present and not disabled.
+type MiddlewareConfig struct {
+	Compression *CompressionConfig `yaml:"compression"`
+	Cache       *CacheConfig       `yaml:"cache"`
+	BasicAuth   *BasicAuthConfig   `yaml:"basic_auth"`
+	ForwardAuth *ForwardAuthConfig `yaml:"forward_auth"`
+	JWT         *JWTConfig         `yaml:"jwt"`
+	CORS        *CORSConfig        `yaml:"cors"`
+	ClientCert  *ClientCertConfig  `yaml:"client_cert"`
+}
+
+// Merge returns the middleware with the sections of the override
+// replacing the ones of m.
+func (m MiddlewareConfig) Merge(override MiddlewareConfig) MiddlewareConfig {
+	if override.Compression != nil {
+		m.Compression = override.Compression
+	}
+	if override.Cache != nil {
+		m.Cache = override.Cache
+	}
+	if override.BasicAuth != nil {
+		m.BasicAuth = override.BasicAuth
+	}
+	if override.ForwardAuth != nil {
+		m.ForwardAuth = override.ForwardAuth
+	}
+	if override.JWT != nil {
+		m.JWT = override.JWT
+	}
+	if override.CORS != nil {
+		m.CORS = override.CORS
+	}
+	if override.ClientCert != nil {
+		m.ClientCert = override.ClientCert
+	}
+
+	return m
+}
+
+// CompressionConfig configures the response compression.
+type CompressionConfig struct {
+	Disabled     bool     `yaml:"disabled"`
+	Encodings    []string `yaml:"encodings"`
+	ContentTypes []string `yaml:"content_types"`
+	MinSize      *int     `yaml:"min_size"`
+}
+
+// CacheConfig configures the response cache.
+type CacheConfig struct {
+	Disabled bool `yaml:"disabled"`
+}
+
+// BasicAuthConfig configures the Basic authentication.
+type BasicAuthConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// Htpasswd is the credentials file.
+	Htpasswd string `yaml:"htpasswd"`
+
+	// Realm is the realm of the whole route. Ignored when Routes is
+	// set.
+	Realm string `yaml:"realm"`
+
+	// Routes restrict the authentication to path prefixes.
+	Routes []BasicAuthRouteConfig `yaml:"routes"`
+}
+
+// BasicAuthRouteConfig is a path prefix protected by the Basic
+// authentication.
+type BasicAuthRouteConfig struct {
+	Prefix string `yaml:"prefix"`
+	Realm  string `yaml:"realm"`
+}
+
+// ForwardAuthConfig configures the forward authentication.
+type ForwardAuthConfig struct {
+	Disabled        bool     `yaml:"disabled"`
+	Address         string   `yaml:"address"`
+	RequestHeaders  []string `yaml:"request_headers"`
+	ResponseHeaders []string `yaml:"response_headers"`
+}
+
+// JWTConfig configures the JWT authentication.
+type JWTConfig struct {
+	Disabled  bool          `yaml:"disabled"`
+	JWKS      string        `yaml:"jwks"`
+	Refresh   time.Duration `yaml:"refresh"`
+	Issuer    string        `yaml:"issuer"`
+	Audiences []string      `yaml:"audiences"`
+
+	// Require lists the claims required by path prefix.
+	Require []JWTRequirementConfig `yaml:"require"`
+
+	// ForwardClaims maps the claims to the request headers they are
+	// forwarded in.
+	ForwardClaims map[string]string `yaml:"forward_claims"`
+
+	StripToken bool `yaml:"strip_token"`
+}
+
+// JWTRequirementConfig lists the claims required under a path prefix,
+// as "name" or "name=value".
+type JWTRequirementConfig struct {
+	Prefix string   `yaml:"prefix"`
+	Claims []string `yaml:"claims"`
+}
+
+// ClientCertConfig requires a client certificate verified by the
+// listener. Without allowed names, any verified certificate is
+// accepted.
+type ClientCertConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// CommonNames are the allowed subject common names.
+	CommonNames []string `yaml:"common_names"`
+
+	// SANs are the allowed subject alternative names, with their type
+	// prefix (e.g. "DNS:billing.internal").
+	SANs []string `yaml:"sans"`
+}
+
+// CORSConfig configures the CORS policy, see cors.Policy.
+type CORSConfig struct {
+	Disabled         bool          `yaml:"disabled"`
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
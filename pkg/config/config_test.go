@@ -0,0 +1,382 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const example = `
+log:
+  level: debug
+listeners:
+  - name: public
+    address: ":${PROXY_TEST_PORT}"
+upstreams:
+  backend:
+    targets:
+      - http://10.0.0.1:8080
+      - url: http://10.0.0.2:8080
+        weight: 3
+    load_balancing:
+      algorithm: consistent-hash
+      hash_key: header:X-User
+  canary:
+    dns: ["http+srv://_http._tcp.canary.internal"]
+middleware:
+  cache: {}
+  cors:
+    allowed_origins: ["https://*.example.com"]
+    max_age: 10m
+routes:
+  - path: /api
+    split: {backend: 90, canary: 10}
+    overrides:
+      - header: X-Canary
+        value: "true"
+        group: canary
+    middleware:
+      cache:
+        disabled: true
+  - upstream: backend
+    affinity:
+      cookie: session
+      secret: s3cr3t
+`
+
+func TestParse(t *testing.T) {
+	os.Setenv("PROXY_TEST_PORT", "8443")
+	defer os.Unsetenv("PROXY_TEST_PORT")
+
+	c, err := Parse([]byte(example))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, c.Validate())
+
+	assert.Equal(t, "debug", c.Log.Level)
+	assert.Equal(t, []ListenerConfig{{Name: "public", Address: ":8443"}}, c.Listeners)
+
+	backend := c.Upstreams["backend"]
+	assert.Equal(t, []TargetConfig{{URL: "http://10.0.0.1:8080"}, {URL: "http://10.0.0.2:8080", Weight: 3}}, backend.Targets)
+	assert.Equal(t, "consistent-hash", backend.LoadBalancing.Algorithm)
+	assert.Equal(t, []string{"http+srv://_http._tcp.canary.internal"}, c.Upstreams["canary"].DNS)
+
+	assert.Equal(t, 10*time.Minute, c.Middleware.CORS.MaxAge)
+
+	if assert.Len(t, c.Routes, 2) {
+		api := c.Routes[0]
+		assert.Equal(t, "/api", api.Name)
+		assert.Equal(t, map[string]int{"backend": 90, "canary": 10}, api.Split)
+		assert.Equal(t, []OverrideConfig{{Header: "X-Canary", Value: "true", Group: "canary"}}, api.Overrides)
+
+		m := c.Middleware.Merge(api.Middleware)
+		assert.True(t, m.Cache.Disabled)
+		assert.NotNil(t, m.CORS)
+
+		root := c.Routes[1]
+		assert.Equal(t, "/", root.Path)
+		assert.Equal(t, "session", root.Affinity.Cookie)
+	}
+}
+
+func TestParse_JSON(t *testing.T) {
+	c, err := Parse([]byte(`{
+  "upstreams": {"backend": {"targets": ["http://10.0.0.1:8080"]}},
+  "routes": [{"upstream": "backend"}]
+}`))
+	if assert.NoError(t, err) {
+		assert.NoError(t, c.Validate())
+		assert.Equal(t, []ListenerConfig{{Name: DefaultAddress, Address: DefaultAddress}}, c.Listeners)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	os.Setenv("PROXY_TEST_PORT", "8443")
+	defer os.Unsetenv("PROXY_TEST_PORT")
+
+	c, err := ParseTOML([]byte(`
+[log]
+level = "debug"
+
+[[listeners]]
+name = "public"
+address = ":${PROXY_TEST_PORT}"
+
+[upstreams.backend]
+targets = ["http://10.0.0.1:8080", {url = "http://10.0.0.2:8080", weight = 3}]
+load_balancing = {algorithm = "consistent-hash", hash_key = "header:X-User"}
+
+[upstreams.canary]
+dns = ["http+srv://_http._tcp.canary.internal"]
+
+[middleware.cache]
+
+[middleware.cors]
+allowed_origins = ["https://*.example.com"]
+max_age = "10m"
+
+[[routes]]
+path = "/api"
+split = {backend = 90, canary = 10}
+overrides = [{header = "X-Canary", value = "true", group = "canary"}]
+middleware = {cache = {disabled = true}}
+
+[[routes]]
+upstream = "backend"
+affinity = {cookie = "session", secret = "s3cr3t"}
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, c.Validate())
+
+	assert.Equal(t, "debug", c.Log.Level)
+	assert.Equal(t, []ListenerConfig{{Name: "public", Address: ":8443"}}, c.Listeners)
+
+	backend := c.Upstreams["backend"]
+	assert.Equal(t, []TargetConfig{{URL: "http://10.0.0.1:8080"}, {URL: "http://10.0.0.2:8080", Weight: 3}}, backend.Targets)
+	assert.Equal(t, "consistent-hash", backend.LoadBalancing.Algorithm)
+	assert.Equal(t, []string{"http+srv://_http._tcp.canary.internal"}, c.Upstreams["canary"].DNS)
+
+	assert.NotNil(t, c.Middleware.Cache)
+	assert.Equal(t, 10*time.Minute, c.Middleware.CORS.MaxAge)
+
+	if assert.Len(t, c.Routes, 2) {
+		api := c.Routes[0]
+		assert.Equal(t, "/api", api.Name)
+		assert.Equal(t, map[string]int{"backend": 90, "canary": 10}, api.Split)
+		assert.Equal(t, []OverrideConfig{{Header: "X-Canary", Value: "true", Group: "canary"}}, api.Overrides)
+		assert.True(t, c.Middleware.Merge(api.Middleware).Cache.Disabled)
+		assert.Equal(t, "session", c.Routes[1].Affinity.Cookie)
+	}
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name:   "Syntax",
+			config: "[[routes]]\npath = \"/\"\nupstream = [x\n",
+			want:   []string{"line 3, column 13: no value can start with x"},
+		},
+		{
+			name:   "Unknown field",
+			config: "[[routes]]\npath = \"/\"\ntimeout = \"3s\"\n",
+			want:   []string{`line 3, column 1: routes[0]: unknown field "timeout"`},
+		},
+		{
+			name:   "Environment",
+			config: "[[listeners]]\naddress = \"${PROXY_TEST_UNSET}\"\n",
+			want:   []string{"line 2, column 1: environment variable PROXY_TEST_UNSET is not set"},
+		},
+		{
+			name:   "Validation",
+			config: "[upstreams.backend]\ntargets = [\"http://x\"]\n\n[[routes]]\nupstream = \"backnd\"\n",
+			want:   []string{`line 5, column 1: routes[0].upstream: unknown upstream "backnd"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseTOML([]byte(tt.config))
+			if err == nil {
+				err = c.Validate()
+			}
+
+			errs, ok := err.(Errors)
+			if !assert.True(t, ok, "Errors expected, got %v", err) {
+				return
+			}
+
+			var messages []string
+			for _, e := range errs {
+				messages = append(messages, e.Error())
+			}
+
+			assert.Equal(t, tt.want, messages)
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name:   "Syntax",
+			config: "routes:\n  - path: /\n    upstream: [x\n",
+			want:   []string{"line 2: did not find expected ',' or ']'"},
+		},
+		{
+			name:   "Unknown field",
+			config: "routes:\n  - path: /\n    timeout: 3s\n",
+			want:   []string{`line 3, column 5: routes[0]: unknown field "timeout"`},
+		},
+		{
+			name:   "Type",
+			config: "load_balancing:\n  virtual_nodes: many\n",
+			want:   []string{"line 2: cannot unmarshal !!str `many` into int"},
+		},
+		{
+			name:   "Environment",
+			config: "listeners:\n  - address: ${PROXY_TEST_UNSET}\n",
+			want:   []string{"line 2, column 14: environment variable PROXY_TEST_UNSET is not set"},
+		},
+		{
+			name: "Validation",
+			config: `upstreams:
+  backend:
+    targets: ["10.0.0.1"]
+routes:
+  - path: api
+    upstream: backnd
+  - split: {backend: -1}
+`,
+			want: []string{
+				`line 3, column 15: upstreams.backend.targets[0]: absolute URL expected, got "10.0.0.1"`,
+				`line 5, column 11: routes[0].path: path must start with "/"`,
+				`line 6, column 15: routes[0].upstream: unknown upstream "backnd"`,
+				`line 7, column 22: routes[1].split.backend: negative weight`,
+			},
+		},
+		{
+			name:   "Admin",
+			config: "listeners: [{address: \":8080\"}]\nadmin:\n  address: :8080\nupstreams:\n  x: {targets: [\"http://x\"]}\nroutes: [{upstream: x}]\n",
+			want: []string{
+				`line 3, column 12: admin.address: address is used by the listener ":8080"`,
+				"line 3, column 3: admin: a token or a client CA is required",
+			},
+		},
+		{
+			name:   "Unix socket",
+			config: "listeners:\n  - address: :80\n    socket_mode: \"0660\"\n  - address: unix:/run/proxy.sock\n    socket_mode: rw\nupstreams:\n  x: {targets: [\"unix:///run/app.sock\", \"unix://app.sock\"]}\nroutes: [{upstream: x}]\n",
+			want: []string{
+				"line 3, column 18: listeners[0].socket_mode: socket mode requires a Unix socket address",
+				`line 5, column 18: listeners[1].socket_mode: invalid socket mode "rw"`,
+				`line 7, column 41: upstreams.x.targets[1]: socket path expected, got "unix://app.sock"`,
+			},
+		},
+		{
+			name:   "Listener routing",
+			config: "listeners:\n  - name: web\n    address: :80\n    redirect: {status: 200}\n  - name: secure\n    address: :8080\n    hsts: {max_age: 1h}\nupstreams:\n  x: {targets: [\"http://x\"]}\nroutes:\n  - upstream: x\n    listeners: [secure, web, admin]\n",
+			want: []string{
+				"line 4, column 24: listeners[0].redirect.status: invalid redirect status 200",
+				"line 7, column 11: listeners[1].hsts: HSTS requires a TLS listener",
+				`line 12, column 25: routes[0].listeners[1]: listener "web" only redirects to HTTPS`,
+				`line 12, column 30: routes[0].listeners[2]: unknown listener "admin"`,
+			},
+		},
+		{
+			name:   "TLS certificates",
+			config: "listeners:\n  - address: :443\n    tls: {certificate: a.pem}\n  - address: :8443\n    tls:\n      directory: certs\n      certificates:\n        - {certificate: b.pem}\nupstreams:\n  x: {targets: [\"http://x\"]}\nroutes: [{upstream: x}]\n",
+			want: []string{
+				"line 3, column 10: listeners[0].tls: certificate and key are required",
+				"line 8, column 11: listeners[1].tls.certificates[0]: certificate and key are required",
+			},
+		},
+		{
+			name:   "ACME",
+			config: "listeners:\n  - address: :443\n    tls:\n      acme:\n        domains: [example.com, \"*.example.com\"]\n        renew_before: -1h\n        challenges: [dns-01]\nupstreams:\n  x: {targets: [\"http://x\"]}\nroutes: [{upstream: x}]\n",
+			want: []string{
+				"line 5, column 32: listeners[0].tls.acme.domains[1]: invalid domain \"*.example.com\"",
+				"line 5, column 9: listeners[0].tls.acme.storage: storage is required",
+				"line 6, column 23: listeners[0].tls.acme.renew_before: renew before must be positive",
+				"line 7, column 22: listeners[0].tls.acme.challenges[0]: unknown challenge \"dns-01\"",
+			},
+		},
+		{
+			name:   "Client authentication",
+			config: "listeners:\n  - name: web\n    address: :80\n  - name: secure\n    address: :443\n    tls:\n      certificate: a.pem\n      key: a.key\n      client_auth: {mode: always}\nupstreams:\n  x: {targets: [\"http://x\"]}\nroutes:\n  - upstream: x\n    middleware:\n      client_cert: {sans: [billing.internal]}\n",
+			want: []string{
+				"line 9, column 20: listeners[1].tls.client_auth.ca: ca is required",
+				`line 9, column 27: listeners[1].tls.client_auth.mode: unknown mode "always"`,
+				`line 15, column 28: routes[0].middleware.client_cert.sans[0]: invalid SAN "billing.internal", DNS:, email:, IP: or URI: prefix expected`,
+				`line 13, column 5: routes[0]: client_cert requires a client authentication on the listener "web"`,
+			},
+		},
+		{
+			name:   "Sticky sessions",
+			config: "upstreams:\n  x:\n    targets: [\"http://x\"]\n    load_balancing: {max_fails: -1, fail_timeout: -1s}\nroutes:\n  - upstream: x\n    affinity: {cookie: session}\n",
+			want: []string{
+				"line 4, column 33: upstreams.x.load_balancing.max_fails: max_fails must not be negative",
+				"line 4, column 51: upstreams.x.load_balancing.fail_timeout: fail_timeout must not be negative",
+				"line 7, column 15: routes[0].affinity.secret: secret is required",
+			},
+		},
+		{
+			name:   "CORS credentials",
+			config: "middleware:\n  cors: {allowed_origins: [\"*\"], allow_credentials: true}\nroutes:\n  - upstream: x\nupstreams:\n  x: {targets: [\"http://x\"]}\n",
+			want:   []string{`line 2, column 9: middleware.cors: cors: the "*" origin cannot allow credentials`},
+		},
+		{
+			name:   "Missing value",
+			config: "middleware:\n  jwt:\n    issuer: me\nroutes:\n  - upstream: x\nupstreams:\n  x: {targets: [\"http://x\"]}\n",
+			want:   []string{"line 3, column 5: middleware.jwt.jwks: jwks is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse([]byte(tt.config))
+			if err == nil {
+				err = c.Validate()
+			}
+
+			errs, ok := err.(Errors)
+			if !assert.True(t, ok, "Errors expected, got %v", err) {
+				return
+			}
+
+			var messages []string
+			for _, e := range errs {
+				messages = append(messages, e.Error())
+			}
+
+			assert.Equal(t, tt.want, messages)
+		})
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"HOST": "example.com", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "https://${HOST}/", want: "https://example.com/"},
+		{value: "${MISSING:-default}", want: "default"},
+		{value: "${EMPTY:-default}", want: "default"},
+		{value: "${EMPTY}", want: ""},
+		{value: "$$HOME and $1", want: "$HOME and $1"},
+		{value: "${MISSING}", wantErr: true},
+		{value: "${HOST", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := expandEnv(tt.value, lookup)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a configuration error, located in the file.
+type Error struct {
+
+	// Line and Column locate the error. They are zero when unknown.
+	Line   int
+	Column int
+
+	// Path is the location of the invalid value in the configuration
+	// (e.g. "routes[0].upstream").
+	Path string
+
+	Message string
+}
+
+// Error is the `error` interface implementation.
+func (e *Error) Error() string {
+	var b strings.Builder
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		fmt.Fprintf(&b, "line %d, column %d: ", e.Line, e.Column)
+	case e.Line > 0:
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if len(e.Path) > 0 {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// Errors is the list of the errors of a configuration.
+type Errors []*Error
+
+// Error is the `error` interface implementation.
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// yamlLine extracts the line number of the YAML library messages.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Load reads the configuration file located at the given path. The
+// ".toml" files are read by ParseTOML, the other ones by Parse.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return ParseTOML(data)
+	}
+	return Parse(data)
+}
+
+// Parse reads a YAML or JSON configuration.
+//
+// The "${NAME}" and "${NAME:-default}" references in the values are
+// replaced by the environment variables, "$$" being a literal "$".
+// The unknown fields are rejected. The returned error is an Errors
+// value locating the problems in the file.
+//
+// The values are not checked, see Config.Validate.
+func Parse(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, Errors{parseYAMLError(err.Error())}
+	}
+
+	return parseNode(&root)
+}
+
+// parseNode decodes the configuration of a YAML document node.
+func parseNode(root *yaml.Node) (*Config, error) {
+	c := &Config{}
+	if len(root.Content) == 0 {
+		c.setDefaults()
+		return c, nil
+	}
+
+	document := root.Content[0]
+	l := &loader{positions: make(map[string]*yaml.Node)}
+	l.expand(document)
+	l.check(document, reflect.TypeOf(c).Elem(), "")
+	if len(l.errors) > 0 {
+		return nil, l.errors
+	}
+
+	if err := document.Decode(c); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			var errs Errors
+			for _, message := range typeErr.Errors {
+				errs = append(errs, parseYAMLError(message))
+			}
+			return nil, errs
+		}
+		return nil, Errors{parseYAMLError(err.Error())}
+	}
+
+	c.setDefaults()
+	c.positions = l.positions
+	return c, nil
+}
+
+// parseYAMLError converts a YAML library message to an Error.
+func parseYAMLError(message string) *Error {
+	if m := yamlLine.FindStringSubmatch(message); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &Error{Line: line, Message: m[2]}
+	}
+
+	return &Error{Message: strings.TrimPrefix(message, "yaml: ")}
+}
+
+// loader expands the environment variables and checks the fields of
+// the configuration nodes.
+type loader struct {
+
+	// positions maps the configuration paths to their nodes.
+	positions map[string]*yaml.Node
+
+	errors Errors
+}
+
+// expand replaces the environment variable references in the scalar
+// nodes.
+func (l *loader) expand(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		for _, child := range node.Content {
+			l.expand(child)
+		}
+		return
+	}
+
+	if !strings.Contains(node.Value, "$") {
+		return
+	}
+
+	value, err := expandEnv(node.Value, os.LookupEnv)
+	if err != nil {
+		l.errors = append(l.errors, &Error{Line: node.Line, Column: node.Column, Message: err.Error()})
+		return
+	}
+
+	if value != node.Value {
+		node.Value = value
+
+		// The type of the plain scalars is resolved again from their
+		// new value (e.g. "${PORT}" into an integer).
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+}
+
+// unmarshalerType is the type of the yaml.Unmarshaler interface.
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// check rejects the unknown fields of the node decoded into the given
+// type, and records the node positions.
+func (l *loader) check(node *yaml.Node, t reflect.Type, path string) {
+	l.positions[path] = node
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if node.Kind == yaml.ScalarNode && reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				l.errors = append(l.errors, &Error{
+					Line:    key.Line,
+					Column:  key.Column,
+					Path:    path,
+					Message: fmt.Sprintf("unknown field %q", key.Value),
+				})
+				continue
+			}
+
+			l.check(value, field.Type, join(path, key.Value))
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			l.check(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value))
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+
+		for i, child := range node.Content {
+			l.check(child, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+		}
+	}
+}
+
+// yamlFields returns the struct fields by YAML name.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if len(name) > 0 && name != "-" {
+			fields[name] = field
+		}
+	}
+
+	return fields
+}
+
+// join appends a field name to a configuration path.
+func join(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// expandEnv replaces the "${NAME}" and "${NAME:-default}" references
+// by the values returned by lookup. "$$" is a literal "$".
+func expandEnv(value string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(value[i:], "$$"):
+			b.WriteByte('$')
+			i++
+			continue
+		case !strings.HasPrefix(value[i:], "${"):
+			b.WriteByte('$')
+			continue
+		}
+
+		end := strings.IndexByte(value[i:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated variable reference in %q", value)
+		}
+
+		reference := value[i+2 : i+end]
+		name, fallback, hasFallback := reference, "", false
+		if pos := strings.Index(reference, ":-"); pos != -1 {
+			name, fallback, hasFallback = reference[:pos], reference[pos+2:], true
+		}
+
+		if len(name) == 0 {
+			return "", fmt.Errorf("empty variable reference in %q", value)
+		}
+
+		v, ok := lookup(name)
+		switch {
+		case ok && (len(v) > 0 || !hasFallback):
+			b.WriteString(v)
+		case hasFallback:
+			b.WriteString(fallback)
+		default:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+
+		i += end
+	}
+
+	return b.String(), nil
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.String()
+	}
+	sort.Strings(names)
+	return names
+}
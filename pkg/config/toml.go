@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// tomlPosition extracts the position of the TOML library messages.
+var tomlPosition = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+
+// ParseTOML reads a TOML configuration, with the same fields, checks
+// and environment variable references as Parse.
+func ParseTOML(data []byte) (*Config, error) {
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		if m := tomlPosition.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			column, _ := strconv.Atoi(m[2])
+			return nil, Errors{{Line: line, Column: column, Message: m[3]}}
+		}
+		return nil, Errors{{Message: err.Error()}}
+	}
+
+	document := tomlTable(tree)
+	if len(document.Content) == 0 {
+		return parseNode(&yaml.Node{Kind: yaml.DocumentNode})
+	}
+	return parseNode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{document}})
+}
+
+// tomlTable converts a TOML table into a YAML mapping node, its keys
+// being in the order of the file.
+func tomlTable(tree *toml.Tree) *yaml.Node {
+	position := tree.Position()
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: position.Line, Column: position.Col}
+
+	keys := tree.Keys()
+	positions := make(map[string]toml.Position, len(keys))
+	for _, key := range keys {
+		positions[key] = tree.GetPositionPath([]string{key})
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := positions[keys[i]], positions[keys[j]]
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+
+	for _, key := range keys {
+		p := positions[key]
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: p.Line, Column: p.Col},
+			tomlValue(tree.GetPath([]string{key}), p),
+		)
+	}
+	return node
+}
+
+// tomlValue converts a TOML value into a YAML node, located at the
+// given position when the value has none.
+func tomlValue(value interface{}, position toml.Position) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Line: position.Line, Column: position.Col}
+
+	switch v := value.(type) {
+	case *toml.Tree:
+		return tomlTable(v)
+	case []*toml.Tree:
+		node.Kind, node.Tag = yaml.SequenceNode, "!!seq"
+		for _, item := range v {
+			node.Content = append(node.Content, tomlTable(item))
+		}
+	case []interface{}:
+		node.Kind, node.Tag = yaml.SequenceNode, "!!seq"
+		for _, item := range v {
+			node.Content = append(node.Content, tomlValue(item, position))
+		}
+	case string:
+		// The strings are plain scalars, as in YAML, so the expanded
+		// environment variables are typed from their value.
+		node.Tag, node.Value = "!!str", v
+	case bool:
+		node.Tag, node.Value = "!!bool", strconv.FormatBool(v)
+	case int64:
+		node.Tag, node.Value = "!!int", strconv.FormatInt(v, 10)
+	case float64:
+		node.Tag, node.Value = "!!float", strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		node.Tag, node.Value = "!!str", v.Format(time.RFC3339Nano)
+	default:
+		node.Tag, node.Value = "!!str", fmt.Sprint(v)
+	}
+	return node
+}
@@ -0,0 +1,560 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/acme"
+	"github.com/moutoum/http-reverse-proxy/pkg/compress"
+	"github.com/moutoum/http-reverse-proxy/pkg/cors"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpguts"
+)
+
+// setDefaults fills the missing values.
+func (c *Config) setDefaults() {
+	if len(c.Listeners) == 0 {
+		c.Listeners = []ListenerConfig{{Address: DefaultAddress}}
+	}
+
+	for i := range c.Listeners {
+		if len(c.Listeners[i].Name) == 0 {
+			c.Listeners[i].Name = c.Listeners[i].Address
+		}
+	}
+
+	for _, route := range c.Routes {
+		if len(route.Path) == 0 {
+			route.Path = "/"
+		}
+		if len(route.Name) == 0 {
+			route.Name = route.Host + route.Path
+		}
+	}
+}
+
+// Validate checks the configuration. The returned error is an Errors
+// value.
+func (c *Config) Validate() error {
+	v := &validator{config: c}
+	v.validate()
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	return nil
+}
+
+// validator accumulates the configuration errors.
+type validator struct {
+	config *Config
+	errors Errors
+}
+
+// errorf records an error on the value located at the given path.
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	err := &Error{Path: path, Message: fmt.Sprintf(format, args...)}
+
+	// The error is located on the value, or on its closest parent
+	// when the value is missing from the file.
+	for p := path; v.config.positions != nil; p = parent(p) {
+		if node, ok := v.config.positions[p]; ok {
+			err.Line, err.Column = node.Line, node.Column
+			break
+		}
+		if len(p) == 0 {
+			break
+		}
+	}
+
+	v.errors = append(v.errors, err)
+}
+
+// parent returns the path of the parent of a configuration value.
+func parent(path string) string {
+	pos := strings.LastIndexAny(path, ".[")
+	if pos == -1 {
+		return ""
+	}
+	return path[:pos]
+}
+
+func (v *validator) validate() {
+	c := v.config
+
+	if len(c.Log.Level) > 0 {
+		if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+			v.errorf("log.level", "%v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, l := range c.Listeners {
+		path := "listeners[" + strconv.Itoa(i) + "]"
+		if names[l.Name] {
+			v.errorf(path+".name", "duplicated listener %q", l.Name)
+		}
+		names[l.Name] = true
+
+		if len(l.Address) == 0 {
+			v.errorf(path+".address", "address is required")
+		}
+
+		if l.TLS != nil {
+			v.validateTLS(path+".tls", l.TLS)
+		}
+
+		if l.HSTS != nil {
+			if l.TLS == nil {
+				v.errorf(path+".hsts", "HSTS requires a TLS listener")
+			}
+			if l.HSTS.MaxAge < 0 {
+				v.errorf(path+".hsts.max_age", "max age must be positive")
+			}
+		}
+
+		if r := l.Redirect; r != nil {
+			if l.TLS != nil {
+				v.errorf(path+".redirect", "redirect requires a plaintext listener")
+			}
+			switch r.Status {
+			case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+				http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				v.errorf(path+".redirect.status", "invalid redirect status %d", r.Status)
+			}
+			if r.Port < 0 || r.Port > 65535 {
+				v.errorf(path+".redirect.port", "invalid port %d", r.Port)
+			}
+		}
+
+		if len(l.SocketMode) > 0 {
+			if len(l.SocketPath()) == 0 {
+				v.errorf(path+".socket_mode", "socket mode requires a Unix socket address")
+			} else if _, err := l.FileMode(); err != nil {
+				v.errorf(path+".socket_mode", "invalid socket mode %q", l.SocketMode)
+			}
+		}
+	}
+
+	if a := c.Admin; a != nil {
+		v.validateAdmin(a)
+	}
+
+	for _, l := range c.Listeners {
+		if len(c.Metrics.Address) > 0 && c.Metrics.Address == l.Address {
+			v.errorf("metrics.address", "address is used by the listener %q", l.Name)
+		}
+	}
+
+	v.validateTracing(&c.Tracing)
+
+	if h := c.RequestID.Header; len(h) > 0 && !httpguts.ValidHeaderFieldName(h) {
+		v.errorf("request_id.header", "invalid header name %q", h)
+	}
+
+	if a := c.AccessLog; a != nil {
+		v.validateAccessLog(a)
+	}
+
+	for _, name := range sortedKeys(c.Upstreams) {
+		v.validateUpstream("upstreams."+name, c.Upstreams[name])
+	}
+
+	v.validateLoadBalancing("load_balancing", &c.LoadBalancing)
+	v.validateMiddleware("middleware", &c.Middleware)
+
+	if len(c.Routes) == 0 {
+		v.errorf("routes", "at least one route is required")
+	}
+
+	routes := make(map[string]bool)
+	for i, route := range c.Routes {
+		path := "routes[" + strconv.Itoa(i) + "]"
+		if routes[route.Name] {
+			v.errorf(path+".name", "duplicated route %q", route.Name)
+		}
+		routes[route.Name] = true
+
+		v.validateRoute(path, route)
+	}
+}
+
+func (v *validator) validateAdmin(a *AdminConfig) {
+	if len(a.Address) == 0 {
+		v.errorf("admin.address", "address is required")
+	}
+
+	for _, l := range v.config.Listeners {
+		if len(a.Address) > 0 && a.Address == l.Address {
+			v.errorf("admin.address", "address is used by the listener %q", l.Name)
+		}
+	}
+
+	if a.TLS != nil && (len(a.TLS.Certificate) == 0 || len(a.TLS.Key) == 0) {
+		v.errorf("admin.tls", "certificate and key are required")
+	}
+
+	if len(a.Token) == 0 && (a.TLS == nil || len(a.TLS.ClientCA) == 0) {
+		v.errorf("admin", "a token or a client CA is required")
+	}
+}
+
+func (v *validator) validateTracing(t *TracingConfig) {
+	switch t.Exporter {
+	case "", "stdout":
+	case "otlp":
+		if len(t.Endpoint) == 0 {
+			v.errorf("tracing.endpoint", "endpoint is required")
+		} else {
+			v.validateURL("tracing.endpoint", t.Endpoint)
+		}
+	default:
+		v.errorf("tracing.exporter", "unknown exporter %q", t.Exporter)
+	}
+
+	if r := t.SampleRate; r != nil && (*r < 0 || *r > 1) {
+		v.errorf("tracing.sample_rate", "sample rate must be between 0 and 1")
+	}
+
+	for i, name := range t.Propagation {
+		if _, err := tracing.ParsePropagator(name); err != nil {
+			v.errorf("tracing.propagation["+strconv.Itoa(i)+"]", "unknown propagation format %q", name)
+		}
+	}
+}
+
+func (v *validator) validateAccessLog(a *AccessLogConfig) {
+	if _, err := accesslog.ParseFormat(a.Format, nil); err != nil {
+		v.errorf("access_log.format", "unknown format %q", a.Format)
+	}
+
+	for i, name := range a.Fields {
+		if _, err := accesslog.ParseFormat(a.Format, []string{name}); err != nil {
+			v.errorf("access_log.fields["+strconv.Itoa(i)+"]", "unknown field %q", name)
+		}
+	}
+
+	switch a.Output {
+	case "", "stdout":
+	case "file":
+		if len(a.File.Path) == 0 {
+			v.errorf("access_log.file.path", "path is required")
+		}
+		if a.File.MaxSize < 0 {
+			v.errorf("access_log.file.max_size", "max size must be positive")
+		}
+		if a.File.MaxBackups < 0 {
+			v.errorf("access_log.file.max_backups", "max backups must be positive")
+		}
+	case "syslog":
+		switch a.Syslog.Network {
+		case "":
+		case "udp", "tcp", "unix", "unixgram":
+			if len(a.Syslog.Address) == 0 {
+				v.errorf("access_log.syslog.address", "address is required")
+			}
+		default:
+			v.errorf("access_log.syslog.network", "unknown network %q", a.Syslog.Network)
+		}
+	default:
+		v.errorf("access_log.output", "unknown output %q", a.Output)
+	}
+}
+
+func (v *validator) validateUpstream(path string, u *UpstreamConfig) {
+	if u == nil {
+		return
+	}
+
+	if len(u.Targets) > 0 && len(u.DNS) > 0 {
+		v.errorf(path, "targets and dns are exclusive")
+	}
+
+	for i, t := range u.Targets {
+		target := path + ".targets[" + strconv.Itoa(i) + "]"
+		v.validateUpstreamURL(target, t.URL)
+		if t.Weight < 0 {
+			v.errorf(target+".weight", "negative weight")
+		}
+	}
+
+	for i, value := range u.DNS {
+		v.validateURL(path+".dns["+strconv.Itoa(i)+"]", value)
+	}
+
+	if u.LoadBalancing != nil {
+		v.validateLoadBalancing(path+".load_balancing", u.LoadBalancing)
+	}
+}
+
+func (v *validator) validateLoadBalancing(path string, lb *LoadBalancingConfig) {
+	switch lb.Algorithm {
+	case "", "round-robin":
+	case "consistent-hash":
+		if len(lb.HashKey) > 0 {
+			if _, err := proxy.ParseHashKey(lb.HashKey); err != nil {
+				v.errorf(path+".hash_key", "%v", err)
+			}
+		}
+	default:
+		v.errorf(path+".algorithm", "unknown algorithm %q", lb.Algorithm)
+	}
+
+	if lb.MaxFails < 0 {
+		v.errorf(path+".max_fails", "max_fails must not be negative")
+	}
+	if lb.FailTimeout < 0 {
+		v.errorf(path+".fail_timeout", "fail_timeout must not be negative")
+	}
+}
+
+func (v *validator) validateTLS(path string, t *TLSConfig) {
+	missing := len(t.Certificate) == 0 && len(t.Certificates) == 0 && len(t.Directory) == 0 && t.ACME == nil
+	if missing || (len(t.Certificate) == 0) != (len(t.Key) == 0) {
+		v.errorf(path, "certificate and key are required")
+	}
+
+	for i, c := range t.Certificates {
+		if len(c.Certificate) == 0 || len(c.Key) == 0 {
+			v.errorf(path+".certificates["+strconv.Itoa(i)+"]", "certificate and key are required")
+		}
+	}
+
+	if t.ReloadInterval < 0 {
+		v.errorf(path+".reload_interval", "reload interval must be positive")
+	}
+
+	if a := t.ACME; a != nil {
+		v.validateACME(path+".acme", a)
+	}
+
+	if a := t.ClientAuth; a != nil {
+		if len(a.CA) == 0 {
+			v.errorf(path+".client_auth.ca", "ca is required")
+		}
+		switch a.Mode {
+		case "", ClientAuthOptional, ClientAuthRequired:
+		default:
+			v.errorf(path+".client_auth.mode", "unknown mode %q", a.Mode)
+		}
+	}
+}
+
+func (v *validator) validateACME(path string, a *ACMEConfig) {
+	if len(a.Domains) == 0 {
+		v.errorf(path+".domains", "domains are required")
+	}
+	for i, domain := range a.Domains {
+		if len(domain) == 0 || strings.ContainsAny(domain, "*/: ") {
+			v.errorf(path+".domains["+strconv.Itoa(i)+"]", "invalid domain %q", domain)
+		}
+	}
+
+	if len(a.Storage) == 0 {
+		v.errorf(path+".storage", "storage is required")
+	}
+	if len(a.DirectoryURL) > 0 {
+		v.validateURL(path+".directory_url", a.DirectoryURL)
+	}
+	if a.RenewBefore < 0 {
+		v.errorf(path+".renew_before", "renew before must be positive")
+	}
+
+	for i, c := range a.Challenges {
+		if c != acme.ChallengeHTTP01 && c != acme.ChallengeTLSALPN01 {
+			v.errorf(path+".challenges["+strconv.Itoa(i)+"]", "unknown challenge %q", c)
+		}
+	}
+}
+
+func (v *validator) validateRoute(path string, r *RouteConfig) {
+	if !strings.HasPrefix(r.Path, "/") {
+		v.errorf(path+".path", "path must start with \"/\"")
+	}
+
+	for i, name := range r.Listeners {
+		l := v.config.Listener(name)
+		switch {
+		case l == nil:
+			v.errorf(path+".listeners["+strconv.Itoa(i)+"]", "unknown listener %q", name)
+		case l.Redirect != nil:
+			v.errorf(path+".listeners["+strconv.Itoa(i)+"]", "listener %q only redirects to HTTPS", name)
+		}
+	}
+
+	switch {
+	case len(r.Upstream) > 0 && len(r.Split) > 0:
+		v.errorf(path, "upstream and split are exclusive")
+	case len(r.Upstream) == 0 && len(r.Split) == 0:
+		v.errorf(path, "upstream or split is required")
+	case len(r.Upstream) > 0:
+		v.validateGroup(path+".upstream", r.Upstream)
+	}
+
+	for _, name := range sortedKeys(r.Split) {
+		v.validateGroup(path+".split."+name, name)
+		if r.Split[name] < 0 {
+			v.errorf(path+".split."+name, "negative weight")
+		}
+	}
+
+	for i, o := range r.Overrides {
+		override := path + ".overrides[" + strconv.Itoa(i) + "]"
+		set := 0
+		for _, value := range []string{o.Header, o.Cookie, o.Query} {
+			if len(value) > 0 {
+				set++
+			}
+		}
+		if set != 1 {
+			v.errorf(override, "exactly one of header, cookie or query is required")
+		}
+		if len(o.Group) > 0 {
+			v.validateGroup(override+".group", o.Group)
+		}
+	}
+
+	if len(r.Overrides) > 0 && len(r.Split) == 0 {
+		v.errorf(path+".overrides", "overrides require a split")
+	}
+
+	if r.Affinity != nil {
+		// A random secret would end the sessions at each reload.
+		if len(r.Affinity.Secret) == 0 {
+			v.errorf(path+".affinity.secret", "secret is required")
+		}
+		switch strings.ToLower(r.Affinity.SameSite) {
+		case "", "lax", "strict", "none":
+		default:
+			v.errorf(path+".affinity.same_site", "unknown same_site value %q", r.Affinity.SameSite)
+		}
+	}
+
+	if r.Mirror != nil {
+		v.validateUpstreamURL(path+".mirror.target", r.Mirror.Target)
+		if p := r.Mirror.Percentage; p != nil && (*p < 0 || *p > 100) {
+			v.errorf(path+".mirror.percentage", "percentage must be between 0 and 100")
+		}
+	}
+
+	v.validateMiddleware(path+".middleware", &r.Middleware)
+
+	// The client certificates are only verified by the listeners with
+	// a client authentication.
+	if c := v.config.Middleware.Merge(r.Middleware).ClientCert; c != nil && !c.Disabled {
+		for _, l := range v.config.Listeners {
+			if l.Redirect != nil || !r.servedBy(l.Name) {
+				continue
+			}
+			if l.TLS == nil || l.TLS.ClientAuth == nil {
+				v.errorf(path, "client_cert requires a client authentication on the listener %q", l.Name)
+			}
+		}
+	}
+}
+
+// validateGroup checks that a route references a known upstream
+// group. The groups can also come from the discovery file.
+func (v *validator) validateGroup(path, name string) {
+	if _, ok := v.config.Upstreams[name]; ok || len(v.config.Discovery.File) > 0 {
+		return
+	}
+
+	v.errorf(path, "unknown upstream %q", name)
+}
+
+func (v *validator) validateMiddleware(path string, m *MiddlewareConfig) {
+	if c := m.Compression; c != nil && !c.Disabled && len(c.Encodings) > 0 {
+		if _, err := compress.NewHandler(nil, compress.WithEncodings(c.Encodings...)); err != nil {
+			v.errorf(path+".compression.encodings", "%v", err)
+		}
+	}
+
+	if b := m.BasicAuth; b != nil && !b.Disabled && len(b.Htpasswd) == 0 {
+		v.errorf(path+".basic_auth.htpasswd", "htpasswd is required")
+	}
+
+	if f := m.ForwardAuth; f != nil && !f.Disabled {
+		v.validateURL(path+".forward_auth.address", f.Address)
+	}
+
+	if j := m.JWT; j != nil && !j.Disabled {
+		if len(j.JWKS) == 0 {
+			v.errorf(path+".jwt.jwks", "jwks is required")
+		}
+		for i, r := range j.Require {
+			if len(r.Claims) == 0 {
+				v.errorf(path+".jwt.require["+strconv.Itoa(i)+"].claims", "claims are required")
+			}
+		}
+	}
+
+	if c := m.ClientCert; c != nil && !c.Disabled {
+		for i, san := range c.SANs {
+			if !strings.HasPrefix(san, "DNS:") && !strings.HasPrefix(san, "email:") &&
+				!strings.HasPrefix(san, "IP:") && !strings.HasPrefix(san, "URI:") {
+				v.errorf(path+".client_cert.sans["+strconv.Itoa(i)+"]", "invalid SAN %q, DNS:, email:, IP: or URI: prefix expected", san)
+			}
+		}
+	}
+
+	if c := m.CORS; c != nil && !c.Disabled {
+		if len(c.AllowedOrigins) == 0 {
+			v.errorf(path+".cors.allowed_origins", "allowed_origins is required")
+		} else if _, err := cors.NewHandler(c.Policy(), nil); err != nil {
+			v.errorf(path+".cors", "%v", err)
+		}
+	}
+}
+
+// validateUpstreamURL checks that the value is an absolute URL, or a
+// Unix socket URL (e.g. "unix:///var/run/app.sock").
+func (v *validator) validateUpstreamURL(path, value string) {
+	u, err := url.Parse(value)
+	if err != nil || !proxy.IsUnix(u) {
+		v.validateURL(path, value)
+		return
+	}
+
+	if len(u.Host) > 0 || len(u.Path) == 0 {
+		v.errorf(path, "socket path expected, got %q", value)
+	}
+}
+
+// validateURL checks that the value is an absolute URL.
+func (v *validator) validateURL(path, value string) {
+	if len(value) == 0 {
+		v.errorf(path, "URL is required")
+		return
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		v.errorf(path, "%v", err)
+		return
+	}
+
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		v.errorf(path, "absolute URL expected, got %q", value)
+	}
+}
+
+// Policy returns the CORS policy.
+func (c *CORSConfig) Policy() cors.Policy {
+	return cors.Policy{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+}
@@ -0,0 +1,139 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_allowOrigin(t *testing.T) {
+	p := &Policy{AllowedOrigins: []string{
+		"https://example.com",
+		"https://*.example.org",
+		`/^https://app-[0-9]+\.example\.net$/`,
+	}}
+
+	c, err := p.compile()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://example.com", want: true},
+		{origin: "https://EXAMPLE.com", want: true},
+		{origin: "http://example.com"},
+		{origin: "https://api.example.org", want: true},
+		{origin: "https://a.b.example.org", want: true},
+		{origin: "https://example.org"},
+		{origin: "https://evilexample.org"},
+		{origin: "https://app-12.example.net", want: true},
+		{origin: "https://app-x.example.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.allowOrigin(tt.origin))
+		})
+	}
+}
+
+func TestPolicy_compile(t *testing.T) {
+	_, err := (&Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}).compile()
+	assert.EqualError(t, err, `cors: the "*" origin cannot allow credentials`)
+
+	_, err = (&Policy{AllowedOrigins: []string{"/[/"}}).compile()
+	assert.Error(t, err)
+
+	_, err = (&Policy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}).compile()
+	assert.NoError(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	calls := 0
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.Header().Set("Access-Control-Allow-Origin", "*")
+		writer.Header().Set("X-Request-Id", "42")
+	})
+
+	h, err := NewHandler(Policy{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-Type"},
+		ExposedHeaders: []string{"X-Request-Id"},
+		MaxAge:         10 * time.Minute,
+	}, origin, WithRoute("/public", Policy{AllowedOrigins: []string{"*"}}))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	serve := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("Allowed preflight", func(t *testing.T) {
+		calls = 0
+		recorder := serve("OPTIONS", "/api", map[string]string{
+			"Origin":                         "https://example.com",
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "content-type",
+		})
+
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, "https://example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, PUT", recorder.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "content-type", recorder.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("Rejected preflight", func(t *testing.T) {
+		calls = 0
+		recorder := serve("OPTIONS", "/api", map[string]string{
+			"Origin":                        "https://example.com",
+			"Access-Control-Request-Method": "DELETE",
+		})
+
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("Allowed actual request", func(t *testing.T) {
+		recorder := serve("GET", "/api", map[string]string{"Origin": "https://example.com"})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "https://example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "X-Request-Id", recorder.Header().Get("Access-Control-Expose-Headers"))
+		assert.Equal(t, "Origin", recorder.Header().Get("Vary"))
+	})
+
+	t.Run("Not allowed actual request", func(t *testing.T) {
+		recorder := serve("GET", "/api", map[string]string{"Origin": "https://evil.com"})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("Route policy", func(t *testing.T) {
+		recorder := serve("GET", "/public/data", map[string]string{"Origin": "https://evil.com"})
+		assert.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("Same origin request", func(t *testing.T) {
+		recorder := serve("GET", "/api", nil)
+		assert.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
@@ -0,0 +1,207 @@
+package cors
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is a http.Handler that is used as a middleware
+// to apply a Cross-Origin Resource Sharing policy at the edge.
+//
+// The preflight requests are answered directly, without reaching
+// the origin handler. The CORS headers sent by the origin handler
+// are replaced by the ones of the policy.
+type Handler struct {
+
+	// Origin is the http handler that will have the CORS feature
+	// in front of it.
+	Origin http.Handler
+
+	// policy is the policy used when no route matches.
+	policy *compiledPolicy
+
+	// routes contains the policies by path prefix.
+	routes []route
+}
+
+// route represents the policy applied to a path prefix.
+type route struct {
+	prefix string
+	policy *Policy
+
+	compiled *compiledPolicy
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithRoute applies the policy to the requests whose path starts with
+// the prefix instead of the default policy.
+func WithRoute(prefix string, policy Policy) Option {
+	return func(h *Handler) {
+		h.routes = append(h.routes, route{prefix: prefix, policy: &policy})
+	}
+}
+
+// NewHandler creates a CORS middleware from a default policy and a
+// http.Handler. It returns an error if one of the policies is invalid.
+func NewHandler(policy Policy, o http.Handler, opts ...Option) (*Handler, error) {
+	compiled, err := policy.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		Origin: o,
+		policy: compiled,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	for i := range h.routes {
+		if h.routes[i].compiled, err = h.routes[i].policy.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Longest prefixes first, so the most specific route wins.
+	sort.SliceStable(h.routes, func(i, j int) bool {
+		return len(h.routes[i].prefix) > len(h.routes[j].prefix)
+	})
+
+	return h, nil
+}
+
+// ServeHTTP applies the CORS policy in front of the origin handler.
+//
+// The requests without "Origin" header are forwarded untouched.
+// A preflight request is answered with a 204 No Content status when
+// it is allowed by the policy, a 403 Forbidden status otherwise.
+//
+// More details can be found here: https://fetch.spec.whatwg.org/#http-cors-protocol
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	if len(origin) == 0 {
+		h.Origin.ServeHTTP(writer, request)
+		return
+	}
+
+	policy := h.match(request)
+	if isPreflight(request) {
+		h.preflight(writer, request, policy, origin)
+		return
+	}
+
+	writer.Header().Add("Vary", "Origin")
+
+	rw := &responseWriter{ResponseWriter: writer, policy: policy, origin: origin}
+	if !policy.allowOrigin(origin) {
+		logrus.WithContext(request.Context()).WithField("origin", origin).Debug("CORS origin not allowed")
+		rw.policy = nil
+	}
+
+	h.Origin.ServeHTTP(rw, request)
+
+	// The origin handler may not have written anything.
+	rw.applyHeaders()
+}
+
+// preflight answers a preflight request.
+func (h *Handler) preflight(writer http.ResponseWriter, request *http.Request, policy *compiledPolicy, origin string) {
+	headers := writer.Header()
+	headers.Add("Vary", "Origin")
+	headers.Add("Vary", "Access-Control-Request-Method")
+	headers.Add("Vary", "Access-Control-Request-Headers")
+
+	method := strings.ToUpper(request.Header.Get("Access-Control-Request-Method"))
+	requestedHeaders := request.Header.Get("Access-Control-Request-Headers")
+
+	if !policy.allowOrigin(origin) || !policy.methods[method] || !policy.allowHeaders(requestedHeaders) {
+		logrus.WithContext(request.Context()).WithFields(logrus.Fields{
+			"origin":  origin,
+			"method":  method,
+			"headers": requestedHeaders,
+		}).Debug("CORS preflight rejected")
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	policy.setPreflightHeaders(headers, origin, requestedHeaders)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// match returns the policy of the most specific route matching the
+// request path.
+func (h *Handler) match(request *http.Request) *compiledPolicy {
+	for _, r := range h.routes {
+		if strings.HasPrefix(request.URL.Path, r.prefix) {
+			return r.compiled
+		}
+	}
+
+	return h.policy
+}
+
+// isPreflight checks if the request is a CORS preflight request.
+func isPreflight(request *http.Request) bool {
+	return request.Method == http.MethodOptions &&
+		len(request.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+// responseWriter replaces the CORS headers of the origin response
+// by the ones of the policy.
+type responseWriter struct {
+	http.ResponseWriter
+
+	// policy is the policy to apply. No CORS header is sent when nil.
+	policy *compiledPolicy
+	origin string
+
+	wroteHeader bool
+}
+
+// WriteHeader is the "http.ResponseWriter" interface implementation.
+func (r *responseWriter) WriteHeader(statusCode int) {
+	r.applyHeaders()
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// applyHeaders replaces the CORS headers once, before the response
+// headers are sent.
+func (r *responseWriter) applyHeaders() {
+	if r.wroteHeader {
+		return
+	}
+
+	r.wroteHeader = true
+
+	headers := r.Header()
+	for key := range headers {
+		if strings.HasPrefix(key, "Access-Control-") {
+			headers.Del(key)
+		}
+	}
+
+	if r.policy != nil {
+		r.policy.setActualHeaders(headers, r.origin)
+	}
+}
+
+// Write is the "http.ResponseWriter" interface implementation.
+func (r *responseWriter) Write(bytes []byte) (int, error) {
+	r.applyHeaders()
+	return r.ResponseWriter.Write(bytes)
+}
+
+// Flush is the "http.Flusher" interface implementation.
+func (r *responseWriter) Flush() {
+	r.applyHeaders()
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
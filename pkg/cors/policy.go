@@ -0,0 +1,214 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy represents the Cross-Origin Resource Sharing rules
+// applied to a route.
+type Policy struct {
+
+	// AllowedOrigins contains the origins allowed to access the
+	// resources. An origin can be:
+	//   - "*" to allow any origin,
+	//   - an exact origin (e.g. "https://example.com"),
+	//   - a wildcard subdomain (e.g. "https://*.example.com"),
+	//   - a regular expression surrounded by slashes (e.g. "/^https://.*\.example\.com$/").
+	AllowedOrigins []string
+
+	// AllowedMethods contains the methods allowed for the cross-origin
+	// requests. The simple methods (GET, HEAD, POST) are used when empty.
+	AllowedMethods []string
+
+	// AllowedHeaders contains the request headers allowed for the
+	// cross-origin requests. "*" allows any header.
+	AllowedHeaders []string
+
+	// ExposedHeaders contains the response headers the browsers are
+	// allowed to expose to the client scripts.
+	ExposedHeaders []string
+
+	// AllowCredentials allows the cross-origin requests to carry the
+	// credentials (cookies, authorization headers, ...). It cannot be
+	// used with the "*" origin.
+	AllowCredentials bool
+
+	// MaxAge is the duration the preflight responses can be cached by
+	// the browsers. It is not sent when zero.
+	MaxAge time.Duration
+}
+
+// compiledPolicy is a Policy ready to be evaluated.
+type compiledPolicy struct {
+	policy *Policy
+
+	anyOrigin bool
+	origins   map[string]bool
+	wildcards []wildcardOrigin
+	patterns  []*regexp.Regexp
+
+	methods    map[string]bool
+	anyHeader  bool
+	headers    map[string]bool
+	methodsStr string
+}
+
+// wildcardOrigin represents a "scheme://*.domain" origin.
+type wildcardOrigin struct {
+	prefix string
+	suffix string
+}
+
+// compile validates the policy and prepares the origin matchers.
+func (p *Policy) compile() (*compiledPolicy, error) {
+	c := &compiledPolicy{
+		policy:  p,
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+
+	for _, origin := range p.AllowedOrigins {
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+
+		case len(origin) > 2 && strings.HasPrefix(origin, "/") && strings.HasSuffix(origin, "/"):
+			pattern, err := regexp.Compile(origin[1 : len(origin)-1])
+			if err != nil {
+				return nil, fmt.Errorf("cors: invalid origin pattern %q: %w", origin, err)
+			}
+			c.patterns = append(c.patterns, pattern)
+
+		case strings.Contains(origin, "*"):
+			pos := strings.Index(origin, "*")
+			c.wildcards = append(c.wildcards, wildcardOrigin{
+				prefix: strings.ToLower(origin[:pos]),
+				suffix: strings.ToLower(origin[pos+1:]),
+			})
+
+		default:
+			c.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	// Any site could read the responses of the user otherwise.
+	if c.anyOrigin && p.AllowCredentials {
+		return nil, fmt.Errorf("cors: the %q origin cannot allow credentials", "*")
+	}
+
+	allowedMethods := p.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+
+	methods := make([]string, 0, len(allowedMethods))
+	for _, method := range allowedMethods {
+		method = strings.ToUpper(method)
+		methods = append(methods, method)
+		c.methods[method] = true
+	}
+	c.methodsStr = strings.Join(methods, ", ")
+
+	for _, header := range p.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return c, nil
+}
+
+// allowOrigin checks if the origin is allowed by the policy.
+func (c *compiledPolicy) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+
+	for _, w := range c.wildcards {
+		if len(origin) > len(w.prefix)+len(w.suffix) &&
+			strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix) {
+			return true
+		}
+	}
+
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowHeaders checks if all the requested headers are allowed.
+func (c *compiledPolicy) allowHeaders(requested string) bool {
+	if c.anyHeader {
+		return true
+	}
+
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if len(header) == 0 {
+			continue
+		}
+
+		if !c.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setOriginHeaders adds the headers shared by the preflight and the
+// actual responses.
+func (c *compiledPolicy) setOriginHeaders(headers http.Header, origin string) {
+	if c.anyOrigin {
+		headers.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		headers.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.policy.AllowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// setPreflightHeaders adds the preflight response headers.
+func (c *compiledPolicy) setPreflightHeaders(headers http.Header, origin, requestedHeaders string) {
+	c.setOriginHeaders(headers, origin)
+
+	headers.Set("Access-Control-Allow-Methods", c.methodsStr)
+
+	if len(requestedHeaders) > 0 {
+		// Reflecting the requested headers is valid, they have all been
+		// checked against the policy.
+		headers.Set("Access-Control-Allow-Headers", requestedHeaders)
+	}
+
+	if c.policy.MaxAge > 0 {
+		headers.Set("Access-Control-Max-Age", strconv.Itoa(int(c.policy.MaxAge.Seconds())))
+	}
+}
+
+// setActualHeaders adds the actual response headers.
+func (c *compiledPolicy) setActualHeaders(headers http.Header, origin string) {
+	c.setOriginHeaders(headers, origin)
+
+	if len(c.policy.ExposedHeaders) > 0 {
+		headers.Set("Access-Control-Expose-Headers", strings.Join(c.policy.ExposedHeaders, ", "))
+	}
+}
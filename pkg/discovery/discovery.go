@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/sirupsen/logrus"
+)
+
+// Groups maps the upstream group names to their members.
+type Groups map[string][]*proxy.Upstream
+
+// Provider is an interface that discovers the upstream groups.
+type Provider interface {
+
+	// Groups returns the current upstream groups.
+	Groups() (Groups, error)
+
+	// Watch calls update with the new upstream groups each time they
+	// change, until the stop channel is closed. The errors are logged
+	// and the last known groups are kept.
+	Watch(stop <-chan struct{}, update func(Groups))
+}
+
+// Pools applies the discovered groups to the running upstream pools.
+type Pools struct {
+	mu    sync.Mutex
+	pools map[string]*proxy.Pool
+}
+
+// NewPools creates an empty set of pools.
+func NewPools() *Pools {
+	return &Pools{pools: make(map[string]*proxy.Pool)}
+}
+
+// Add registers the pool of a group.
+func (p *Pools) Add(name string, pool *proxy.Pool) *Pools {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pools[name] = pool
+	return p
+}
+
+// Apply updates the pool members with the given groups. A registered
+// group missing from the update has its pool emptied. The groups
+// without a registered pool are ignored.
+//
+// The in-flight requests are not affected: they keep the upstream
+// they already selected.
+func (p *Pools) Apply(groups Groups) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, pool := range p.pools {
+		pool.SetUpstreams(groups[name])
+	}
+
+	var unknown []string
+	for name := range groups {
+		if _, ok := p.pools[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		logrus.WithField("groups", unknown).Warn("Ignoring unknown upstream groups")
+	}
+}
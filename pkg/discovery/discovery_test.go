@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func urls(upstreams []*proxy.Upstream) []string {
+	var values []string
+	for _, u := range upstreams {
+		values = append(values, u.URL.String())
+	}
+	return values
+}
+
+func TestFile_Groups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			name:    "YAML",
+			content: "groups:\n  default:\n    - http://10.0.0.1:8080\n    - http://10.0.0.2:8080\n  canary:\n    - url: http://10.0.1.1:8080\n      weight: 2\n",
+			want:    map[string]int{"default": 2, "canary": 1},
+		},
+		{
+			name:    "JSON",
+			content: `{"groups": {"default": ["http://10.0.0.1:8080"]}}`,
+			want:    map[string]int{"default": 1},
+		},
+		{
+			name:    "Unix socket",
+			content: "groups:\n  default:\n    - unix:///run/app.sock\n    - http://10.0.0.1:8080\n",
+			want:    map[string]int{"default": 2},
+		},
+		{
+			name:    "Unix socket without path",
+			content: "groups:\n  default:\n    - unix://app.sock\n",
+			wantErr: true,
+		},
+		{
+			name:    "Relative endpoint",
+			content: "groups:\n  default:\n    - 10.0.0.1:8080\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid syntax",
+			content: "groups: [",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "upstreams")
+			writeFile(t, path, tt.content)
+
+			groups, err := NewFile(path, 0).Groups()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				counts := make(map[string]int)
+				for name, endpoints := range groups {
+					counts[name] = len(endpoints)
+				}
+				assert.Equal(t, tt.want, counts)
+			}
+		})
+	}
+}
+
+func TestFile_Watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "upstreams.yaml")
+	writeFile(t, path, "groups:\n  default:\n    - http://10.0.0.1:8080\n")
+
+	updates := make(chan Groups, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go NewFile(path, 10*time.Millisecond).Watch(stop, func(groups Groups) {
+		updates <- groups
+	})
+
+	// Invalid content is not applied.
+	time.Sleep(20 * time.Millisecond)
+	writeFile(t, path, "groups: [")
+	_ = os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+
+	select {
+	case <-updates:
+		t.Fatal("invalid file applied")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeFile(t, path, "groups:\n  default:\n    - http://10.0.0.2:8080\n")
+	_ = os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+
+	select {
+	case groups := <-updates:
+		if assert.Len(t, groups["default"], 1) {
+			assert.Equal(t, "http://10.0.0.2:8080", groups["default"][0].URL.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update not received")
+	}
+}
+
+func TestPools_Apply(t *testing.T) {
+	parse := func(value string) *url.URL {
+		u, _ := url.Parse(value)
+		return u
+	}
+
+	kept := proxy.NewUpstream(parse("http://10.0.0.1:8080"))
+	kept.SetHealthy(false)
+	changed := proxy.NewUpstream(parse("http://10.0.0.2:8080"))
+	changed.SetHealthy(false)
+	other := proxy.NewUpstream(parse("http://10.0.1.1:8080"))
+
+	defaultPool := proxy.NewPool(kept, changed)
+	otherPool := proxy.NewPool(other)
+	pools := NewPools().Add("default", defaultPool).Add("other", otherPool)
+
+	reweighted := proxy.NewUpstream(parse("http://10.0.0.2:8080"))
+	reweighted.Weight = 3
+
+	pools.Apply(Groups{
+		"default": {proxy.NewUpstream(parse("http://10.0.0.1:8080")), reweighted},
+		"unknown": {proxy.NewUpstream(parse("http://10.0.2.1:8080"))},
+	})
+
+	upstreams := defaultPool.Upstreams()
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}, urls(upstreams))
+	assert.True(t, upstreams[0] == kept, "the existing upstream is kept")
+	assert.False(t, upstreams[0].Healthy(), "the health state is kept")
+	assert.Equal(t, 3, upstreams[1].Weight, "the weight is updated")
+	assert.False(t, upstreams[1].Healthy(), "the health state of a reweighted upstream is kept")
+
+	assert.Empty(t, otherPool.Upstreams(), "the missing group is emptied")
+}
@@ -0,0 +1,471 @@
+package discovery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultDNSTimeout is the default timeout of a DNS query.
+	DefaultDNSTimeout = 5 * time.Second
+
+	// MinDNSInterval is the minimum duration between two resolutions,
+	// whatever the records TTL is.
+	MinDNSInterval = time.Second
+
+	// maxDNSInterval is the duration between two resolutions when the
+	// TTL is used and no record was received.
+	maxDNSInterval = 30 * time.Second
+
+	// srvSuffix is the URL scheme suffix of the SRV targets.
+	srvSuffix = "+srv"
+)
+
+// DNS is a Provider resolving the upstream groups from DNS records.
+//
+// The targets are URLs whose host is resolved:
+//
+//	http://backend.internal:8080      A and AAAA records, port 8080
+//	http+srv://_http._tcp.internal     SRV records
+//
+// Each address becomes a pool member. The SRV records of the lowest
+// priority are used, with their port and weight.
+//
+// The records are re-resolved on a fixed interval, or when their TTL
+// expires if the interval is zero. On failure, the last known members
+// are kept.
+type DNS struct {
+
+	// server is the address of the DNS server.
+	server string
+
+	// interval is the duration between two resolutions. The TTL of the
+	// records is used when it is zero.
+	interval time.Duration
+
+	// timeout is the timeout of a DNS query.
+	timeout time.Duration
+
+	targets []dnsTarget
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// dnsTarget is a resolved target of a group.
+type dnsTarget struct {
+	group string
+	url   *url.URL
+	srv   bool
+}
+
+// Static implementation checker.
+var _ Provider = (*DNS)(nil)
+
+// NewDNS creates a provider querying the given DNS server
+// ("host:port"). The first name server of /etc/resolv.conf is used
+// when it is empty.
+func NewDNS(server string, interval time.Duration) (*DNS, error) {
+	if len(server) == 0 {
+		var err error
+		if server, err = systemNameServer("/etc/resolv.conf"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &DNS{
+		server:   server,
+		interval: interval,
+		timeout:  DefaultDNSTimeout,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Add registers a target to resolve into the members of the group.
+func (d *DNS) Add(group string, target *url.URL) error {
+	t := dnsTarget{group: group, url: target}
+	if strings.HasSuffix(target.Scheme, srvSuffix) {
+		t.srv = true
+		if len(target.Port()) > 0 {
+			return fmt.Errorf("discovery: invalid DNS target %q: the SRV records provide the port", target)
+		}
+	}
+
+	if len(target.Hostname()) == 0 {
+		return fmt.Errorf("discovery: invalid DNS target %q: host expected", target)
+	}
+
+	d.targets = append(d.targets, t)
+	return nil
+}
+
+// Groups is the `Provider` interface implementation.
+func (d *DNS) Groups() (Groups, error) {
+	groups, _, err := d.resolve()
+	return groups, err
+}
+
+// Watch is the `Provider` interface implementation. The update
+// function is only called when the members changed.
+func (d *DNS) Watch(stop <-chan struct{}, update func(Groups)) {
+	groups, ttl, err := d.resolve()
+	if err != nil {
+		logrus.WithError(err).Error("Error while resolving DNS upstreams")
+	}
+	last := signature(groups)
+
+	timer := time.NewTimer(d.nextResolution(ttl))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		groups, ttl, err = d.resolve()
+		timer.Reset(d.nextResolution(ttl))
+
+		if err != nil {
+			logrus.WithError(err).Error("Error while resolving DNS upstreams")
+			continue
+		}
+
+		if s := signature(groups); s != last {
+			last = s
+			logrus.WithField("server", d.server).Info("DNS upstreams changed")
+			update(groups)
+		}
+	}
+}
+
+// nextResolution returns the duration until the next resolution.
+func (d *DNS) nextResolution(ttl time.Duration) time.Duration {
+	interval := d.interval
+	if interval <= 0 {
+		interval = ttl
+	}
+
+	if interval < MinDNSInterval {
+		interval = MinDNSInterval
+	}
+
+	return interval
+}
+
+// resolve resolves all the targets. It returns the groups and the
+// lowest TTL of the records.
+func (d *DNS) resolve() (Groups, time.Duration, error) {
+	groups := make(Groups)
+	ttl := maxDNSInterval
+
+	for _, t := range d.targets {
+		var upstreams []*proxy.Upstream
+		var targetTTL time.Duration
+		var err error
+		if t.srv {
+			upstreams, targetTTL, err = d.resolveSRV(t.url)
+		} else {
+			upstreams, targetTTL, err = d.resolveHost(t.url, t.url.Port(), 0)
+		}
+
+		if err != nil {
+			return nil, 0, fmt.Errorf("discovery: cannot resolve %q: %w", t.url, err)
+		}
+
+		if targetTTL < ttl {
+			ttl = targetTTL
+		}
+
+		groups[t.group] = append(groups[t.group], upstreams...)
+	}
+
+	for _, upstreams := range groups {
+		sort.Slice(upstreams, func(i, j int) bool {
+			return upstreams[i].URL.String() < upstreams[j].URL.String()
+		})
+	}
+
+	return groups, ttl, nil
+}
+
+// resolveHost creates the members of the addresses of the target host.
+func (d *DNS) resolveHost(target *url.URL, port string, weight int) ([]*proxy.Upstream, time.Duration, error) {
+	var ips []net.IP
+	ttl := maxDNSInterval
+
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := d.query(target.Hostname(), qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, answer := range answers {
+			ips = append(ips, addressOf(answer))
+			ttl = minTTL(ttl, answer.Header.TTL)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("no address for %s", target.Hostname())
+	}
+
+	return members(target, ips, port, weight), ttl, nil
+}
+
+// resolveSRV creates the members of the SRV records of the lowest
+// priority.
+func (d *DNS) resolveSRV(target *url.URL) ([]*proxy.Upstream, time.Duration, error) {
+	answers, err := d.query(target.Hostname(), dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []*dnsmessage.SRVResource
+	ttl := maxDNSInterval
+	for _, answer := range answers {
+		srv := answer.Body.(*dnsmessage.SRVResource)
+		if len(records) > 0 && srv.Priority > records[0].Priority {
+			continue
+		}
+		if len(records) > 0 && srv.Priority < records[0].Priority {
+			records = records[:0]
+		}
+
+		records = append(records, srv)
+		ttl = minTTL(ttl, answer.Header.TTL)
+	}
+
+	if len(records) == 0 {
+		return nil, 0, fmt.Errorf("no SRV record for %s", target.Hostname())
+	}
+
+	scheme := strings.TrimSuffix(target.Scheme, srvSuffix)
+	var upstreams []*proxy.Upstream
+	for _, srv := range records {
+		host := &url.URL{Scheme: scheme, Host: srv.Target.String(), Path: target.Path}
+		members, hostTTL, err := d.resolveHost(host, strconv.Itoa(int(srv.Port)), int(srv.Weight))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		upstreams = append(upstreams, members...)
+		if hostTTL < ttl {
+			ttl = hostTTL
+		}
+	}
+
+	return upstreams, ttl, nil
+}
+
+// query sends a question to the DNS server and returns the answers of
+// the requested type. The query is sent over UDP, and over TCP when the
+// response is truncated.
+func (d *DNS) query(host string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.randMu.Lock()
+	id := uint16(d.rand.Intn(1 << 16))
+	d.randMu.Unlock()
+
+	question := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+
+	packet, err := question.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := d.exchange("udp", packet)
+	if err == nil && response.Truncated {
+		response, err = d.exchange("tcp", packet)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if response.ID != id || !response.Response {
+		return nil, errors.New("unexpected DNS response")
+	}
+
+	if response.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("DNS query for %s failed: %s", host, response.RCode)
+	}
+
+	var answers []dnsmessage.Resource
+	for _, answer := range response.Answers {
+		if answer.Header.Type == qtype {
+			answers = append(answers, answer)
+		}
+	}
+
+	return answers, nil
+}
+
+// exchange sends the packet over the given network and parses the
+// response.
+func (d *DNS) exchange(network string, packet []byte) (*dnsmessage.Message, error) {
+	conn, err := net.DialTimeout(network, d.server, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+		return nil, err
+	}
+
+	var buffer []byte
+	if network == "tcp" {
+		// The TCP messages are prefixed by their length.
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(packet)))
+		if _, err := conn.Write(append(length, packet...)); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+
+		buffer = make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, buffer); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+
+		buffer = make([]byte, 65535)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		buffer = buffer[:n]
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buffer); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// members creates the upstreams of the addresses.
+func members(target *url.URL, ips []net.IP, port string, weight int) []*proxy.Upstream {
+	upstreams := make([]*proxy.Upstream, 0, len(ips))
+	for _, ip := range ips {
+		host := ip.String()
+		if len(port) > 0 {
+			host = net.JoinHostPort(host, port)
+		} else if ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		u := *target
+		u.Host = host
+
+		upstream := proxy.NewUpstream(&u)
+		upstream.Weight = weight
+		upstreams = append(upstreams, upstream)
+	}
+
+	return upstreams
+}
+
+// addressOf returns the IP address of an A or AAAA record.
+func addressOf(answer dnsmessage.Resource) net.IP {
+	switch body := answer.Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(body.A[:])
+	case *dnsmessage.AAAAResource:
+		return net.IP(body.AAAA[:])
+	}
+
+	return nil
+}
+
+// minTTL returns the lowest of the duration and the TTL in seconds.
+func minTTL(d time.Duration, ttl uint32) time.Duration {
+	if t := time.Duration(ttl) * time.Second; t < d {
+		return t
+	}
+	return d
+}
+
+// signature returns a textual representation of the groups, used to
+// detect the changes.
+func signature(groups Groups) string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		for _, u := range groups[name] {
+			fmt.Fprintf(&b, " %s/%d", u.URL, u.Weight)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// systemNameServer returns the address of the first name server of
+// the resolv.conf file.
+func systemNameServer(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("discovery: cannot find the system name server: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("discovery: no name server in %s", path)
+}
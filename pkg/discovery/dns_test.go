@@ -0,0 +1,264 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubDNS is a minimal DNS server answering from a static zone, over
+// UDP and TCP on the same port.
+type stubDNS struct {
+	udp net.PacketConn
+	tcp net.Listener
+
+	mu sync.Mutex
+	// records maps the questions to the answers.
+	records map[dnsmessage.Question][]dnsmessage.Resource
+	// truncated lists the names answered with the truncated flag over
+	// UDP.
+	truncated map[string]bool
+}
+
+func newStubDNS(t *testing.T) *stubDNS {
+	t.Helper()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("TCP port not available: %v", err)
+	}
+
+	s := &stubDNS{
+		udp:       udp,
+		tcp:       tcp,
+		records:   make(map[dnsmessage.Question][]dnsmessage.Resource),
+		truncated: make(map[string]bool),
+	}
+
+	go s.serveUDP()
+	go s.serveTCP()
+
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	return s
+}
+
+func (s *stubDNS) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+func (s *stubDNS) set(name string, ttl uint32, bodies ...dnsmessage.ResourceBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET}
+	var answers []dnsmessage.Resource
+	for _, body := range bodies {
+		switch body.(type) {
+		case *dnsmessage.AResource:
+			q.Type = dnsmessage.TypeA
+		case *dnsmessage.AAAAResource:
+			q.Type = dnsmessage.TypeAAAA
+		case *dnsmessage.SRVResource:
+			q.Type = dnsmessage.TypeSRV
+		}
+
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: ttl}
+		answers = append(answers, dnsmessage.Resource{Header: header, Body: body})
+	}
+
+	s.records[q] = answers
+}
+
+func (s *stubDNS) truncate(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.truncated[name] = true
+}
+
+func (s *stubDNS) answer(request []byte, udp bool) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(request); err != nil || len(query.Questions) != 1 {
+		return nil
+	}
+
+	q := query.Questions[0]
+
+	s.mu.Lock()
+	answers := s.records[q]
+	truncated := udp && s.truncated[q.Name.String()]
+	s.mu.Unlock()
+
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, Truncated: truncated},
+		Questions: query.Questions,
+	}
+	if !truncated {
+		response.Answers = answers
+	}
+
+	packet, _ := response.Pack()
+	return packet
+}
+
+func (s *stubDNS) serveUDP() {
+	buffer := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		if packet := s.answer(buffer[:n], true); packet != nil {
+			_, _ = s.udp.WriteTo(packet, addr)
+		}
+	}
+}
+
+func (s *stubDNS) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			length := make([]byte, 2)
+			if _, err := io.ReadFull(conn, length); err != nil {
+				return
+			}
+
+			request := make([]byte, binary.BigEndian.Uint16(length))
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+
+			packet := s.answer(request, false)
+			binary.BigEndian.PutUint16(length, uint16(len(packet)))
+			_, _ = conn.Write(append(length, packet...))
+		}()
+	}
+}
+
+func srv(priority, weight, port uint16, target string) *dnsmessage.SRVResource {
+	return &dnsmessage.SRVResource{Priority: priority, Weight: weight, Port: port, Target: dnsmessage.MustNewName(target)}
+}
+
+func TestDNS_Groups(t *testing.T) {
+	stub := newStubDNS(t)
+	stub.set("backend.internal.", 60,
+		&dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+		&dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}},
+	)
+	stub.set("backend.internal.", 60,
+		&dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 15: 1}},
+	)
+	stub.set("_http._tcp.internal.", 60,
+		srv(10, 3, 8081, "a.internal."),
+		srv(10, 1, 8082, "b.internal."),
+		srv(20, 1, 8083, "backup.internal."),
+	)
+	stub.set("a.internal.", 60, &dnsmessage.AResource{A: [4]byte{10, 0, 1, 1}})
+	stub.set("b.internal.", 60, &dnsmessage.AResource{A: [4]byte{10, 0, 1, 2}})
+	stub.set("big.internal.", 60, &dnsmessage.AResource{A: [4]byte{10, 0, 2, 1}})
+	stub.truncate("big.internal.")
+
+	d, err := NewDNS(stub.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for group, target := range map[string]string{
+		"hosts": "http://backend.internal:8080/api",
+		"srv":   "http+srv://_http._tcp.internal",
+		"tcp":   "http://big.internal",
+	} {
+		u, _ := url.Parse(target)
+		if err := d.Add(group, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := d.Groups()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"http://10.0.0.1:8080/api", "http://10.0.0.2:8080/api", "http://[fd00::1]:8080/api"}, urls(groups["hosts"]))
+
+	// Only the records of the lowest priority are used.
+	assert.Equal(t, []string{"http://10.0.1.1:8081", "http://10.0.1.2:8082"}, urls(groups["srv"]))
+	assert.Equal(t, 3, groups["srv"][0].Weight)
+	assert.Equal(t, 1, groups["srv"][1].Weight)
+
+	// The truncated response is queried again over TCP.
+	assert.Equal(t, []string{"http://10.0.2.1"}, urls(groups["tcp"]))
+}
+
+func TestDNS_Watch(t *testing.T) {
+	stub := newStubDNS(t)
+	stub.set("backend.internal.", 1, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}})
+
+	d, err := NewDNS(stub.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://backend.internal")
+	if err := d.Add("default", u); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan Groups, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Watch(stop, func(groups Groups) {
+		updates <- groups
+	})
+
+	// Let the watcher resolve the initial records.
+	time.Sleep(100 * time.Millisecond)
+	stub.set("backend.internal.", 1,
+		&dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+		&dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}},
+	)
+
+	// The records are resolved again when their TTL expires.
+	select {
+	case groups := <-updates:
+		assert.Equal(t, []string{"http://10.0.0.1", "http://10.0.0.2"}, urls(groups["default"]))
+	case <-time.After(3 * time.Second):
+		t.Fatal("update not received")
+	}
+}
+
+func TestDNS_Add(t *testing.T) {
+	d, err := NewDNS("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"http+srv://_http._tcp.internal:80", "http://"} {
+		u, _ := url.Parse(target)
+		assert.Error(t, d.Add("default", u), target)
+	}
+}
@@ -0,0 +1,160 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultCheckInterval is the default duration between two checks of
+// the file modification time.
+const DefaultCheckInterval = time.Second
+
+// File is a Provider reading the upstream groups from a YAML or JSON
+// file:
+//
+//	groups:
+//	  default:
+//	    - http://10.0.0.1:8080
+//	    - http://10.0.0.2:8080
+//	    - unix:///run/app.sock
+//	  canary:
+//	    - url: http://10.0.1.1:8080
+//	      weight: 2
+//
+// The file is watched for changes by polling its modification time.
+type File struct {
+
+	// path is the file location.
+	path string
+
+	// checkInterval is the duration between two checks of the file
+	// modification time.
+	checkInterval time.Duration
+}
+
+// Static implementation checker.
+var _ Provider = (*File)(nil)
+
+// fileContent is the representation of the discovery file.
+type fileContent struct {
+	Groups map[string][]fileEndpoint `yaml:"groups"`
+}
+
+// fileEndpoint is an upstream of the discovery file, either written
+// as a URL or as a mapping with a weight.
+type fileEndpoint struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// UnmarshalYAML is the `yaml.Unmarshaler` interface implementation.
+func (e *fileEndpoint) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&e.URL)
+	}
+
+	type plain fileEndpoint
+	return value.Decode((*plain)(e))
+}
+
+// NewFile creates a provider reading the file located at the given
+// path. The file is checked every checkInterval, or every
+// DefaultCheckInterval when it is zero.
+func NewFile(path string, checkInterval time.Duration) *File {
+	if checkInterval <= 0 {
+		checkInterval = DefaultCheckInterval
+	}
+
+	return &File{path: path, checkInterval: checkInterval}
+}
+
+// Groups is the `Provider` interface implementation.
+func (f *File) Groups() (Groups, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON being a subset of YAML, both formats are read by the
+	// YAML decoder.
+	var content fileContent
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("discovery: invalid file %s: %w", f.path, err)
+	}
+
+	groups := make(Groups, len(content.Groups))
+	for name, endpoints := range content.Groups {
+		upstreams := make([]*proxy.Upstream, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			u, err := url.Parse(endpoint.URL)
+			if err != nil {
+				return nil, fmt.Errorf("discovery: invalid endpoint %q in group %q: %w", endpoint.URL, name, err)
+			}
+			if proxy.IsUnix(u) {
+				if len(u.Host) > 0 || len(u.Path) == 0 {
+					return nil, fmt.Errorf("discovery: invalid endpoint %q in group %q: socket path expected", endpoint.URL, name)
+				}
+			} else if len(u.Scheme) == 0 || len(u.Host) == 0 {
+				return nil, fmt.Errorf("discovery: invalid endpoint %q in group %q: absolute URL expected", endpoint.URL, name)
+			}
+			if endpoint.Weight < 0 {
+				return nil, fmt.Errorf("discovery: negative weight for endpoint %q in group %q", endpoint.URL, name)
+			}
+
+			upstream := proxy.NewUpstream(u)
+			upstream.Weight = endpoint.Weight
+			upstreams = append(upstreams, upstream)
+		}
+		groups[name] = upstreams
+	}
+
+	return groups, nil
+}
+
+// Watch is the `Provider` interface implementation. The whole file is
+// validated before being applied: on error, the previous groups are
+// kept.
+func (f *File) Watch(stop <-chan struct{}, update func(Groups)) {
+	var modTime time.Time
+	if info, err := os.Stat(f.path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(f.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(f.path)
+		if err != nil {
+			logrus.WithError(err).WithField("path", f.path).Error("Error while checking discovery file")
+			continue
+		}
+
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+
+		groups, err := f.Groups()
+		if err != nil {
+			logrus.WithError(err).WithField("path", f.path).Error("Error while reloading discovery file")
+			continue
+		}
+
+		logrus.WithField("path", f.path).Info("Reloaded discovery file")
+		update(groups)
+	}
+}
@@ -0,0 +1,159 @@
+package forwardauth
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is a http.Handler that is used as a middleware
+// to delegate the authentication to an external service.
+//
+// For each incoming request, a subrequest is sent to the auth
+// service. A 2xx answer lets the request reach the origin handler,
+// any other answer is sent back to the client.
+type Handler struct {
+
+	// Address is the auth service endpoint.
+	Address *url.URL
+
+	// Origin is the http handler that will have the authentication
+	// feature in front of it.
+	Origin http.Handler
+
+	// client is the http client used to send the subrequests.
+	client *http.Client
+
+	// requestHeaders is the list of the incoming request headers that
+	// are copied to the subrequest.
+	requestHeaders []string
+
+	// responseHeaders is the list of the auth service response headers
+	// that are copied to the forwarded request.
+	responseHeaders []string
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithRequestHeaders copies the given incoming request headers to
+// the subrequest. By default, only the "Authorization" and "Cookie"
+// headers are sent.
+func WithRequestHeaders(headers ...string) Option {
+	return func(h *Handler) {
+		h.requestHeaders = headers
+	}
+}
+
+// WithResponseHeaders copies the given auth service response headers
+// to the forwarded request when the authentication succeeded.
+func WithResponseHeaders(headers ...string) Option {
+	return func(h *Handler) {
+		h.responseHeaders = headers
+	}
+}
+
+// WithTransport sets the transport used to reach the auth service.
+func WithTransport(t http.RoundTripper) Option {
+	return func(h *Handler) {
+		h.client.Transport = t
+	}
+}
+
+// NewHandler creates a forward-auth middleware from the auth service
+// address and a http.Handler.
+func NewHandler(address *url.URL, o http.Handler, opts ...Option) *Handler {
+	h := &Handler{
+		Address: address,
+		Origin:  o,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+
+			// The login redirects have to be sent back to the client.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		requestHeaders: []string{"Authorization", "Cookie"},
+	}
+
+	for _, o := range opts {
+		o(h)
+	}
+
+	return h
+}
+
+// ServeHTTP adds the authentication in front of the origin handler.
+//
+// The subrequest uses the original method and carries the original
+// request information in the "X-Forwarded-Method", "X-Forwarded-Uri",
+// "X-Forwarded-Host" and "X-Forwarded-Proto" headers.
+// If the auth service is not reachable, it sends back a 502 Bad
+// Gateway status to the client.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	authRequest, err := http.NewRequestWithContext(request.Context(), request.Method, h.Address.String(), nil)
+	if err != nil {
+		logrus.WithContext(request.Context()).WithError(err).Error("Error while creating auth request")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for _, header := range h.requestHeaders {
+		for _, value := range request.Header.Values(header) {
+			authRequest.Header.Add(header, value)
+		}
+	}
+
+	proto := "http"
+	if request.TLS != nil {
+		proto = "https"
+	}
+
+	authRequest.Header.Set("X-Forwarded-Method", request.Method)
+	authRequest.Header.Set("X-Forwarded-Uri", request.URL.RequestURI())
+	authRequest.Header.Set("X-Forwarded-Host", request.Host)
+	authRequest.Header.Set("X-Forwarded-Proto", proto)
+
+	response, err := h.client.Do(authRequest)
+	if err != nil {
+		logrus.WithContext(request.Context()).WithError(err).Error("Error while sending auth request")
+		writer.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		logrus.WithContext(request.Context()).WithField("status", response.StatusCode).WithField("resource", request.URL.RequestURI()).Debug("Authentication rejected")
+		forwardResponse(response, writer)
+		return
+	}
+
+	outgoingRequest := request.Clone(request.Context())
+	for _, header := range h.responseHeaders {
+		// Never trust the client supplied values.
+		outgoingRequest.Header.Del(header)
+		for _, value := range response.Header.Values(header) {
+			outgoingRequest.Header.Add(header, value)
+		}
+	}
+
+	h.Origin.ServeHTTP(writer, outgoingRequest)
+}
+
+// forwardResponse sends the auth service response back to the client.
+func forwardResponse(response *http.Response, writer http.ResponseWriter) {
+	headers := writer.Header()
+	for key, values := range response.Header {
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+
+	writer.WriteHeader(response.StatusCode)
+	_, _ = io.Copy(writer, response.Body)
+}
@@ -0,0 +1,135 @@
+package forwardauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	// The auth service accepts the "valid" token, and redirects the
+	// other requests to its login page.
+	var authRequest *http.Request
+	auth := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		authRequest = request
+		if request.Header.Get("Authorization") != "valid" {
+			writer.Header().Set("Location", "https://login.example.com/")
+			writer.Header().Set("X-Auth-User", "nobody")
+			writer.WriteHeader(http.StatusFound)
+			_, _ = writer.Write([]byte("login required"))
+			return
+		}
+
+		writer.Header().Add("X-Auth-User", "alice")
+		writer.Header().Add("X-Auth-Groups", "admin")
+		writer.Header().Add("X-Auth-Groups", "billing")
+		writer.Header().Set("X-Auth-Internal", "secret")
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer auth.Close()
+
+	address, _ := url.Parse(auth.URL + "/verify")
+
+	var forwarded *http.Request
+	h := NewHandler(address, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forwarded = request
+		writer.WriteHeader(http.StatusOK)
+	}), WithRequestHeaders("Authorization", "X-Tenant"), WithResponseHeaders("X-Auth-User", "X-Auth-Groups"))
+
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		authRequest, forwarded = nil, nil
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		request := httptest.NewRequest("PUT", "https://app.example.com/api/data?q=1", nil)
+		request.Header.Set("Authorization", "valid")
+		request.Header.Set("X-Tenant", "acme")
+		request.Header.Set("Cookie", "session=1")
+		request.Header.Set("X-Auth-User", "admin")
+
+		recorder := serve(request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		if assert.NotNil(t, authRequest) {
+			assert.Equal(t, "PUT", authRequest.Method)
+			assert.Equal(t, "/verify", authRequest.URL.Path)
+			assert.Equal(t, "acme", authRequest.Header.Get("X-Tenant"))
+			assert.Empty(t, authRequest.Header.Get("Cookie"), "only the configured headers are sent")
+			assert.Equal(t, "PUT", authRequest.Header.Get("X-Forwarded-Method"))
+			assert.Equal(t, "/api/data?q=1", authRequest.Header.Get("X-Forwarded-Uri"))
+			assert.Equal(t, "app.example.com", authRequest.Header.Get("X-Forwarded-Host"))
+			assert.Equal(t, "https", authRequest.Header.Get("X-Forwarded-Proto"))
+		}
+
+		if assert.NotNil(t, forwarded) {
+			assert.Equal(t, []string{"alice"}, forwarded.Header.Values("X-Auth-User"), "the client value is replaced")
+			assert.Equal(t, []string{"admin", "billing"}, forwarded.Header.Values("X-Auth-Groups"))
+			assert.Empty(t, forwarded.Header.Get("X-Auth-Internal"))
+		}
+		assert.Equal(t, "admin", request.Header.Get("X-Auth-User"), "the incoming request is unchanged")
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/api/data", nil)
+		request.Header.Set("Authorization", "invalid")
+
+		recorder := serve(request)
+		assert.Nil(t, forwarded)
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		assert.Equal(t, "https://login.example.com/", recorder.Header().Get("Location"))
+		assert.Equal(t, "nobody", recorder.Header().Get("X-Auth-User"))
+		assert.Equal(t, "login required", recorder.Body.String())
+		assert.Equal(t, "http", authRequest.Header.Get("X-Forwarded-Proto"))
+	})
+}
+
+func TestHandler_Errors(t *testing.T) {
+	block := make(chan struct{})
+	auth := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-block
+	}))
+	defer auth.Close()
+	defer close(block)
+
+	origin := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("the request must not be forwarded")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		address, _ := url.Parse(auth.URL)
+		h := NewHandler(address, origin, WithTransport(&http.Transport{ResponseHeaderTimeout: 50 * time.Millisecond}))
+
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		address, _ := url.Parse(closed.URL)
+		h := NewHandler(address, origin)
+
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	})
+
+	t.Run("Invalid method", func(t *testing.T) {
+		address, _ := url.Parse(auth.URL)
+		h := NewHandler(address, origin)
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Method = "BAD METHOD"
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
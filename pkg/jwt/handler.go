@@ -0,0 +1,204 @@
+package jwt
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is a http.Handler that is used as a middleware
+// to authenticate the requests with a bearer JSON Web Token.
+//
+// Valid requests are forwarded to the origin handler, the
+// other ones are rejected with a 401 Unauthorized or a
+// 403 Forbidden status.
+type Handler struct {
+
+	// Validator checks the incoming tokens.
+	Validator *Validator
+
+	// Origin is the http handler that will have the authentication
+	// feature in front of it.
+	Origin http.Handler
+
+	// routes contains the claims required by path prefix.
+	routes []routeClaims
+
+	// claimHeaders maps a claim name to the request header used to
+	// forward its value to the origin.
+	claimHeaders map[string]string
+
+	// stripToken removes the "Authorization" header before forwarding
+	// the request.
+	stripToken bool
+}
+
+// routeClaims represents the claims required for a path prefix.
+type routeClaims struct {
+	prefix string
+	claims []string
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithRequiredClaims requires the given claims to be present in the
+// tokens of the requests whose path starts with the prefix.
+// A claim can be written "name=value" to also check its value. For
+// array claims, the value has to be one of the items.
+func WithRequiredClaims(prefix string, claims ...string) Option {
+	return func(h *Handler) {
+		h.routes = append(h.routes, routeClaims{prefix: prefix, claims: claims})
+
+		// Longest prefixes first, so the most specific route wins.
+		sort.SliceStable(h.routes, func(i, j int) bool {
+			return len(h.routes[i].prefix) > len(h.routes[j].prefix)
+		})
+	}
+}
+
+// WithClaimHeader forwards the claim value to the origin in the
+// given request header. Any client supplied value for this header
+// is removed.
+func WithClaimHeader(claim, header string) Option {
+	return func(h *Handler) {
+		h.claimHeaders[claim] = http.CanonicalHeaderKey(header)
+	}
+}
+
+// WithStripToken removes the raw token from the forwarded request.
+func WithStripToken() Option {
+	return func(h *Handler) {
+		h.stripToken = true
+	}
+}
+
+// NewHandler creates a JWT authentication middleware from a token
+// validator and a http.Handler.
+func NewHandler(v *Validator, o http.Handler, opts ...Option) *Handler {
+	h := &Handler{
+		Validator:    v,
+		Origin:       o,
+		claimHeaders: make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// ServeHTTP adds the authentication in front of the origin handler.
+//
+// The token is read from the "Authorization: Bearer" header. Its
+// signature and registered claims are validated, then the claims
+// required for the requested path are checked.
+//
+// More details can be found here: https://tools.ietf.org/html/rfc6750
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	token, ok := bearerToken(request)
+	if !ok {
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.Validator.Validate(token)
+	if err != nil {
+		logrus.WithContext(request.Context()).WithError(err).WithField("resource", request.URL.RequestURI()).Debug("Rejecting token")
+		writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, errorDescription(err)))
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if missing, ok := h.checkRequiredClaims(request, claims); !ok {
+		logrus.WithContext(request.Context()).WithField("claim", missing).WithField("resource", request.URL.RequestURI()).Debug("Missing required claim")
+		writer.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	outgoingRequest := request.Clone(request.Context())
+	for claim, header := range h.claimHeaders {
+		outgoingRequest.Header.Del(header)
+		if value, ok := claims.String(claim); ok {
+			outgoingRequest.Header.Set(header, value)
+		}
+	}
+
+	if h.stripToken {
+		outgoingRequest.Header.Del("Authorization")
+	}
+
+	h.Origin.ServeHTTP(writer, outgoingRequest)
+}
+
+// checkRequiredClaims checks the claims required by the most specific
+// route matching the request path. It returns the first missing claim.
+func (h *Handler) checkRequiredClaims(request *http.Request, claims Claims) (string, bool) {
+	for _, route := range h.routes {
+		if !strings.HasPrefix(request.URL.Path, route.prefix) {
+			continue
+		}
+
+		for _, required := range route.claims {
+			if !hasClaim(claims, required) {
+				return required, false
+			}
+		}
+
+		return "", true
+	}
+
+	return "", true
+}
+
+// hasClaim checks a "name" or "name=value" requirement.
+func hasClaim(claims Claims, required string) bool {
+	name, want := required, ""
+	if pos := strings.Index(required, "="); pos != -1 {
+		name, want = required[:pos], required[pos+1:]
+	}
+
+	v, ok := claims[name]
+	if !ok {
+		return false
+	}
+
+	if want == "" {
+		return true
+	}
+
+	if items, ok := v.([]interface{}); ok {
+		for _, item := range items {
+			if formatClaim(item) == want {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Space separated claims such as "scope".
+	for _, item := range strings.Fields(formatClaim(v)) {
+		if item == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bearerToken extracts the token from the "Authorization" header.
+func bearerToken(request *http.Request) (string, bool) {
+	auth := request.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(auth[7:])
+	return token, len(token) > 0
+}
@@ -0,0 +1,337 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrKeyNotFound is returned by a KeySet when no key matches
+// the requested key identifier and algorithm.
+var ErrKeyNotFound = errors.New("jwt: key not found")
+
+// KeySet is an interface that provides the verification keys
+// used to check the token signatures.
+type KeySet interface {
+
+	// Key returns the verification key matching the given key
+	// identifier and algorithm. The kid can be empty when the token
+	// header does not provide it.
+	// The returned key is a *rsa.PublicKey, an *ecdsa.PublicKey or a
+	// []byte for HMAC secrets.
+	Key(kid, alg string) (interface{}, error)
+}
+
+// jwk is the JSON representation of a single JSON Web Key.
+//
+// More details can be found here: https://tools.ietf.org/html/rfc7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA parameters.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC parameters.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	// Symmetric parameters.
+	K string `json:"k"`
+}
+
+// key is a parsed JSON Web Key.
+type key struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// StaticKeySet is a KeySet built from a fixed list of keys.
+type StaticKeySet struct {
+	keys []key
+}
+
+// NewStaticKeySet creates an empty StaticKeySet.
+func NewStaticKeySet() *StaticKeySet {
+	return &StaticKeySet{}
+}
+
+// Add registers a key for the given key identifier and algorithm.
+// The kid and alg can be empty to match any token header.
+func (s *StaticKeySet) Add(kid, alg string, k interface{}) *StaticKeySet {
+	s.keys = append(s.keys, key{kid: kid, alg: alg, key: k})
+	return s
+}
+
+// Key is the `KeySet` interface implementation.
+func (s *StaticKeySet) Key(kid, alg string) (interface{}, error) {
+	return findKey(s.keys, kid, alg)
+}
+
+// JWKS is a KeySet that loads its keys from a JSON Web Key Set
+// document, either from a local file or from an URL.
+//
+// The keys are cached and refreshed periodically. An unknown key
+// identifier also triggers a refresh, limited by the minimum
+// refresh interval to avoid flooding the key provider. The concurrent
+// refreshes are merged into a single fetch.
+type JWKS struct {
+
+	// source is the file path or the URL of the document.
+	source string
+
+	// client is the http client used for the remote documents.
+	client *http.Client
+
+	// refreshInterval is the duration after which the cached keys
+	// are considered outdated.
+	refreshInterval time.Duration
+
+	// minRefreshInterval is the minimum duration between two
+	// refreshes triggered by an unknown key identifier.
+	minRefreshInterval time.Duration
+
+	// refreshMu serializes the refreshes.
+	refreshMu sync.Mutex
+
+	mu        sync.RWMutex
+	keys      []key
+	fetchedAt time.Time
+}
+
+// NewJWKS creates a JWKS from a file path or an http(s) URL and
+// loads the keys for the first time.
+func NewJWKS(source string, refreshInterval time.Duration) (*JWKS, error) {
+	j := &JWKS{
+		source:             source,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: 10 * time.Second,
+	}
+
+	if err := j.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Key is the `KeySet` interface implementation.
+func (j *JWKS) Key(kid, alg string) (interface{}, error) {
+	j.mu.RLock()
+	keys, fetchedAt := j.keys, j.fetchedAt
+	j.mu.RUnlock()
+
+	age := time.Since(fetchedAt)
+	if j.refreshInterval > 0 && age > j.refreshInterval {
+		return findKey(j.refresh(fetchedAt), kid, alg)
+	}
+
+	k, err := findKey(keys, kid, alg)
+	if err == ErrKeyNotFound && age > j.minRefreshInterval {
+		// The key provider may have rotated its keys since the
+		// last fetch.
+		return findKey(j.refresh(fetchedAt), kid, alg)
+	}
+
+	return k, err
+}
+
+// Refresh reloads the keys from the source.
+func (j *JWKS) Refresh() error {
+	data, err := j.fetch()
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+
+	return nil
+}
+
+// refresh reloads the keys fetched at the given time and returns the
+// current ones. They are not reloaded again if a concurrent call did it
+// meanwhile. On failure, the previous keys are kept.
+func (j *JWKS) refresh(fetchedAt time.Time) []key {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	j.mu.RLock()
+	outdated := j.fetchedAt.Equal(fetchedAt)
+	j.mu.RUnlock()
+
+	if outdated {
+		if err := j.Refresh(); err != nil {
+			logrus.WithError(err).WithField("source", j.source).Error("Error while refreshing JWKS")
+
+			// Postpone the next attempt.
+			j.mu.Lock()
+			j.fetchedAt = time.Now()
+			j.mu.Unlock()
+		}
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys
+}
+
+// fetch reads the raw document from the source.
+func (j *JWKS) fetch() ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return ioutil.ReadFile(j.source)
+	}
+
+	response, err := j.client.Get(j.source)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwt: unexpected status %d while fetching %s", response.StatusCode, j.source)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// parseJWKS parses a JSON Web Key Set document. Keys with an unknown
+// type or a non signature usage are ignored.
+func parseJWKS(data []byte) ([]key, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("jwt: invalid JWKS document: %w", err)
+	}
+
+	keys := make([]key, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		parsed, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("jwt: invalid key %q: %w", k.Kid, err)
+		}
+
+		if parsed == nil {
+			continue
+		}
+
+		keys = append(keys, key{kid: k.Kid, alg: k.Alg, key: parsed})
+	}
+
+	return keys, nil
+}
+
+// parse converts the JSON Web Key into a usable crypto key.
+// It returns a nil key for unsupported key types.
+func (k *jwk) parse() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+
+	return nil, nil
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// findKey returns the first key that matches the key identifier and
+// the algorithm. Empty values act as wildcards.
+func findKey(keys []key, kid, alg string) (interface{}, error) {
+	for _, k := range keys {
+		if kid != "" && k.kid != "" && k.kid != kid {
+			continue
+		}
+
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+
+		if !keyMatchesAlg(k.key, alg) {
+			continue
+		}
+
+		return k.key, nil
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// keyMatchesAlg checks that the key type can be used with the
+// given algorithm.
+func keyMatchesAlg(k interface{}, alg string) bool {
+	switch k.(type) {
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		return alg == ES256
+	case []byte:
+		return alg == HS256
+	}
+
+	return false
+}
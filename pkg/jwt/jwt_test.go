@@ -0,0 +1,315 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func sign(t *testing.T, alg, kid string, k interface{}, claims map[string]interface{}) string {
+	input := encodeSegment(t, header{Alg: alg, Kid: kid, Typ: "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case RS256:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = s
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, k.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case HS256:
+		mac := hmac.New(sha256.New, k.([]byte))
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestValidator_Validate(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("my-secret")
+
+	keys := NewStaticKeySet().
+		Add("rsa", "", &rsaKey.PublicKey).
+		Add("ec", "", &ecKey.PublicKey).
+		Add("hmac", "", secret)
+
+	now := time.Unix(1600000000, 0)
+	v := &Validator{
+		Keys:      keys,
+		Issuer:    "https://issuer",
+		Audiences: []string{"proxy"},
+		now:       func() time.Time { return now },
+	}
+
+	valid := map[string]interface{}{
+		"iss": "https://issuer",
+		"aud": []string{"other", "proxy"},
+		"exp": now.Add(time.Minute).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		c := make(map[string]interface{}, len(valid))
+		for k, v := range valid {
+			c[k] = v
+		}
+		c[key] = value
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{{
+		name:  "Valid RS256 token",
+		token: sign(t, RS256, "rsa", rsaKey, valid),
+	}, {
+		name:  "Valid ES256 token",
+		token: sign(t, ES256, "ec", ecKey, valid),
+	}, {
+		name:  "Valid HS256 token",
+		token: sign(t, HS256, "hmac", secret, valid),
+	}, {
+		name:  "Invalid signature",
+		token: sign(t, HS256, "hmac", []byte("other"), valid),
+		want:  ErrInvalidSignature,
+	}, {
+		name:  "Unknown key",
+		token: sign(t, HS256, "unknown", secret, valid),
+		want:  ErrKeyNotFound,
+	}, {
+		name:  "Algorithm confusion",
+		token: sign(t, HS256, "rsa", secret, valid),
+		want:  ErrKeyNotFound,
+	}, {
+		name:  "Expired token",
+		token: sign(t, HS256, "hmac", secret, with("exp", now.Unix())),
+		want:  ErrExpired,
+	}, {
+		name:  "Not yet valid token",
+		token: sign(t, HS256, "hmac", secret, with("nbf", now.Add(time.Minute).Unix())),
+		want:  ErrNotYetValid,
+	}, {
+		name:  "Invalid issuer",
+		token: sign(t, HS256, "hmac", secret, with("iss", "https://other")),
+		want:  ErrInvalidIssuer,
+	}, {
+		name:  "Invalid audience",
+		token: sign(t, HS256, "hmac", secret, with("aud", "other")),
+		want:  ErrInvalidAudience,
+	}, {
+		name:  "Malformed token",
+		token: "not-a-token",
+		want:  ErrMalformed,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(tt.token)
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	doc := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "rsa",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}, {
+			"kty": "RSA",
+			"kid": "enc",
+			"use": "enc",
+		}},
+	}
+	data, _ := json.Marshal(doc)
+
+	t.Run("From file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "jwks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "jwks.json")
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := NewJWKS(path, time.Hour)
+		if assert.NoError(t, err) {
+			k, err := keys.Key("rsa", RS256)
+			assert.NoError(t, err)
+			assert.Equal(t, rsaKey.N, k.(*rsa.PublicKey).N)
+		}
+	})
+
+	t.Run("From URL", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			calls++
+			_, _ = writer.Write(data)
+		}))
+		defer server.Close()
+
+		keys, err := NewJWKS(server.URL, time.Hour)
+		if assert.NoError(t, err) {
+			_, err = keys.Key("rsa", RS256)
+			assert.NoError(t, err)
+			_, err = keys.Key("enc", RS256)
+			assert.Equal(t, ErrKeyNotFound, err)
+			// Keys are cached.
+			assert.Equal(t, 1, calls)
+		}
+	})
+
+	t.Run("Concurrent refreshes", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(20 * time.Millisecond)
+			_, _ = writer.Write(data)
+		}))
+		defer server.Close()
+
+		keys, err := NewJWKS(server.URL, time.Hour)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// The unknown key identifiers refresh the outdated keys once.
+		keys.fetchedAt = time.Now().Add(-time.Minute)
+		keys.minRefreshInterval = 30 * time.Second
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := keys.Key("rotated", RS256)
+				assert.Equal(t, ErrKeyNotFound, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+		// Then not before the minimum refresh interval.
+		_, err = keys.Key("rotated", RS256)
+		assert.Equal(t, ErrKeyNotFound, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestHandler(t *testing.T) {
+	secret := []byte("my-secret")
+	v := &Validator{Keys: NewStaticKeySet().Add("", HS256, secret)}
+
+	var forwarded *http.Request
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forwarded = request
+	})
+
+	h := NewHandler(v, origin,
+		WithRequiredClaims("/admin", "role=admin"),
+		WithRequiredClaims("/admin/public"),
+		WithClaimHeader("sub", "X-User"),
+		WithStripToken(),
+	)
+
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		forwarded = nil
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", path, nil)
+		request.Header.Set("X-User", "spoofed")
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	user := sign(t, HS256, "", secret, map[string]interface{}{"sub": "alice", "role": []string{"user"}})
+	admin := sign(t, HS256, "", secret, map[string]interface{}{"sub": "bob", "role": []string{"user", "admin"}})
+
+	t.Run("Missing token", func(t *testing.T) {
+		recorder := serve("/api", "")
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		recorder := serve("/api", user+"x")
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Valid token", func(t *testing.T) {
+		recorder := serve("/api", user)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		if assert.NotNil(t, forwarded) {
+			assert.Equal(t, "alice", forwarded.Header.Get("X-User"))
+			assert.Empty(t, forwarded.Header.Get("Authorization"))
+		}
+	})
+
+	t.Run("Missing required claim", func(t *testing.T) {
+		recorder := serve("/admin/users", user)
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("Required claim present", func(t *testing.T) {
+		recorder := serve("/admin/users", admin)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.NotNil(t, forwarded)
+	})
+
+	t.Run("Most specific route", func(t *testing.T) {
+		recorder := serve("/admin/public", user)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.NotNil(t, forwarded)
+	})
+}
@@ -0,0 +1,285 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Supported signature algorithms.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	HS256 = "HS256"
+)
+
+// Token validation errors.
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrUnsupportedAlg   = errors.New("jwt: unsupported algorithm")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token is expired")
+	ErrNotYetValid      = errors.New("jwt: token is not valid yet")
+	ErrInvalidIssuer    = errors.New("jwt: invalid issuer")
+	ErrInvalidAudience  = errors.New("jwt: invalid audience")
+)
+
+// Claims is the decoded payload of a token.
+type Claims map[string]interface{}
+
+// String returns the claim value formatted as a string. Numbers are
+// formatted without exponent and arrays are joined with a comma.
+// It returns false if the claim is not present.
+func (c Claims) String(name string) (string, bool) {
+	v, ok := c[name]
+	if !ok {
+		return "", false
+	}
+
+	return formatClaim(v), true
+}
+
+// time returns the numeric date claim as a time.Time.
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	f, err := v.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(f), 0), true
+}
+
+// audience returns the "aud" claim that can be either a string
+// or an array of strings.
+func (c Claims) audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var values []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+// header is the JOSE header of a token.
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Validator verifies the tokens signature and their registered
+// claims.
+type Validator struct {
+
+	// Keys provides the verification keys.
+	Keys KeySet
+
+	// Issuer is the expected "iss" claim. It is not checked when empty.
+	Issuer string
+
+	// Audiences contains the accepted "aud" claim values. At least one
+	// of them has to be present in the token. It is not checked when
+	// empty.
+	Audiences []string
+
+	// Leeway is the tolerated clock skew when checking the "exp" and
+	// "nbf" claims.
+	Leeway time.Duration
+
+	// now returns the current time. It helps for testing purpose.
+	now func() time.Time
+}
+
+// Validate parses the compact serialized token, verifies its
+// signature and checks the "exp", "nbf", "iss" and "aud" claims.
+func (v *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	k, err := v.Keys.Key(h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(h.Alg, k, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkClaims validates the registered claims.
+func (v *Validator) checkClaims(claims Claims) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+
+	if exp, ok := claims.time("exp"); ok && !now.Before(exp.Add(v.Leeway)) {
+		return ErrExpired
+	}
+
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+
+	if len(v.Audiences) > 0 && !containsAny(claims.audience(), v.Audiences) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+// verify checks the signature of the signing input with the given key.
+func verify(alg string, k interface{}, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case RS256:
+		pub, ok := k.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	case ES256:
+		pub, ok := k.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		if len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	case HS256:
+		secret, ok := k.([]byte)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	return ErrUnsupportedAlg
+}
+
+// decodeSegment decodes a base64url JSON segment of the token.
+// Numbers are kept as json.Number to avoid precision loss.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// formatClaim formats a decoded JSON value as a header friendly string.
+func formatClaim(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			values = append(values, formatClaim(item))
+		}
+		return strings.Join(values, ",")
+	case nil:
+		return ""
+	}
+
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// containsAny checks if at least one of the wanted values is
+// present in the values.
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// errorDescription returns a client friendly description of the
+// validation error.
+func errorDescription(err error) string {
+	return strings.TrimPrefix(fmt.Sprint(err), "jwt: ")
+}
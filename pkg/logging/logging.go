@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// The log levels.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Fields are the structured values of a log entry. The errors are
+// under the "error" key.
+type Fields map[string]interface{}
+
+// Logger writes the log entries of the handlers. The context is the
+// one of the request being handled, or context.Background().
+type Logger interface {
+	Log(ctx context.Context, level Level, msg string, fields Fields)
+}
+
+// LoggerFunc is a function implementing the Logger interface.
+type LoggerFunc func(ctx context.Context, level Level, msg string, fields Fields)
+
+// Log is the `Logger` interface implementation.
+func (f LoggerFunc) Log(ctx context.Context, level Level, msg string, fields Fields) {
+	f(ctx, level, msg, fields)
+}
+
+// Discard is a Logger dropping all the entries.
+var Discard Logger = LoggerFunc(func(context.Context, Level, string, Fields) {})
+
+// Logrus returns a Logger writing to a logrus logger, the entries
+// holding the context for the logrus hooks.
+func Logrus(l *logrus.Logger) Logger {
+	return logrusLogger{l}
+}
+
+// Default returns the Logger writing to the standard logrus logger,
+// used when none is given to the handlers.
+func Default() Logger {
+	return Logrus(logrus.StandardLogger())
+}
+
+type logrusLogger struct {
+	logger *logrus.Logger
+}
+
+// Log is the `Logger` interface implementation.
+func (l logrusLogger) Log(ctx context.Context, level Level, msg string, fields Fields) {
+	entry := l.logger.WithContext(ctx)
+	if len(fields) > 0 {
+		entry = entry.WithFields(logrus.Fields(fields))
+	}
+
+	switch level {
+	case LevelDebug:
+		entry.Debug(msg)
+	case LevelInfo:
+		entry.Info(msg)
+	case LevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
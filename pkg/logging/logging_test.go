@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogrus(t *testing.T) {
+	var out bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&out)
+	l.SetLevel(logrus.InfoLevel)
+	l.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+
+	logger := Logrus(l)
+	logger.Log(context.Background(), LevelDebug, "hidden", nil)
+	logger.Log(context.Background(), LevelWarn, "Slow upstream", Fields{"upstream": "10.0.0.1"})
+	logger.Log(context.Background(), LevelError, "Error while sending request", Fields{"error": errors.New("refused")})
+
+	assert.Equal(t, "level=warning msg=\"Slow upstream\" upstream=10.0.0.1\n"+
+		"level=error msg=\"Error while sending request\" error=refused\n", out.String())
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/certstore"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+)
+
+// Metrics are the metrics of the proxy and cache handlers. They are
+// created once, and shared by the handlers of all the routes.
+type Metrics struct {
+	registry *Registry
+
+	requests *Counter
+	duration *Histogram
+	inFlight *Gauge
+	lookups  *Counter
+}
+
+// New registers the proxy and cache metrics.
+func New(r *Registry) *Metrics {
+	return &Metrics{
+		registry: r,
+		requests: r.NewCounter("proxy_requests_total",
+			"Number of proxied requests.",
+			"route", "upstream", "method", "status_class"),
+		duration: r.NewHistogram("proxy_request_duration_seconds",
+			"Duration of the proxied requests, until the response is forwarded.",
+			nil, "route", "upstream", "method", "status_class"),
+		inFlight: r.NewGauge("proxy_requests_in_flight",
+			"Number of requests being proxied.",
+			"route", "method"),
+		lookups: r.NewCounter("cache_requests_total",
+			"Number of cache lookups by status (hit, miss, stale or bypass).",
+			"route", "status"),
+	}
+}
+
+// Registry returns the registry of the metrics.
+func (m *Metrics) Registry() *Registry {
+	return m.registry
+}
+
+// WatchStore registers the stored bytes and entries gauges of a cache
+// store.
+func (m *Metrics) WatchStore(store cache.Inspector) {
+	m.registry.NewGaugeFunc("cache_stored_bytes",
+		"Size of the cached response bodies.",
+		func() float64 { return float64(store.Stats().Bytes) })
+	m.registry.NewGaugeFunc("cache_entries",
+		"Number of cached responses.",
+		func() float64 { return float64(store.Stats().Entries) })
+}
+
+// WatchCertificates registers the expiry time gauge of the
+// certificates of the listeners, by listener name.
+func (m *Metrics) WatchCertificates(listers map[string]certstore.Lister) {
+	m.registry.NewGaugeVecFunc("tls_certificate_expiry_timestamp_seconds",
+		"Expiry time of the listener certificates, in seconds since the epoch.",
+		func(set func(float64, ...string)) {
+			for listener, lister := range listers {
+				for _, info := range lister.Certificates() {
+					set(float64(info.NotAfter.Unix()), listener, info.File, strings.Join(info.Names, ","))
+				}
+			}
+		}, "listener", "file", "names")
+}
+
+// Proxy returns the proxy metrics of a route.
+func (m *Metrics) Proxy(route string) proxy.Metrics {
+	return &proxyMetrics{metrics: m, route: route}
+}
+
+// Cache returns the cache metrics of a route.
+func (m *Metrics) Cache(route string) cache.Metrics {
+	return &cacheMetrics{metrics: m, route: route}
+}
+
+// proxyMetrics are the metrics of the proxy handler of a route.
+type proxyMetrics struct {
+	metrics *Metrics
+	route   string
+}
+
+// Begin is the `proxy.Metrics` interface implementation.
+func (p *proxyMetrics) Begin(request *http.Request) func(*proxy.Upstream, int) {
+	start := time.Now()
+	method := request.Method
+	p.metrics.inFlight.Add(1, p.route, method)
+
+	return func(upstream *proxy.Upstream, status int) {
+		p.metrics.inFlight.Add(-1, p.route, method)
+
+		var host string
+		if upstream != nil {
+			host = upstream.Addr()
+		}
+
+		labels := []string{p.route, host, method, StatusClass(status)}
+		p.metrics.requests.Inc(labels...)
+		p.metrics.duration.Observe(time.Since(start).Seconds(), labels...)
+	}
+}
+
+// cacheMetrics are the metrics of the cache handler of a route.
+type cacheMetrics struct {
+	metrics *Metrics
+	route   string
+}
+
+// Lookup is the `cache.Metrics` interface implementation.
+func (c *cacheMetrics) Lookup(status cache.LookupStatus) {
+	c.metrics.lookups.Inc(c.route, string(status))
+}
+
+// StatusClass returns the class of a status code (e.g. "2xx").
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
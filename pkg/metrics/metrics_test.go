@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("requests_total", "Number of requests.", "method", "path")
+	gauge := r.NewGauge("in_flight", "In-flight\nrequests.")
+	histogram := r.NewHistogram("duration_seconds", "Durations.", []float64{1, 0.5})
+	r.NewGaugeFunc("entries", "Entries.", func() float64 { return 42 })
+
+	counter.Inc("POST", "/b")
+	counter.Add(2, "GET", `/a"\`)
+	gauge.Set(3)
+	gauge.Add(-1)
+	histogram.Observe(0.5)
+	histogram.Observe(0.7)
+	histogram.Observe(3)
+
+	var b bytes.Buffer
+	n, err := r.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	assert.Equal(t, `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET",path="/a\"\\"} 2
+requests_total{method="POST",path="/b"} 1
+# HELP in_flight In-flight\nrequests.
+# TYPE in_flight gauge
+in_flight 2
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.5"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 4.2
+duration_seconds_count 3
+# HELP entries Entries.
+# TYPE entries gauge
+entries 42
+`, b.String())
+
+	assert.Panics(t, func() { r.NewGauge("entries", "Duplicated.") })
+	assert.Panics(t, func() { counter.Inc("GET") })
+}
+
+func TestRegistry_GaugeVecFunc(t *testing.T) {
+	r := NewRegistry()
+	names := []string{"b", "a"}
+	r.NewGaugeVecFunc("expiry", "Expiry.", func(set func(float64, ...string)) {
+		for i, name := range names {
+			set(float64(i), name)
+		}
+	}, "name")
+
+	var b bytes.Buffer
+	_, _ = r.WriteTo(&b)
+	assert.Equal(t, "# HELP expiry Expiry.\n# TYPE expiry gauge\nexpiry{name=\"a\"} 1\nexpiry{name=\"b\"} 0\n", b.String())
+
+	// The series that are not set anymore are dropped.
+	names = names[:1]
+	b.Reset()
+	_, _ = r.WriteTo(&b)
+	assert.Equal(t, "# HELP expiry Expiry.\n# TYPE expiry gauge\nexpiry{name=\"b\"} 0\n", b.String())
+}
+
+func TestMetrics_Proxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/missing" {
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	target, _ := url.Parse(origin.URL)
+	m := New(NewRegistry())
+	handler := proxy.New(target, proxy.WithMetrics(m.Proxy("api")))
+
+	assert.HTTPStatusCode(t, handler.ServeHTTP, "GET", "/found", nil, http.StatusOK)
+	assert.HTTPStatusCode(t, handler.ServeHTTP, "GET", "/found", nil, http.StatusOK)
+	assert.HTTPStatusCode(t, handler.ServeHTTP, "POST", "/missing", nil, http.StatusNotFound)
+
+	unavailable := proxy.NewBalanced(proxy.NewPool(), proxy.WithMetrics(m.Proxy("down")))
+	assert.HTTPStatusCode(t, unavailable.ServeHTTP, "GET", "/", nil, http.StatusServiceUnavailable)
+
+	output := scrape(t, m.Registry())
+	host := target.Host
+	assert.Contains(t, output, `proxy_requests_total{route="api",upstream="`+host+`",method="GET",status_class="2xx"} 2`)
+	assert.Contains(t, output, `proxy_requests_total{route="api",upstream="`+host+`",method="POST",status_class="4xx"} 1`)
+	assert.Contains(t, output, `proxy_requests_total{route="down",upstream="",method="GET",status_class="5xx"} 1`)
+	assert.Contains(t, output, `proxy_request_duration_seconds_count{route="api",upstream="`+host+`",method="GET",status_class="2xx"} 2`)
+	assert.Contains(t, output, `proxy_requests_in_flight{route="api",method="GET"} 0`)
+}
+
+func TestMetrics_Cache(t *testing.T) {
+	origin := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = writer.Write([]byte("content"))
+	})
+
+	store := cache.NewInMemoryCache()
+	m := New(NewRegistry())
+	m.WatchStore(store)
+	handler := cache.NewHandler(store, origin, cache.WithMetrics(m.Cache("static")))
+
+	assert.HTTPSuccess(t, handler.ServeHTTP, "GET", "/index.html", nil)
+	assert.HTTPSuccess(t, handler.ServeHTTP, "GET", "/index.html", nil)
+	assert.HTTPSuccess(t, handler.ServeHTTP, "POST", "/index.html", nil)
+
+	output := scrape(t, m.Registry())
+	assert.Contains(t, output, `cache_requests_total{route="static",status="bypass"} 1`)
+	assert.Contains(t, output, `cache_requests_total{route="static",status="hit"} 1`)
+	assert.Contains(t, output, `cache_requests_total{route="static",status="miss"} 1`)
+	assert.Contains(t, output, "cache_stored_bytes 7\n")
+	assert.Contains(t, output, "cache_entries 1\n")
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(204))
+	assert.Equal(t, "5xx", StatusClass(599))
+	assert.Equal(t, "unknown", StatusClass(0))
+}
+
+func scrape(t *testing.T, r *Registry) string {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	return strings.TrimSpace(rec.Body.String()) + "\n"
+}
@@ -0,0 +1,397 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the default upper bounds of the histograms, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric family of the registry.
+type collector interface {
+
+	// write writes the samples of the family in the text format.
+	write(w io.Writer)
+}
+
+// Registry is a set of metric families exposed in the Prometheus text
+// format.
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Registry)(nil)
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a family to the registry. It panics if the name is
+// already used, as for the other programming errors.
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicated metric %q", name))
+	}
+
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes all the metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, c := range collectors {
+		c.write(cw)
+	}
+
+	if err := cw.w.(*bufio.Writer).Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP is the `http.Handler` interface implementation. It
+// writes the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", ContentType)
+	if _, err := r.WriteTo(writer); err != nil {
+		logrus.WithError(err).Error("Error while writing metrics")
+	}
+}
+
+// family holds the labelled series of a metric.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a metric with its label values.
+type series struct {
+	values []string
+
+	// value is the counter or gauge value.
+	value float64
+
+	// counts, sum and count are the histogram state, counts being the
+	// non-cumulative bucket counts.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// newFamily creates a family without series.
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// with returns the series of the label values, which must be locked.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values.
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]*series, len(keys))
+	for i, key := range keys {
+		list[i] = f.series[key]
+	}
+	return list
+}
+
+// header writes the HELP and TYPE lines of the family.
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// Counter is a family of monotonic counters.
+type Counter struct {
+	*family
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// Add increases the counter of the label values.
+func (c *Counter) Add(delta float64, values ...string) {
+	c.mu.Lock()
+	c.with(values).value += delta
+	c.mu.Unlock()
+}
+
+// Inc increases the counter of the label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	for _, s := range c.sorted() {
+		writeSample(w, c.name, c.labels, s.values, "", "", s.value)
+	}
+}
+
+// Gauge is a family of values that go up and down.
+type Gauge struct {
+	*family
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// Add changes the gauge of the label values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.mu.Lock()
+	g.with(values).value += delta
+	g.mu.Unlock()
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(value float64, values ...string) {
+	g.mu.Lock()
+	g.with(values).value = value
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.header(w)
+	for _, s := range g.sorted() {
+		writeSample(w, g.name, g.labels, s.values, "", "", s.value)
+	}
+}
+
+// gaugeFunc is a gauge whose value is read on collection.
+type gaugeFunc struct {
+	*family
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is
+// returned by the function on each collection.
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	r.register(name, &gaugeFunc{family: newFamily(name, help, "gauge", nil), value: value})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w)
+	writeSample(w, g.name, nil, nil, "", "", g.value())
+}
+
+// gaugeVecFunc is a labelled gauge whose series are collected on
+// each collection.
+type gaugeVecFunc struct {
+	*family
+	collect func(set func(value float64, values ...string))
+}
+
+// NewGaugeVecFunc registers a gauge with the given label names whose
+// series are set by the function on each collection, the series it
+// does not set being dropped.
+func (r *Registry) NewGaugeVecFunc(name, help string, collect func(set func(value float64, values ...string)), labels ...string) {
+	r.register(name, &gaugeVecFunc{family: newFamily(name, help, "gauge", labels), collect: collect})
+}
+
+func (g *gaugeVecFunc) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.series = make(map[string]*series)
+	g.collect(func(value float64, values ...string) {
+		g.with(values).value = value
+	})
+
+	g.header(w)
+	for _, s := range g.sorted() {
+		writeSample(w, g.name, g.labels, s.values, "", "", s.value)
+	}
+}
+
+// Histogram is a family of observation distributions.
+type Histogram struct {
+	*family
+	buckets []float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper
+// bounds and label names. The default buckets are used when nil.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	h := &Histogram{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: append([]float64(nil), buckets...),
+	}
+	sort.Float64s(h.buckets)
+	r.register(name, h)
+	return h
+}
+
+// Observe adds an observation to the histogram of the label values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.with(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(s.count))
+	}
+}
+
+// writeSample writes a sample line, with an optional extra label.
+func writeSample(w io.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+
+	if len(labels) > 0 || len(extraLabel) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if len(extraLabel) > 0 {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extraLabel + `="` + escapeLabel(extraValue) + `"`)
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+
+	_, _ = io.WriteString(w, b.String())
+}
+
+// formatFloat formats a sample value.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// countingWriter counts the written bytes and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// Write is the `io.Writer` interface implementation.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAffinityCookie is the default name of the affinity cookie.
+const DefaultAffinityCookie = "proxy_affinity"
+
+// UpstreamLister is an interface that provides the list of the
+// upstreams a Selector can choose from.
+type UpstreamLister interface {
+
+	// Upstreams returns the known upstreams.
+	Upstreams() []*Upstream
+}
+
+// AffinityConfig configures the sticky sessions.
+type AffinityConfig struct {
+
+	// CookieName is the name of the affinity cookie.
+	CookieName string
+
+	// Secret is the key used to sign the cookie. A random key is
+	// generated when empty, so the sessions do not survive a restart.
+	Secret []byte
+
+	// TTL is the lifetime of the affinity. The cookie is a session
+	// cookie when zero.
+	TTL time.Duration
+
+	// Path is the cookie path attribute. Defaults to "/".
+	Path string
+
+	// Domain is the cookie domain attribute.
+	Domain string
+
+	// Secure is the cookie secure attribute.
+	Secure bool
+
+	// HTTPOnly is the cookie httponly attribute.
+	HTTPOnly bool
+
+	// SameSite is the cookie samesite attribute.
+	SameSite http.SameSite
+}
+
+// Sticky is a Selector that pins the clients to an upstream with a
+// signed cookie naming the chosen upstream.
+//
+// When the pinned upstream is unknown, unhealthy or drained, the request is
+// transparently forwarded to another upstream and the cookie is
+// updated.
+type Sticky struct {
+
+	// Selector chooses the upstream of the new sessions. It has to be
+	// an UpstreamLister so the pinned upstreams can be found again.
+	Selector Selector
+
+	config AffinityConfig
+}
+
+// Static implementation checker.
+var _ Selector = (*Sticky)(nil)
+
+// NewSticky creates a sticky session selector in front of the given
+// selector.
+func NewSticky(selector Selector, config AffinityConfig) *Sticky {
+	if len(config.CookieName) == 0 {
+		config.CookieName = DefaultAffinityCookie
+	}
+
+	if len(config.Path) == 0 {
+		config.Path = "/"
+	}
+
+	if len(config.Secret) == 0 {
+		config.Secret = make([]byte, 32)
+		_, _ = rand.Read(config.Secret)
+	}
+
+	return &Sticky{Selector: selector, config: config}
+}
+
+// Upstreams is the `UpstreamLister` interface implementation.
+func (s *Sticky) Upstreams() []*Upstream {
+	if lister, ok := s.Selector.(UpstreamLister); ok {
+		return lister.Upstreams()
+	}
+
+	return nil
+}
+
+// Select is the `Selector` interface implementation.
+func (s *Sticky) Select(writer http.ResponseWriter, request *http.Request) (*Upstream, error) {
+	if u := s.pinned(request); u != nil {
+		return u, nil
+	}
+
+	u, err := s.Selector.Select(writer, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if writer != nil {
+		http.SetCookie(writer, s.cookie(u))
+	}
+
+	return u, nil
+}
+
+// pinned returns the available upstream named by the request cookie,
+// or nil.
+func (s *Sticky) pinned(request *http.Request) *Upstream {
+	c, err := request.Cookie(s.config.CookieName)
+	if err != nil {
+		return nil
+	}
+
+	id, ok := s.verify(c.Value)
+	if !ok {
+		return nil
+	}
+
+	for _, u := range s.Upstreams() {
+		if upstreamID(u) == id && u.available() {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// cookie creates the affinity cookie for the upstream.
+func (s *Sticky) cookie(u *Upstream) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.config.CookieName,
+		Path:     s.config.Path,
+		Domain:   s.config.Domain,
+		Secure:   s.config.Secure,
+		HttpOnly: s.config.HTTPOnly,
+		SameSite: s.config.SameSite,
+	}
+
+	var expires int64
+	if s.config.TTL > 0 {
+		c.MaxAge = int(s.config.TTL.Seconds())
+		expires = time.Now().Add(s.config.TTL).Unix()
+	}
+
+	c.Value = s.sign(upstreamID(u) + "." + strconv.FormatInt(expires, 10))
+	return c
+}
+
+// sign appends the signature to the payload.
+func (s *Sticky) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.config.Secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the cookie signature and expiration, and returns the
+// upstream identifier.
+func (s *Sticky) verify(value string) (string, bool) {
+	pos := strings.LastIndex(value, ".")
+	if pos == -1 {
+		return "", false
+	}
+
+	payload := value[:pos]
+	if subtle.ConstantTimeCompare([]byte(s.sign(payload)), []byte(value)) != 1 {
+		return "", false
+	}
+
+	parts := strings.SplitN(payload, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || (expires > 0 && time.Now().Unix() > expires) {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+// upstreamID returns an opaque identifier of the upstream, so the
+// cookie does not disclose the internal addresses.
+func upstreamID(u *Upstream) string {
+	sum := sha256.Sum256([]byte(u.URL.String()))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSticky_Select(t *testing.T) {
+	a, b := NewUpstream(&url.URL{Host: "a"}), NewUpstream(&url.URL{Host: "b"})
+	s := NewSticky(NewPool(a, b), AffinityConfig{
+		CookieName: "affinity",
+		Secret:     []byte("secret"),
+		TTL:        time.Hour,
+		Secure:     true,
+		HTTPOnly:   true,
+	})
+
+	// first selects an upstream and returns the affinity cookie.
+	first := func() (*Upstream, *http.Cookie) {
+		recorder := httptest.NewRecorder()
+		u, err := s.Select(recorder, httptest.NewRequest("GET", "/", nil))
+		assert.NoError(t, err)
+		cookies := recorder.Result().Cookies()
+		if !assert.Len(t, cookies, 1) {
+			t.FailNow()
+		}
+		return u, cookies[0]
+	}
+
+	next := func(c *http.Cookie) (*Upstream, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(c)
+		u, err := s.Select(recorder, req)
+		assert.NoError(t, err)
+		return u, recorder
+	}
+
+	t.Run("Cookie attributes", func(t *testing.T) {
+		_, c := first()
+		assert.Equal(t, "affinity", c.Name)
+		assert.Equal(t, 3600, c.MaxAge)
+		assert.True(t, c.Secure)
+		assert.True(t, c.HttpOnly)
+	})
+
+	t.Run("Pinned upstream", func(t *testing.T) {
+		pinned, c := first()
+		for i := 0; i < 4; i++ {
+			u, recorder := next(c)
+			assert.Equal(t, pinned, u)
+			assert.Empty(t, recorder.Result().Cookies())
+		}
+	})
+
+	t.Run("Tampered cookie", func(t *testing.T) {
+		forged := s.cookie(a)
+		forged.Value = forged.Value[:len(forged.Value)-1] + "x"
+		_, recorder := next(forged)
+		assert.Len(t, recorder.Result().Cookies(), 1)
+	})
+
+	t.Run("Unhealthy pinned upstream", func(t *testing.T) {
+		pinned, c := first()
+		pinned.SetHealthy(false)
+		defer pinned.SetHealthy(true)
+
+		u, recorder := next(c)
+		assert.NotEqual(t, pinned, u)
+		cookies := recorder.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			// The new cookie pins the fallback upstream.
+			pinned.SetHealthy(true)
+			again, _ := next(cookies[0])
+			assert.Equal(t, u, again)
+		}
+	})
+
+	t.Run("Expired cookie", func(t *testing.T) {
+		c := &http.Cookie{Name: "affinity", Value: s.sign(upstreamID(a) + ".1")}
+		_, recorder := next(c)
+		assert.Len(t, recorder.Result().Cookies(), 1)
+	})
+}
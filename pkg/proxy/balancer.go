@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+// Balancer is an interface that chooses an upstream among the
+// members of a pool.
+type Balancer interface {
+
+	// Update is called with the pool members each time they change.
+	Update(upstreams []*Upstream)
+
+	// Pick returns the upstream that handles the request. It returns
+	// nil if no upstream is available.
+	Pick(request *http.Request) *Upstream
+}
+
+// RoundRobin is a Balancer that chooses the available upstreams
+// one after the other. An upstream is chosen as many times in a row
+// as its weight.
+type RoundRobin struct {
+	slots atomic.Value
+	next  uint32
+}
+
+// weightedSlots are the upstreams with the cumulative weights, used
+// to map a counter to an upstream.
+type weightedSlots struct {
+	upstreams []*Upstream
+	bounds    []uint32
+}
+
+// Static implementation checker.
+var _ Balancer = (*RoundRobin)(nil)
+
+// NewRoundRobin creates a round robin balancer.
+func NewRoundRobin() *RoundRobin {
+	r := &RoundRobin{}
+	r.slots.Store(&weightedSlots{})
+	return r
+}
+
+// Update is the `Balancer` interface implementation.
+func (r *RoundRobin) Update(upstreams []*Upstream) {
+	slots := &weightedSlots{
+		upstreams: append([]*Upstream(nil), upstreams...),
+		bounds:    make([]uint32, len(upstreams)),
+	}
+
+	var total uint32
+	for i, u := range upstreams {
+		total += uint32(u.weight())
+		slots.bounds[i] = total
+	}
+
+	r.slots.Store(slots)
+}
+
+// Pick is the `Balancer` interface implementation.
+func (r *RoundRobin) Pick(_ *http.Request) *Upstream {
+	slots := r.slots.Load().(*weightedSlots)
+
+	n := len(slots.upstreams)
+	if n == 0 {
+		return nil
+	}
+
+	total := slots.bounds[n-1]
+	slot := atomic.AddUint32(&r.next, 1) % total
+	start := sort.Search(n, func(i int) bool {
+		return slots.bounds[i] > slot
+	})
+
+	for i := 0; i < n; i++ {
+		if u := slots.upstreams[(start+i)%n]; u.available() {
+			return u
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRobin_Pick(t *testing.T) {
+	upstreams := newTestUpstreams(3)
+	upstreams[0].Weight = 2
+	upstreams[2].SetHealthy(false)
+
+	r := NewRoundRobin()
+	r.Update(upstreams)
+
+	counts := make(map[*Upstream]int)
+	for i := 0; i < 300; i++ {
+		counts[r.Pick(httptest.NewRequest("GET", "/", nil))]++
+	}
+
+	// The share of the unhealthy upstream goes to the next one.
+	assert.Equal(t, 225, counts[upstreams[0]])
+	assert.Equal(t, 75, counts[upstreams[1]])
+	assert.Zero(t, counts[upstreams[2]])
+
+	assert.Nil(t, NewRoundRobin().Pick(httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestRoundRobin_Drained(t *testing.T) {
+	upstreams := newTestUpstreams(2)
+	upstreams[0].SetDrained(true)
+
+	r := NewRoundRobin()
+	r.Update(upstreams)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, upstreams[1], r.Pick(httptest.NewRequest("GET", "/", nil)))
+	}
+
+	upstreams[1].SetDrained(true)
+	assert.Nil(t, r.Pick(httptest.NewRequest("GET", "/", nil)))
+
+	upstreams[0].SetDrained(false)
+	assert.Equal(t, upstreams[0], r.Pick(httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestPool_FailTimeout(t *testing.T) {
+	upstreams := newTestUpstreams(2)
+	NewPool(upstreams[0]).WithFailTimeout(0, 50*time.Millisecond)
+
+	upstreams[0].fail()
+	upstreams[1].fail()
+	assert.False(t, upstreams[0].Healthy())
+	assert.True(t, upstreams[1].Healthy(), "the failures are ignored without fail timeout")
+
+	copied := newTestUpstreams(1)[0]
+	copied.CopyState(upstreams[0])
+	assert.False(t, copied.Healthy())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, upstreams[0].Healthy())
+	assert.True(t, copied.Healthy())
+}
+
+func TestPool_Breaker(t *testing.T) {
+	upstreams := newTestUpstreams(2)
+	pool := NewPool(upstreams...).WithFailTimeout(2, 50*time.Millisecond)
+	pick := func() *Upstream {
+		u, err := pool.Select(nil, httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+
+	// The breaker opens after two consecutive failures.
+	upstreams[0].fail()
+	assert.Equal(t, BreakerClosed, upstreams[0].Breaker())
+	upstreams[0].succeed()
+	upstreams[0].fail()
+	assert.Equal(t, BreakerClosed, upstreams[0].Breaker(), "a success resets the failures")
+	upstreams[0].fail()
+	assert.Equal(t, BreakerOpen, upstreams[0].Breaker())
+	assert.False(t, upstreams[0].Healthy())
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, upstreams[1], pick())
+	}
+
+	// A single trial request is sent once the breaker is half-open, and
+	// its failure opens the breaker again.
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, upstreams[0].Breaker())
+	assert.True(t, upstreams[0].Healthy())
+	picked := []*Upstream{pick(), pick(), pick(), pick()}
+	assert.ElementsMatch(t, []*Upstream{upstreams[0], upstreams[1], upstreams[1], upstreams[1]}, picked)
+	upstreams[0].fail()
+	assert.Equal(t, BreakerOpen, upstreams[0].Breaker())
+
+	// A successful trial closes it.
+	time.Sleep(60 * time.Millisecond)
+	upstreams[1].SetDrained(true)
+	assert.Equal(t, upstreams[0], pick())
+	assert.Nil(t, pick(), "the trial request is in flight")
+	upstreams[0].succeed()
+	assert.Equal(t, BreakerClosed, upstreams[0].Breaker())
+	assert.Equal(t, upstreams[0], pick())
+	assert.Equal(t, upstreams[0], pick())
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of an upstream.
+type BreakerState int
+
+const (
+	// BreakerClosed lets the requests go through.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen takes the upstream out of the rotation until its
+	// fail timeout elapses.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single trial request go through: the
+	// breaker closes when it succeeds, and opens again when it fails.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is the circuit breaker of an upstream, opened by the requests
+// the upstream could not answer.
+type breaker struct {
+	mu sync.Mutex
+
+	// maxFails is the number of consecutive failures opening the
+	// breaker. Zero means 1.
+	maxFails int
+
+	// timeout is how long the breaker stays open. The failures are
+	// ignored when zero.
+	timeout time.Duration
+
+	state    BreakerState
+	failures int
+
+	// since is when the breaker opened, or when the trial request of
+	// the half-open breaker was let through.
+	since time.Time
+
+	// trial is set while the trial request of the half-open breaker is
+	// in flight.
+	trial bool
+}
+
+// configure sets the failure threshold and the open duration.
+func (b *breaker) configure(maxFails int, timeout time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.maxFails, b.timeout = maxFails, timeout
+}
+
+// current returns the state, the breaker being half-open once its
+// timeout elapsed. The caller must hold the lock.
+func (b *breaker) current() BreakerState {
+	if b.state == BreakerOpen && time.Since(b.since) >= b.timeout {
+		b.state, b.trial = BreakerHalfOpen, false
+	}
+	return b.state
+}
+
+// State returns the state of the breaker.
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current()
+}
+
+// allow checks if a request can be sent, claiming the trial request of
+// the half-open breaker. An unanswered trial, e.g. a request that was
+// selected but never sent, is given up after the timeout.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.current() {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if b.trial && time.Since(b.since) < b.timeout {
+			return false
+		}
+		b.trial, b.since = true, time.Now()
+		return true
+	default:
+		return false
+	}
+}
+
+// success records an answered request, closing the breaker.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state, b.failures, b.trial = BreakerClosed, 0, false
+}
+
+// failure records a request the upstream could not answer, opening the
+// breaker after maxFails consecutive ones or a failed trial.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.timeout <= 0 {
+		return
+	}
+
+	b.failures++
+	if b.current() == BreakerHalfOpen || b.failures >= b.maxFails {
+		b.state, b.since = BreakerOpen, time.Now()
+		b.failures, b.trial = 0, false
+	}
+}
+
+// copyState copies the state and settings of another breaker.
+func (b *breaker) copyState(from *breaker) {
+	from.mu.Lock()
+	maxFails, timeout := from.maxFails, from.timeout
+	state, failures, since := from.state, from.failures, from.since
+	from.mu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxFails, b.timeout = maxFails, timeout
+	b.state, b.failures, b.since, b.trial = state, failures, since, false
+}
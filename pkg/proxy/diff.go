@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+)
+
+// CompareConfig configures the comparison between the primary and
+// the shadow responses.
+type CompareConfig struct {
+
+	// Headers is the list of the response headers that are compared.
+	Headers []string
+
+	// IgnorePaths is the list of the JSON body paths ignored by the
+	// comparison. A path is a dot separated list of object keys and
+	// array indexes, "*" matches any key or index
+	// (e.g. "meta.timestamp" or "items.*.id").
+	IgnorePaths []string
+
+	// Routes is the list of the path prefixes used to aggregate the
+	// comparison counters. The requests are counted in the most
+	// specific matching route, or in the "/" route.
+	Routes []string
+
+	// MaxBodySize is the maximum body size that is compared. Larger
+	// bodies are only compared on their first bytes.
+	MaxBodySize int64
+}
+
+// MirrorStats contains the aggregate comparison counters of a route.
+type MirrorStats struct {
+
+	// Matches is the number of identical responses.
+	Matches uint64 `json:"matches"`
+
+	// Mismatches is the number of different responses.
+	Mismatches uint64 `json:"mismatches"`
+
+	// Errors is the number of comparisons that could not be done
+	// because one of the requests failed.
+	Errors uint64 `json:"errors"`
+}
+
+// capturedResponse is the part of a response that is compared.
+type capturedResponse struct {
+	status  int
+	headers http.Header
+	body    []byte
+}
+
+// comparator compares the primary and shadow responses and keeps
+// the counters by route.
+type comparator struct {
+	config CompareConfig
+	routes []string
+
+	// logger is the one of the proxy once it is created.
+	logger logging.Logger
+
+	mu    sync.Mutex
+	stats map[string]*MirrorStats
+}
+
+// newComparator creates a comparator from its configuration.
+func newComparator(config CompareConfig) *comparator {
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+
+	routes := append([]string{"/"}, config.Routes...)
+
+	// Longest prefixes first, so the most specific route wins.
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i]) > len(routes[j])
+	})
+
+	return &comparator{
+		config: config,
+		routes: routes,
+		logger: logging.Default(),
+		stats:  make(map[string]*MirrorStats),
+	}
+}
+
+// route returns the route used to count the given request.
+func (c *comparator) route(request *http.Request) string {
+	for _, route := range c.routes {
+		if strings.HasPrefix(request.URL.Path, route) {
+			return route
+		}
+	}
+
+	return "/"
+}
+
+// capture reads the compared part of a response. The body is read up
+// to the maximum body size.
+func (c *comparator) capture(response *http.Response) *capturedResponse {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, c.config.MaxBodySize))
+	return &capturedResponse{
+		status:  response.StatusCode,
+		headers: response.Header,
+		body:    body,
+	}
+}
+
+// compare compares the responses, logs the mismatches and updates
+// the route counters. A nil response means that its request failed.
+func (c *comparator) compare(request *http.Request, primary, shadow *capturedResponse) {
+	route := c.route(request)
+
+	c.mu.Lock()
+	stats, ok := c.stats[route]
+	if !ok {
+		stats = &MirrorStats{}
+		c.stats[route] = stats
+	}
+
+	if primary == nil || shadow == nil {
+		stats.Errors++
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	differences := c.differences(primary, shadow)
+
+	c.mu.Lock()
+	if len(differences) == 0 {
+		stats.Matches++
+	} else {
+		stats.Mismatches++
+	}
+	c.mu.Unlock()
+
+	if len(differences) > 0 {
+		c.logger.Log(request.Context(), logging.LevelWarn, "Shadow response mismatch", logging.Fields{
+			"route":          route,
+			"method":         request.Method,
+			"resource":       request.URL.RequestURI(),
+			"primary-status": primary.status,
+			"shadow-status":  shadow.status,
+			"differences":    differences,
+		})
+	}
+}
+
+// Stats returns a copy of the counters by route.
+func (c *comparator) Stats() map[string]MirrorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]MirrorStats, len(c.stats))
+	for route, s := range c.stats {
+		stats[route] = *s
+	}
+
+	return stats
+}
+
+// differences lists the differences between two responses.
+func (c *comparator) differences(primary, shadow *capturedResponse) []string {
+	var differences []string
+
+	if primary.status != shadow.status {
+		differences = append(differences, fmt.Sprintf("status: %d != %d", primary.status, shadow.status))
+	}
+
+	for _, header := range c.config.Headers {
+		p, s := strings.Join(primary.headers.Values(header), ","), strings.Join(shadow.headers.Values(header), ",")
+		if p != s {
+			differences = append(differences, fmt.Sprintf("header %s: %q != %q", http.CanonicalHeaderKey(header), p, s))
+		}
+	}
+
+	var p, s interface{}
+	if json.Unmarshal(primary.body, &p) == nil && json.Unmarshal(shadow.body, &s) == nil {
+		for _, path := range c.config.IgnorePaths {
+			parts := strings.Split(path, ".")
+			p = removePath(p, parts)
+			s = removePath(s, parts)
+		}
+
+		return append(differences, jsonDifferences("body", p, s)...)
+	}
+
+	if !bytes.Equal(primary.body, shadow.body) {
+		differences = append(differences, fmt.Sprintf("body: %d bytes != %d bytes", len(primary.body), len(shadow.body)))
+	}
+
+	return differences
+}
+
+// removePath removes the value located at the path from the decoded
+// JSON value.
+func removePath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return v
+	}
+
+	key, rest := path[0], path[1:]
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if key != "*" && key != k {
+				continue
+			}
+
+			if len(rest) == 0 {
+				delete(value, k)
+			} else {
+				value[k] = removePath(item, rest)
+			}
+		}
+
+	case []interface{}:
+		for i, item := range value {
+			if key != "*" && key != fmt.Sprint(i) {
+				continue
+			}
+
+			if len(rest) == 0 {
+				// Keeps the indexes stable.
+				value[i] = nil
+			} else {
+				value[i] = removePath(item, rest)
+			}
+		}
+	}
+
+	return v
+}
+
+// jsonDifferences lists the paths where the decoded JSON values differ.
+func jsonDifferences(path string, p, s interface{}) []string {
+	pm, pok := p.(map[string]interface{})
+	sm, sok := s.(map[string]interface{})
+	if pok && sok {
+		keys := make(map[string]bool)
+		for k := range pm {
+			keys[k] = true
+		}
+		for k := range sm {
+			keys[k] = true
+		}
+
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		var differences []string
+		for _, k := range sorted {
+			differences = append(differences, jsonDifferences(path+"."+k, pm[k], sm[k])...)
+		}
+		return differences
+	}
+
+	pa, pok := p.([]interface{})
+	sa, sok := s.([]interface{})
+	if pok && sok && len(pa) == len(sa) {
+		var differences []string
+		for i := range pa {
+			differences = append(differences, jsonDifferences(fmt.Sprintf("%s.%d", path, i), pa[i], sa[i])...)
+		}
+		return differences
+	}
+
+	if !reflect.DeepEqual(p, s) {
+		return []string{path}
+	}
+
+	return nil
+}
+
+// exchange synchronizes the primary and the shadow responses of a
+// mirrored request.
+type exchange struct {
+	primary chan *capturedResponse
+}
+
+// newExchange creates an exchange.
+func newExchange() *exchange {
+	return &exchange{primary: make(chan *capturedResponse, 1)}
+}
+
+// wait returns the primary response, or nil if it is not available
+// before the timeout.
+func (e *exchange) wait(timeout time.Duration) *capturedResponse {
+	select {
+	case r := <-e.primary:
+		return r
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// teeBody captures the body while it is forwarded to the client.
+type teeBody struct {
+	io.ReadCloser
+
+	buf   bytes.Buffer
+	limit int64
+}
+
+// Read is the "io.Reader" interface implementation.
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if remaining := t.limit - int64(t.buf.Len()); remaining > 0 {
+		if int64(n) < remaining {
+			remaining = int64(n)
+		}
+		t.buf.Write(p[:remaining])
+	}
+
+	return n, err
+}
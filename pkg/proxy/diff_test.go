@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_comparator_differences(t *testing.T) {
+	c := newComparator(CompareConfig{
+		Headers:     []string{"Content-Type"},
+		IgnorePaths: []string{"meta.timestamp", "items.*.id"},
+	})
+
+	response := func(status int, contentType, body string) *capturedResponse {
+		return &capturedResponse{
+			status:  status,
+			headers: http.Header{"Content-Type": []string{contentType}},
+			body:    []byte(body),
+		}
+	}
+
+	tests := []struct {
+		name            string
+		primary, shadow *capturedResponse
+		want            []string
+	}{{
+		name:    "Identical responses",
+		primary: response(200, "text/plain", "content"),
+		shadow:  response(200, "text/plain", "content"),
+	}, {
+		name:    "Different status",
+		primary: response(200, "text/plain", "content"),
+		shadow:  response(500, "text/plain", "content"),
+		want:    []string{"status: 200 != 500"},
+	}, {
+		name:    "Different header",
+		primary: response(200, "text/plain", "content"),
+		shadow:  response(200, "text/html", "content"),
+		want:    []string{`header Content-Type: "text/plain" != "text/html"`},
+	}, {
+		name:    "Different raw body",
+		primary: response(200, "text/plain", "content"),
+		shadow:  response(200, "text/plain", "other content"),
+		want:    []string{"body: 7 bytes != 13 bytes"},
+	}, {
+		name:    "Equivalent JSON bodies",
+		primary: response(200, "application/json", `{"a": 1, "b": [1, 2]}`),
+		shadow:  response(200, "application/json", `{"b":[1,2],"a":1}`),
+	}, {
+		name:    "Different JSON bodies",
+		primary: response(200, "application/json", `{"a": 1, "b": [1, 2], "c": "x"}`),
+		shadow:  response(200, "application/json", `{"a": 2, "b": [1, 3]}`),
+		want:    []string{"body.a", "body.b.1", "body.c"},
+	}, {
+		name:    "Ignored JSON paths",
+		primary: response(200, "application/json", `{"meta": {"timestamp": 1, "v": 1}, "items": [{"id": 1, "n": "a"}]}`),
+		shadow:  response(200, "application/json", `{"meta": {"timestamp": 2, "v": 1}, "items": [{"id": 2, "n": "a"}]}`),
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.differences(tt.primary, tt.shadow))
+		})
+	}
+}
+
+func Test_comparator_compare(t *testing.T) {
+	c := newComparator(CompareConfig{Routes: []string{"/api", "/api/users"}})
+	ok := &capturedResponse{status: 200}
+	ko := &capturedResponse{status: 500}
+
+	c.compare(httptest.NewRequest("GET", "/api/users/1", nil), ok, ok)
+	c.compare(httptest.NewRequest("GET", "/api/users/2", nil), ok, ko)
+	c.compare(httptest.NewRequest("GET", "/api/data", nil), ok, ok)
+	c.compare(httptest.NewRequest("GET", "/other", nil), ok, nil)
+
+	assert.Equal(t, map[string]MirrorStats{
+		"/api/users": {Matches: 1, Mismatches: 1},
+		"/api":       {Matches: 1},
+		"/":          {Errors: 1},
+	}, c.Stats())
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultVirtualNodes is the default number of points of each
+// upstream on the hash ring.
+const DefaultVirtualNodes = 160
+
+// HashKey extracts the key used by the consistent hashing from the
+// requests.
+type HashKey func(request *http.Request) string
+
+// HashByPath uses the request path as hash key.
+func HashByPath() HashKey {
+	return func(request *http.Request) string {
+		return request.URL.Path
+	}
+}
+
+// HashByHeader uses the value of a request header as hash key.
+func HashByHeader(name string) HashKey {
+	return func(request *http.Request) string {
+		return request.Header.Get(name)
+	}
+}
+
+// HashByCookie uses the value of a request cookie as hash key.
+func HashByCookie(name string) HashKey {
+	return func(request *http.Request) string {
+		c, err := request.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// HashByQuery uses the value of a query parameter as hash key.
+func HashByQuery(name string) HashKey {
+	return func(request *http.Request) string {
+		return request.URL.Query().Get(name)
+	}
+}
+
+// HashByClientIP uses the client IP address as hash key.
+func HashByClientIP() HashKey {
+	return clientIP
+}
+
+// ParseHashKey creates a HashKey from its textual representation:
+// "path", "ip", "header:<name>", "cookie:<name>" or "query:<name>".
+func ParseHashKey(value string) (HashKey, error) {
+	switch value {
+	case "path":
+		return HashByPath(), nil
+	case "ip":
+		return HashByClientIP(), nil
+	}
+
+	for prefix, key := range map[string]func(string) HashKey{
+		"header:": HashByHeader,
+		"cookie:": HashByCookie,
+		"query:":  HashByQuery,
+	} {
+		if name := strings.TrimPrefix(value, prefix); len(name) > 0 && name != value {
+			return key(name), nil
+		}
+	}
+
+	return nil, fmt.Errorf("proxy: invalid hash key %q", value)
+}
+
+// clientIP returns the IP address of the client connection.
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// ConsistentHash is a Balancer that sends the requests with the
+// same key to the same upstream.
+//
+// The upstreams are placed on a hash ring with several virtual
+// nodes each, so a membership change only remaps the keys of the
+// added or removed upstream. The number of virtual nodes of an
+// upstream is proportional to its weight. When the chosen upstream is
+// unhealthy or drained, the next one on the ring is used.
+type ConsistentHash struct {
+	key          HashKey
+	virtualNodes int
+
+	ring atomic.Value
+}
+
+// ring is an immutable hash ring.
+type ring struct {
+	points    []uint64
+	upstreams []*Upstream
+}
+
+// Static implementation checker.
+var _ Balancer = (*ConsistentHash)(nil)
+
+// NewConsistentHash creates a consistent hashing balancer from the
+// request key to use. The requests without key are hashed with the
+// client IP address.
+func NewConsistentHash(key HashKey, virtualNodes int) *ConsistentHash {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	c := &ConsistentHash{key: key, virtualNodes: virtualNodes}
+	c.ring.Store(&ring{})
+	return c
+}
+
+// Update is the `Balancer` interface implementation.
+func (c *ConsistentHash) Update(upstreams []*Upstream) {
+	type node struct {
+		point    uint64
+		upstream *Upstream
+	}
+
+	// The weights are scaled so an average upstream gets virtualNodes
+	// points.
+	var total int
+	for _, u := range upstreams {
+		total += u.weight()
+	}
+
+	var nodes []node
+	for _, u := range upstreams {
+		count := c.virtualNodes * u.weight() * len(upstreams) / total
+		if count < 1 {
+			count = 1
+		}
+
+		id := u.URL.String()
+		for i := 0; i < count; i++ {
+			nodes = append(nodes, node{point: hash(id + "#" + strconv.Itoa(i)), upstream: u})
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].point < nodes[j].point
+	})
+
+	r := &ring{
+		points:    make([]uint64, 0, len(nodes)),
+		upstreams: make([]*Upstream, 0, len(nodes)),
+	}
+	for _, n := range nodes {
+		r.points = append(r.points, n.point)
+		r.upstreams = append(r.upstreams, n.upstream)
+	}
+
+	c.ring.Store(r)
+}
+
+// Pick is the `Balancer` interface implementation.
+func (c *ConsistentHash) Pick(request *http.Request) *Upstream {
+	r := c.ring.Load().(*ring)
+	if len(r.points) == 0 {
+		return nil
+	}
+
+	key := c.key(request)
+	if len(key) == 0 {
+		key = clientIP(request)
+	}
+
+	point := hash(key)
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= point
+	})
+
+	for i := 0; i < len(r.points); i++ {
+		if u := r.upstreams[(start+i)%len(r.points)]; u.available() {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// hash computes the ring position of a key. The FNV-1a hash is
+// finalized with the MurmurHash3 mixer to spread the similar keys.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	v := h.Sum64()
+
+	v ^= v >> 33
+	v *= 0xff51afd7ed558ccd
+	v ^= v >> 33
+	v *= 0xc4ceb9fe1a85ec53
+	v ^= v >> 33
+	return v
+}
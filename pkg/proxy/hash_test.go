@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUpstreams(n int) []*Upstream {
+	upstreams := make([]*Upstream, n)
+	for i := range upstreams {
+		upstreams[i] = NewUpstream(&url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:80", i)})
+	}
+	return upstreams
+}
+
+func TestParseHashKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/path?user=alice", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-User", "bob")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "path", want: "/path"},
+		{value: "ip", want: "192.0.2.1"},
+		{value: "header:X-User", want: "bob"},
+		{value: "query:user", want: "alice"},
+		{value: "cookie:session", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			key, err := ParseHashKey(tt.value)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, key(req))
+			}
+		})
+	}
+
+	_, err := ParseHashKey("header:")
+	assert.Error(t, err)
+}
+
+func TestConsistentHash_Pick(t *testing.T) {
+	upstreams := newTestUpstreams(4)
+	c := NewConsistentHash(HashByQuery("key"), 0)
+	c.Update(upstreams)
+
+	pick := func(key string) *Upstream {
+		return c.Pick(httptest.NewRequest("GET", "/?key="+key, nil))
+	}
+
+	t.Run("Same key same upstream", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, pick("alice"), pick("alice"))
+		}
+	})
+
+	t.Run("Distribution", func(t *testing.T) {
+		counts := make(map[*Upstream]int)
+		for i := 0; i < 10000; i++ {
+			counts[pick(fmt.Sprint(i))]++
+		}
+
+		assert.Len(t, counts, 4)
+		for _, count := range counts {
+			assert.InDelta(t, 2500, count, 600)
+		}
+	})
+
+	t.Run("Minimal remapping", func(t *testing.T) {
+		before := make(map[string]*Upstream)
+		for i := 0; i < 10000; i++ {
+			before[fmt.Sprint(i)] = pick(fmt.Sprint(i))
+		}
+
+		c.Update(append(upstreams, newTestUpstreams(5)[4]))
+		defer c.Update(upstreams)
+
+		moved := 0
+		for key, u := range before {
+			if pick(key) != u {
+				moved++
+			}
+		}
+
+		// Only the keys of the new upstream (about 1/5) are moved.
+		assert.InDelta(t, 2000, moved, 600)
+	})
+
+	t.Run("Unhealthy upstream", func(t *testing.T) {
+		u := pick("alice")
+		u.SetHealthy(false)
+		defer u.SetHealthy(true)
+
+		fallback := pick("alice")
+		assert.NotNil(t, fallback)
+		assert.NotEqual(t, u, fallback)
+	})
+
+	t.Run("Empty ring", func(t *testing.T) {
+		assert.Nil(t, NewConsistentHash(HashByPath(), 0).Pick(httptest.NewRequest("GET", "/", nil)))
+	})
+}
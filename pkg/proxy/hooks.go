@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// Hooks receives the events of the proxied requests, to build a
+// custom instrumentation. The calls are made in the goroutine of the
+// request, so they must not block.
+//
+// NopHooks can be embedded to implement only some of the methods.
+type Hooks interface {
+
+	// OnUpstreamRequest is called before sending a request to the
+	// selected upstream.
+	OnUpstreamRequest(request *http.Request, upstream *Upstream)
+
+	// OnUpstreamResponse is called with the response headers of the
+	// upstream, and the time it took to receive them.
+	OnUpstreamResponse(request *http.Request, upstream *Upstream, response *http.Response, latency time.Duration)
+
+	// OnUpstreamError is called when no upstream is available, the
+	// upstream being nil, or when the upstream request fails.
+	OnUpstreamError(request *http.Request, upstream *Upstream, err error)
+}
+
+// NopHooks is a Hooks implementation doing nothing.
+type NopHooks struct{}
+
+// Static implementation checker.
+var _ Hooks = NopHooks{}
+
+// OnUpstreamRequest is the `Hooks` interface implementation.
+func (NopHooks) OnUpstreamRequest(*http.Request, *Upstream) {}
+
+// OnUpstreamResponse is the `Hooks` interface implementation.
+func (NopHooks) OnUpstreamResponse(*http.Request, *Upstream, *http.Response, time.Duration) {}
+
+// OnUpstreamError is the `Hooks` interface implementation.
+func (NopHooks) OnUpstreamError(*http.Request, *Upstream, error) {}
+
+// WithHooks sends the request events to the given hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(handler *Handler) {
+		handler.hooks = hooks
+	}
+}
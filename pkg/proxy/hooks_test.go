@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingHooks records the names of the proxy events.
+type recordingHooks struct {
+	NopHooks
+	events []string
+}
+
+func (r *recordingHooks) OnUpstreamRequest(request *http.Request, upstream *Upstream) {
+	r.events = append(r.events, "request "+upstream.URL.Host)
+}
+
+func (r *recordingHooks) OnUpstreamResponse(request *http.Request, upstream *Upstream, response *http.Response, latency time.Duration) {
+	r.events = append(r.events, "response "+response.Status)
+}
+
+func (r *recordingHooks) OnUpstreamError(request *http.Request, upstream *Upstream, err error) {
+	if upstream == nil {
+		r.events = append(r.events, "error")
+		return
+	}
+	r.events = append(r.events, "error "+upstream.URL.Host)
+}
+
+func TestHandler_Hooks(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	}))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	var messages []string
+	logger := logging.LoggerFunc(func(ctx context.Context, level logging.Level, msg string, fields logging.Fields) {
+		messages = append(messages, level.String()+" "+msg)
+	})
+
+	hooks := &recordingHooks{}
+	New(targetURL, WithHooks(hooks), WithLogger(logger)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"request " + targetURL.Host, "response 418 I'm a teapot"}, hooks.events)
+	assert.Empty(t, messages)
+
+	hooks = &recordingHooks{}
+	unreachable := &url.URL{Scheme: "http", Host: "127.0.0.1:1"}
+	New(unreachable, WithHooks(hooks), WithLogger(logger)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"request 127.0.0.1:1", "error 127.0.0.1:1"}, hooks.events)
+	assert.Equal(t, []string{"error Error while sending request"}, messages)
+
+	hooks = &recordingHooks{}
+	NewBalanced(NewPool(), WithHooks(hooks), WithLogger(logging.Discard)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"error"}, hooks.events)
+}
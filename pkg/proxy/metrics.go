@@ -0,0 +1,19 @@
+package proxy
+
+import "net/http"
+
+// Metrics records the proxied requests.
+type Metrics interface {
+
+	// Begin is called when a request is received. The returned
+	// function is called once the request is handled, with the chosen
+	// upstream (nil if none was available) and the response status.
+	Begin(request *http.Request) func(upstream *Upstream, status int)
+}
+
+// WithMetrics records the requests in the given metrics.
+func WithMetrics(m Metrics) Option {
+	return func(handler *Handler) {
+		handler.metrics = m
+	}
+}
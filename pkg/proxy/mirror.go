@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+)
+
+// MirrorConfig configures the traffic mirroring to a shadow target.
+type MirrorConfig struct {
+
+	// Target is the shadow server URL that receives the copies of
+	// the requests.
+	Target *url.URL
+
+	// Percentage is the percentage of the requests that are mirrored,
+	// from 0 to 100.
+	Percentage float64
+
+	// MaxConcurrent is the maximum number of mirrored requests in
+	// flight. The requests are not mirrored when the limit is reached.
+	MaxConcurrent int
+
+	// MaxBodySize is the maximum request body size that is buffered
+	// to be mirrored. Larger requests are not mirrored.
+	MaxBodySize int64
+
+	// Timeout is the maximum duration of a mirrored request.
+	Timeout time.Duration
+
+	// Compare enables the comparison between the primary and the
+	// shadow responses when set.
+	Compare *CompareConfig
+}
+
+// mirror replays a copy of the requests to a shadow target
+// asynchronously. The shadow responses are discarded, or only
+// compared to the primary ones.
+type mirror struct {
+	config MirrorConfig
+
+	// comparator compares the responses when the comparison is enabled.
+	comparator *comparator
+
+	// transport and logger are the ones of the proxy, set once it is
+	// created.
+	transport http.RoundTripper
+	logger    logging.Logger
+
+	// slots bounds the number of mirrored requests in flight.
+	slots chan struct{}
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// WithMirror replays a copy of a percentage of the requests to a
+// shadow target. The mirroring never slows down the primary requests:
+// the copies are sent asynchronously and dropped when too many of them
+// are already in flight.
+func WithMirror(config MirrorConfig) Option {
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 100
+	}
+
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	return func(handler *Handler) {
+		handler.mirror = &mirror{
+			config: config,
+			slots:  make(chan struct{}, config.MaxConcurrent),
+			rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+
+		if config.Compare != nil {
+			handler.mirror.comparator = newComparator(*config.Compare)
+		}
+	}
+}
+
+// sample decides if the current request has to be mirrored.
+func (m *mirror) sample() bool {
+	if m.config.Percentage >= 100 {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rand.Float64()*100 < m.config.Percentage
+}
+
+// reserve takes a slot for a shadow request, before its body is
+// buffered. It returns false when too many shadow requests are already
+// in flight, the request being then forwarded untouched.
+func (m *mirror) reserve(request *http.Request) bool {
+	select {
+	case m.slots <- struct{}{}:
+		return true
+	default:
+		m.logger.Log(request.Context(), logging.LevelDebug, "Mirror saturated, dropping request", logging.Fields{"resource": request.URL.RequestURI()})
+		return false
+	}
+}
+
+// release frees the slot taken by reserve.
+func (m *mirror) release() {
+	<-m.slots
+}
+
+// prepare buffers the request body and creates the shadow request for
+// the incoming URL. The outgoing request body is replaced by the
+// buffered one.
+// It returns nil if the request cannot be mirrored.
+func (m *mirror) prepare(request *http.Request, incoming *url.URL) *http.Request {
+	var body []byte
+	if request.Body != nil && request.Body != http.NoBody {
+		buf, err := ioutil.ReadAll(io.LimitReader(request.Body, m.config.MaxBodySize+1))
+		if err != nil {
+			m.logger.Log(request.Context(), logging.LevelDebug, "Error while buffering mirrored request body", logging.Fields{"error": err})
+			request.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buf), request.Body), Closer: request.Body}
+			return nil
+		}
+
+		if int64(len(buf)) > m.config.MaxBodySize {
+			// Too large, the primary request is streamed as is.
+			request.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buf), request.Body), Closer: request.Body}
+			return nil
+		}
+
+		_ = request.Body.Close()
+		request.Body = ioutil.NopCloser(bytes.NewReader(buf))
+		body = buf
+	}
+
+	// The shadow request must not be canceled with the client request.
+	shadow := request.Clone(context.Background())
+	shadow.URL = mergeURLs(incoming, m.config.Target)
+	shadow.Host = ""
+	if IsUnix(m.config.Target) {
+		shadow.Host = "localhost"
+	}
+	shadow.Body = http.NoBody
+	if body != nil {
+		shadow.Body = ioutil.NopCloser(bytes.NewReader(body))
+		shadow.ContentLength = int64(len(body))
+	}
+
+	return shadow
+}
+
+// send replays the shadow request in the slot taken by reserve, and
+// releases it once done. The callback is called with the shadow
+// response, before its body is discarded.
+func (m *mirror) send(shadow *http.Request, callback func(*http.Response, error)) {
+	go func() {
+		defer m.release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+		defer cancel()
+
+		response, err := m.transport.RoundTrip(shadow.WithContext(ctx))
+		if callback != nil {
+			callback(response, err)
+		}
+
+		if err != nil {
+			m.logger.Log(ctx, logging.LevelDebug, "Error while sending mirrored request", logging.Fields{
+				"error":    err,
+				"resource": shadow.URL.RequestURI(),
+			})
+			return
+		}
+
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+}
+
+// sendAndCompare replays the shadow request and compares its response
+// with the primary response provided through the exchange.
+func (m *mirror) sendAndCompare(request, shadow *http.Request) *exchange {
+	e := newExchange()
+
+	m.send(shadow, func(response *http.Response, err error) {
+		var shadowResponse *capturedResponse
+		if err == nil {
+			shadowResponse = m.comparator.capture(response)
+		}
+
+		m.comparator.compare(request, e.wait(m.config.Timeout), shadowResponse)
+	})
+
+	return e
+}
+
+// readCloser combines a reader and the closer of another one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -2,13 +2,15 @@ package proxy
 
 import (
 	"crypto/tls"
-	"net/http"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
 )
 
 type Option func(*Handler)
 
 func WithInsecure() Option {
-	t := http.DefaultTransport.(*http.Transport).Clone()
+	t := newTransport()
 	t.TLSClientConfig = &tls.Config{
 		InsecureSkipVerify: true,
 	}
@@ -17,3 +19,19 @@ func WithInsecure() Option {
 		handler.transport = t
 	}
 }
+
+// WithTracer creates a client span for each upstream request, and
+// propagates it in the request headers.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(handler *Handler) {
+		handler.tracer = t
+	}
+}
+
+// WithLogger sets the logger of the proxy, the standard logrus logger
+// by default.
+func WithLogger(l logging.Logger) Option {
+	return func(handler *Handler) {
+		handler.logger = l
+	}
+}
@@ -5,14 +5,32 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
-	"github.com/sirupsen/logrus"
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/logging"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
 )
 
 // Handler represents a proxy server.
 type Handler struct {
-	target    *url.URL
+	selector  Selector
 	transport http.RoundTripper
+
+	// mirror replays the requests to a shadow target when set.
+	mirror *mirror
+
+	// metrics records the requests when set.
+	metrics Metrics
+
+	// tracer creates the upstream client spans when set.
+	tracer *tracing.Tracer
+
+	// hooks receives the request events.
+	hooks Hooks
+
+	// logger writes the errors of the requests.
+	logger logging.Logger
 }
 
 // Static implementation checker.
@@ -21,15 +39,31 @@ var _ http.Handler = (*Handler)(nil)
 // New creates a proxy that can be served as a http.Handler.
 // It takes the target server to forward requests.
 func New(target *url.URL, opts ...Option) *Handler {
+	return NewBalanced(NewPool(NewUpstream(target)), opts...)
+}
+
+// NewBalanced creates a proxy that can be served as a http.Handler.
+// It takes the selector that chooses the upstream of each request.
+func NewBalanced(selector Selector, opts ...Option) *Handler {
 	h := &Handler{
-		transport: http.DefaultTransport,
-		target:    target,
+		transport: defaultTransport,
+		selector:  selector,
+		hooks:     NopHooks{},
+		logger:    logging.Default(),
 	}
 
 	for _, o := range opts {
 		o(h)
 	}
 
+	if h.mirror != nil {
+		h.mirror.transport = h.transport
+		h.mirror.logger = h.logger
+		if h.mirror.comparator != nil {
+			h.mirror.comparator.logger = h.logger
+		}
+	}
+
 	return h
 }
 
@@ -39,33 +73,122 @@ func New(target *url.URL, opts ...Option) *Handler {
 // with the type and then reads the HTTP response.
 // The response is forwarded to the client connection.
 // If an error occurs during the forwarding process, it sends back a
-// 502 Bad Gateway status to the client.
+// 502 Bad Gateway status to the client. If no upstream is available,
+// it sends back a 503 Service Unavailable status.
 //
 // ServeHTTP is the `http.Handler` implementation for the `Handler` type.
 func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var upstream *Upstream
+	status := http.StatusBadGateway
+	if h.metrics != nil {
+		done := h.metrics.Begin(request)
+		defer func() { done(upstream, status) }()
+	}
+
+	upstream, err := h.selector.Select(writer, request)
+	if err != nil {
+		h.hooks.OnUpstreamError(request, nil, err)
+		h.logger.Log(request.Context(), logging.LevelError, "Error while selecting upstream", logging.Fields{
+			"error":    err,
+			"resource": request.URL.RequestURI(),
+		})
+		status = http.StatusServiceUnavailable
+		writer.WriteHeader(status)
+		return
+	}
+
 	outgoingRequest := request.Clone(request.Context())
-	outgoingRequest.URL = mergeURLs(request.URL, h.target)
+	outgoingRequest.URL = mergeURLs(request.URL, upstream.URL)
+	if IsUnix(upstream.URL) && len(outgoingRequest.Host) == 0 {
+		outgoingRequest.Host = "localhost"
+	}
 	outgoingRequest.Header.Set("X-Proxy-Remote-Addr", request.RemoteAddr)
 
+	var e *exchange
+	if h.mirror != nil && h.mirror.sample() && h.mirror.reserve(request) {
+		// The slot is taken first, so the body of the requests that
+		// cannot be mirrored is not buffered.
+		shadow := h.mirror.prepare(outgoingRequest, request.URL)
+		switch {
+		case shadow == nil:
+			h.mirror.release()
+		case h.mirror.comparator != nil:
+			e = h.mirror.sendAndCompare(request, shadow)
+		default:
+			h.mirror.send(shadow, nil)
+		}
+	}
+
+	ctx, span := h.tracer.Start(request.Context(), "upstream "+request.Method, tracing.SpanKindClient,
+		tracing.String("http.method", request.Method),
+		tracing.String("http.url", outgoingRequest.URL.String()),
+		tracing.String("net.peer.name", upstream.Addr()),
+	)
+	defer span.End()
+	if span != nil {
+		outgoingRequest = outgoingRequest.WithContext(ctx)
+		h.tracer.Inject(ctx, outgoingRequest.Header)
+	}
+
 	// Sends the request to the target server.
 	// Note: Cannot use a simple `http.Client` because the implementation
 	//       returns error with HTTP semantic errors (4xx, 5xx, ...).
+	h.hooks.OnUpstreamRequest(outgoingRequest, upstream)
+	start := time.Now()
 	response, err := h.transport.RoundTrip(outgoingRequest)
+	latency := time.Since(start)
+	accesslog.SetUpstream(request.Context(), upstream.Addr(), latency)
 	if err != nil {
-		logrus.WithError(err).Error("Error while sending request")
+		span.SetStatus(tracing.StatusError, err.Error())
+		h.hooks.OnUpstreamError(outgoingRequest, upstream, err)
+		h.logger.Log(request.Context(), logging.LevelError, "Error while sending request", logging.Fields{"error": err})
+		// The requests abandoned by their client are not the upstream
+		// fault.
+		if request.Context().Err() == nil {
+			upstream.fail()
+		}
 		writer.WriteHeader(http.StatusBadGateway)
+		if e != nil {
+			e.primary <- nil
+		}
 		return
 	}
 
 	defer response.Body.Close()
+	upstream.succeed()
+	h.hooks.OnUpstreamResponse(outgoingRequest, upstream, response, latency)
+	status = response.StatusCode
+	span.SetAttributes(tracing.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(tracing.StatusError, response.Status)
+	}
+
+	if e != nil {
+		// Captures the primary response while it is forwarded.
+		body := &teeBody{ReadCloser: response.Body, limit: h.mirror.comparator.config.MaxBodySize}
+		response.Body = body
+		defer func() {
+			e.primary <- &capturedResponse{status: response.StatusCode, headers: response.Header, body: body.buf.Bytes()}
+		}()
+	}
 
 	if err = copyResponse(response, writer); err != nil {
-		logrus.WithError(err).Error("Error while copying response")
+		h.logger.Log(request.Context(), logging.LevelError, "Error while copying response", logging.Fields{"error": err})
 		writer.WriteHeader(http.StatusBadGateway)
 		return
 	}
 }
 
+// MirrorStats returns the shadow comparison counters by route. It
+// returns nil if the comparison is not enabled.
+func (h *Handler) MirrorStats() map[string]MirrorStats {
+	if h.mirror == nil || h.mirror.comparator == nil {
+		return nil
+	}
+
+	return h.mirror.comparator.Stats()
+}
+
 // copyResponse forwards the given response to the response writer.
 //
 // It copies the HTTP status code, merges the headers and copies the
@@ -105,6 +228,10 @@ func copyResponse(response *http.Response, writer http.ResponseWriter) error {
 //
 // NOTE: It also merges the query parameters.
 func mergeURLs(req, target *url.URL) *url.URL {
+	if IsUnix(target) {
+		return unixURL(req, target.Path)
+	}
+
 	u := *target
 
 	if !strings.HasSuffix(u.Path, "/") {
@@ -45,6 +45,11 @@ func Test_mergeURLs(t *testing.T) {
 		request: &url.URL{RawQuery: "q=test"},
 		target:  &url.URL{RawQuery: "q2=test2"},
 		want:    &url.URL{Path: "/", RawQuery: "q2=test2&q=test"},
+	}, {
+		name:    "With Unix socket target",
+		request: &url.URL{Path: "/entities", RawQuery: "q=test"},
+		target:  &url.URL{Scheme: "unix", Path: "/run/app.sock"},
+		want:    &url.URL{Scheme: "http", Host: "2f72756e2f6170702e736f636b.unix.invalid", Path: "/entities", RawQuery: "q=test"},
 	}}
 
 	for _, tt := range tests {
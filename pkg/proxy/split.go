@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Override forces the upstream group of the requests that match it,
+// whatever the split weights are (e.g. "X-Canary: true").
+type Override struct {
+
+	// Header is the request header to check.
+	Header string
+
+	// Cookie is the request cookie to check.
+	Cookie string
+
+	// Query is the request query parameter to check.
+	Query string
+
+	// Value is the expected value. Any non empty value matches when
+	// it is empty.
+	Value string
+
+	// Group is the forced group. When it is empty, the matched value
+	// is used as the group name.
+	Group string
+}
+
+// match returns the forced group if the request matches the override.
+func (o *Override) match(request *http.Request) (string, bool) {
+	var value string
+	switch {
+	case len(o.Header) > 0:
+		value = request.Header.Get(o.Header)
+	case len(o.Cookie) > 0:
+		if c, err := request.Cookie(o.Cookie); err == nil {
+			value = c.Value
+		}
+	case len(o.Query) > 0:
+		value = request.URL.Query().Get(o.Query)
+	}
+
+	if len(value) == 0 || (len(o.Value) > 0 && value != o.Value) {
+		return "", false
+	}
+
+	if len(o.Group) > 0 {
+		return o.Group, true
+	}
+
+	return value, true
+}
+
+// Split is a Selector that distributes the requests between named
+// upstream groups by percentage weight.
+//
+// The weights can be changed at runtime. The overrides are checked
+// first and force a group for the requests they match.
+type Split struct {
+	mu        sync.RWMutex
+	groups    []splitGroup
+	overrides []Override
+	total     int
+
+	// randMu protects the random source, which is not safe for
+	// concurrent use.
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// splitGroup is a named upstream group with its weight.
+type splitGroup struct {
+	name     string
+	selector Selector
+	weight   int
+}
+
+// Static implementation checker.
+var _ Selector = (*Split)(nil)
+
+// NewSplit creates an empty Split with the given overrides.
+func NewSplit(overrides ...Override) *Split {
+	return &Split{
+		overrides: overrides,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// AddGroup registers an upstream group with its weight. A group with
+// a zero weight only receives the overridden requests.
+func (s *Split) AddGroup(name string, selector Selector, weight int) *Split {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.groups = append(s.groups, splitGroup{name: name, selector: selector, weight: weight})
+	s.total += weight
+	return s
+}
+
+// SetWeights changes the weights of the groups. The groups missing
+// from the map keep their weight. It returns an error if a group is
+// unknown or a weight is negative, and nothing is changed in this case.
+func (s *Split) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("proxy: negative weight for group %q", name)
+		}
+		if s.group(name) == nil {
+			return fmt.Errorf("proxy: unknown group %q", name)
+		}
+	}
+
+	s.total = 0
+	for i := range s.groups {
+		if weight, ok := weights[s.groups[i].name]; ok {
+			s.groups[i].weight = weight
+		}
+		s.total += s.groups[i].weight
+	}
+
+	return nil
+}
+
+// Weights returns the current weights of the groups.
+func (s *Split) Weights() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	weights := make(map[string]int, len(s.groups))
+	for _, g := range s.groups {
+		weights[g.name] = g.weight
+	}
+
+	return weights
+}
+
+// Select is the `Selector` interface implementation.
+func (s *Split) Select(writer http.ResponseWriter, request *http.Request) (*Upstream, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.overrides {
+		if name, ok := s.overrides[i].match(request); ok {
+			if g := s.group(name); g != nil {
+				return g.selector.Select(writer, request)
+			}
+		}
+	}
+
+	if s.total <= 0 {
+		return nil, ErrNoUpstream
+	}
+
+	s.randMu.Lock()
+	n := s.rand.Intn(s.total)
+	s.randMu.Unlock()
+
+	for _, g := range s.groups {
+		if n < g.weight {
+			return g.selector.Select(writer, request)
+		}
+		n -= g.weight
+	}
+
+	return nil, ErrNoUpstream
+}
+
+// Upstreams is the `UpstreamLister` interface implementation. It
+// returns the upstreams of all the groups.
+func (s *Split) Upstreams() []*Upstream {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var upstreams []*Upstream
+	for _, g := range s.groups {
+		if lister, ok := g.selector.(UpstreamLister); ok {
+			upstreams = append(upstreams, lister.Upstreams()...)
+		}
+	}
+
+	return upstreams
+}
+
+// group returns the group with the given name, or nil.
+func (s *Split) group(name string) *splitGroup {
+	for i := range s.groups {
+		if s.groups[i].name == name {
+			return &s.groups[i]
+		}
+	}
+
+	return nil
+}
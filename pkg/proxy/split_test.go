@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPool(host string) *Pool {
+	return NewPool(NewUpstream(&url.URL{Scheme: "http", Host: host}))
+}
+
+func TestPool_Select(t *testing.T) {
+	a, b := NewUpstream(&url.URL{Host: "a"}), NewUpstream(&url.URL{Host: "b"})
+	p := NewPool(a, b)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	t.Run("Round robin", func(t *testing.T) {
+		first, _ := p.Select(nil, req)
+		second, _ := p.Select(nil, req)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("Skip unhealthy upstreams", func(t *testing.T) {
+		a.SetHealthy(false)
+		defer a.SetHealthy(true)
+		for i := 0; i < 4; i++ {
+			u, err := p.Select(nil, req)
+			assert.NoError(t, err)
+			assert.Equal(t, b, u)
+		}
+	})
+
+	t.Run("No healthy upstream", func(t *testing.T) {
+		a.SetHealthy(false)
+		b.SetHealthy(false)
+		defer a.SetHealthy(true)
+		defer b.SetHealthy(true)
+		_, err := p.Select(nil, req)
+		assert.Equal(t, ErrNoUpstream, err)
+	})
+}
+
+func TestSplit_Select(t *testing.T) {
+	s := NewSplit(
+		Override{Header: "X-Canary", Value: "true", Group: "canary"},
+		Override{Cookie: "group"},
+		Override{Query: "group"},
+	).
+		AddGroup("stable", newTestPool("stable"), 100).
+		AddGroup("canary", newTestPool("canary"), 0)
+
+	selectHost := func(req *http.Request) string {
+		u, err := s.Select(nil, req)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		return u.URL.Host
+	}
+
+	t.Run("Weights", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			assert.Equal(t, "stable", selectHost(httptest.NewRequest("GET", "/", nil)))
+		}
+	})
+
+	t.Run("Header override", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Canary", "true")
+		assert.Equal(t, "canary", selectHost(req))
+
+		req.Header.Set("X-Canary", "false")
+		assert.Equal(t, "stable", selectHost(req))
+	})
+
+	t.Run("Cookie override", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "group", Value: "canary"})
+		assert.Equal(t, "canary", selectHost(req))
+	})
+
+	t.Run("Query override", func(t *testing.T) {
+		assert.Equal(t, "canary", selectHost(httptest.NewRequest("GET", "/?group=canary", nil)))
+		assert.Equal(t, "stable", selectHost(httptest.NewRequest("GET", "/?group=unknown", nil)))
+	})
+
+	t.Run("Runtime weights", func(t *testing.T) {
+		assert.Error(t, s.SetWeights(map[string]int{"unknown": 10}))
+		assert.Error(t, s.SetWeights(map[string]int{"stable": -1}))
+		assert.Equal(t, map[string]int{"stable": 100, "canary": 0}, s.Weights())
+
+		assert.NoError(t, s.SetWeights(map[string]int{"stable": 0, "canary": 100}))
+		for i := 0; i < 20; i++ {
+			assert.Equal(t, "canary", selectHost(httptest.NewRequest("GET", "/", nil)))
+		}
+
+		assert.NoError(t, s.SetWeights(map[string]int{"stable": 50, "canary": 50}))
+		hosts := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			hosts[selectHost(httptest.NewRequest("GET", "/", nil))]++
+		}
+		assert.InDelta(t, 500, hosts["stable"], 100)
+		assert.InDelta(t, 500, hosts["canary"], 100)
+	})
+
+	t.Run("No weight", func(t *testing.T) {
+		assert.NoError(t, s.SetWeights(map[string]int{"stable": 0, "canary": 0}))
+		_, err := s.Select(nil, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, ErrNoUpstream, err)
+	})
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SchemeUnix is the scheme of the upstreams listening on a Unix
+// socket, e.g. "unix:///var/run/app.sock". The requests are sent in
+// plain HTTP over the socket whose path is the URL path.
+const SchemeUnix = "unix"
+
+// unixHostSuffix ends the hosts standing for the Unix sockets in the
+// outgoing URLs, the socket path being hexadecimal encoded before. The
+// ".invalid" TLD is never resolved (RFC 2606).
+const unixHostSuffix = ".unix.invalid"
+
+// defaultTransport is the transport of the proxies, able to dial the
+// Unix socket upstreams.
+var defaultTransport = newTransport()
+
+// IsUnix checks if a URL is a Unix socket upstream URL.
+func IsUnix(u *url.URL) bool {
+	return u.Scheme == SchemeUnix
+}
+
+// unixURL returns the outgoing URL of a request to a Unix socket.
+// Each socket has its own host, so the transport keeps a connection
+// pool per socket.
+func unixURL(req *url.URL, socket string) *url.URL {
+	return &url.URL{
+		Scheme:   "http",
+		Host:     hex.EncodeToString([]byte(socket)) + unixHostSuffix,
+		Path:     req.Path,
+		RawPath:  req.RawPath,
+		RawQuery: req.RawQuery,
+	}
+}
+
+// newTransport returns a copy of the default transport dialing the
+// Unix sockets of the URLs created by unixURL. The environment proxy
+// is not used for the Unix sockets.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && strings.HasSuffix(host, unixHostSuffix) {
+			if socket, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix)); err == nil {
+				return dialer.DialContext(ctx, "unix", string(socket))
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	proxy := t.Proxy
+	t.Proxy = func(request *http.Request) (*url.URL, error) {
+		if proxy == nil || strings.HasSuffix(request.URL.Hostname(), unixHostSuffix) {
+			return nil, nil
+		}
+		return proxy(request)
+	}
+
+	return t
+}
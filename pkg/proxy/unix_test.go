@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_UnixWithEnvironmentProxy(t *testing.T) {
+	// The environment proxy is read once by the process, the test is
+	// run again in a child process with the proxy variables set.
+	if os.Getenv("PROXY_TEST_UNIX_CHILD") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHandler_UnixWithEnvironmentProxy$", "-test.v")
+		cmd.Env = append(os.Environ(),
+			"PROXY_TEST_UNIX_CHILD=1",
+			"HTTP_PROXY=http://proxy.invalid:3128",
+			"HTTPS_PROXY=http://proxy.invalid:3128",
+		)
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "app.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		_, _ = writer.Write([]byte("from socket"))
+	})}
+	go func() { _ = app.Serve(ln) }()
+	defer app.Close()
+
+	recorder := httptest.NewRecorder()
+	New(&url.URL{Scheme: SchemeUnix, Path: socket}).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "from socket", recorder.Body.String())
+
+	// The other hosts still use the environment proxy.
+	proxy, err := defaultTransport.Proxy(httptest.NewRequest("GET", "http://example.com/", nil))
+	if assert.NoError(t, err) && assert.NotNil(t, proxy) {
+		assert.Equal(t, "proxy.invalid:3128", proxy.Host)
+	}
+}
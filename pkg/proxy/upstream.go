@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoUpstream is returned by a Selector when no upstream is able
+// to handle the request.
+var ErrNoUpstream = errors.New("proxy: no upstream available")
+
+// Selector is an interface that chooses the upstream handling
+// a request.
+type Selector interface {
+
+	// Select returns the upstream that handles the request. The writer
+	// can be used to set response headers related to the selection.
+	Select(writer http.ResponseWriter, request *http.Request) (*Upstream, error)
+}
+
+// Upstream represents a server the requests can be forwarded to.
+type Upstream struct {
+
+	// URL is the upstream server URL.
+	URL *url.URL
+
+	// Weight is the relative share of the requests the upstream
+	// receives from the weighted balancers. Zero means 1.
+	Weight int
+
+	// down is set when the upstream is not healthy.
+	down int32
+
+	// breaker is opened by the requests the upstream could not answer.
+	breaker breaker
+
+	// drained is set when the upstream must not receive new requests.
+	drained int32
+}
+
+// NewUpstream creates a healthy upstream.
+func NewUpstream(u *url.URL) *Upstream {
+	return &Upstream{URL: u}
+}
+
+// Addr returns the address of the upstream: the host and port, or the
+// path of the Unix socket upstreams.
+func (u *Upstream) Addr() string {
+	if IsUnix(u.URL) {
+		return u.URL.Path
+	}
+	return u.URL.Host
+}
+
+// weight returns the effective weight of the upstream.
+func (u *Upstream) weight() int {
+	if u.Weight <= 0 {
+		return 1
+	}
+	return u.Weight
+}
+
+// Healthy checks if the upstream can receive requests: it is not down
+// and its breaker is not open.
+func (u *Upstream) Healthy() bool {
+	return atomic.LoadInt32(&u.down) == 0 && u.breaker.State() != BreakerOpen
+}
+
+// Breaker returns the state of the circuit breaker of the upstream.
+func (u *Upstream) Breaker() BreakerState {
+	return u.breaker.State()
+}
+
+// SetHealthy marks the upstream as able, or not, to receive requests.
+// It also closes its breaker.
+func (u *Upstream) SetHealthy(healthy bool) {
+	var down int32
+	if !healthy {
+		down = 1
+	}
+
+	atomic.StoreInt32(&u.down, down)
+	u.breaker.success()
+}
+
+// fail records a request the upstream could not answer.
+func (u *Upstream) fail() {
+	u.breaker.failure()
+}
+
+// succeed records a request the upstream answered.
+func (u *Upstream) succeed() {
+	u.breaker.success()
+}
+
+// CopyState copies the health, breaker and drain states of another
+// upstream, e.g. the previous instance of the same server.
+func (u *Upstream) CopyState(from *Upstream) {
+	atomic.StoreInt32(&u.down, atomic.LoadInt32(&from.down))
+	u.breaker.copyState(&from.breaker)
+	u.SetDrained(from.Drained())
+}
+
+// Drained checks if the upstream has been taken out of the rotation.
+func (u *Upstream) Drained() bool {
+	return atomic.LoadInt32(&u.drained) == 1
+}
+
+// SetDrained takes the upstream out of the rotation, or puts it back.
+// A drained upstream finishes its in-flight requests but receives no
+// new ones, whatever its health.
+func (u *Upstream) SetDrained(drained bool) {
+	var value int32
+	if drained {
+		value = 1
+	}
+
+	atomic.StoreInt32(&u.drained, value)
+}
+
+// available checks if the upstream can be selected. It claims the
+// trial request of a half-open breaker, so it is only called by the
+// balancers for the upstream they return.
+func (u *Upstream) available() bool {
+	return atomic.LoadInt32(&u.down) == 0 && !u.Drained() && u.breaker.allow()
+}
+
+// Pool is a Selector that balances the requests between several
+// upstreams. The unhealthy and drained upstreams are skipped.
+type Pool struct {
+	mu        sync.RWMutex
+	upstreams []*Upstream
+	balancer  Balancer
+
+	// maxFails and failTimeout configure the breakers of the members.
+	maxFails    int
+	failTimeout time.Duration
+}
+
+// Static implementation checker.
+var _ Selector = (*Pool)(nil)
+
+// NewPool creates a round robin pool from the given upstreams.
+func NewPool(upstreams ...*Upstream) *Pool {
+	p := &Pool{upstreams: upstreams, balancer: NewRoundRobin()}
+	p.balancer.Update(upstreams)
+	return p
+}
+
+// WithBalancer sets the balancer used to choose the upstreams.
+func (p *Pool) WithBalancer(b Balancer) *Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.balancer = b
+	p.balancer.Update(p.upstreams)
+	return p
+}
+
+// WithFailTimeout opens the breaker of the members after maxFails
+// consecutive requests they could not answer (e.g. refused
+// connections), taking them out of the rotation for the given duration.
+// A single trial request is then sent: the breaker closes if it
+// succeeds and opens again if it fails. The failures are ignored when
+// the timeout is zero, the default. A zero maxFails means 1.
+func (p *Pool) WithFailTimeout(maxFails int, timeout time.Duration) *Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxFails, p.failTimeout = maxFails, timeout
+	for _, u := range p.upstreams {
+		u.breaker.configure(maxFails, timeout)
+	}
+	return p
+}
+
+// Upstreams returns the pool members.
+func (p *Pool) Upstreams() []*Upstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*Upstream(nil), p.upstreams...)
+}
+
+// SetUpstreams replaces the pool members. The members that are still
+// listed with the same URL and weight are kept as is, so their
+// health state and balancing position are preserved.
+func (p *Pool) SetUpstreams(upstreams []*Upstream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[string]*Upstream, len(p.upstreams))
+	for _, u := range p.upstreams {
+		existing[u.URL.String()] = u
+	}
+
+	members := make([]*Upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		member, ok := existing[u.URL.String()]
+		switch {
+		case !ok:
+			member = u
+		case member.weight() != u.weight():
+			// Only the weight changed, the health and drain states are
+			// kept.
+			u.CopyState(member)
+			member = u
+		}
+		member.breaker.configure(p.maxFails, p.failTimeout)
+		members = append(members, member)
+	}
+
+	p.upstreams = members
+	p.balancer.Update(members)
+}
+
+// Select is the `Selector` interface implementation.
+func (p *Pool) Select(_ http.ResponseWriter, request *http.Request) (*Upstream, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if u := p.balancer.Pick(request); u != nil {
+		return u, nil
+	}
+
+	return nil, ErrNoUpstream
+}
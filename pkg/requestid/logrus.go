@@ -0,0 +1,28 @@
+package requestid
+
+import "github.com/sirupsen/logrus"
+
+// LogHook is a logrus hook adding the request ID to the entries
+// created with the request context, e.g.
+// `logrus.WithContext(request.Context())`.
+type LogHook struct{}
+
+// Static implementation checker.
+var _ logrus.Hook = LogHook{}
+
+// Levels is the `logrus.Hook` interface implementation.
+func (LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire is the `logrus.Hook` interface implementation.
+func (LogHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	if id := FromContext(entry.Context); len(id) > 0 {
+		entry.Data["request_id"] = id
+	}
+	return nil
+}
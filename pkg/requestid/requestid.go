@@ -0,0 +1,174 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+)
+
+// DefaultHeader is the header holding the request ID.
+const DefaultHeader = "X-Request-ID"
+
+// maxLength is the maximum length of the trusted incoming IDs.
+const maxLength = 128
+
+// New returns a new UUIDv7: the IDs are ordered by creation time, to
+// the millisecond.
+//
+// See https://www.rfc-editor.org/rfc/rfc9562#name-uuid-version-7
+func New() string {
+	var id [16]byte
+	_, _ = rand.Read(id[6:])
+
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(id[:6], ms[2:])
+
+	id[6] = 0x70 | id[6]&0x0f // Version 7.
+	id[8] = 0x80 | id[8]&0x3f // RFC 4122 variant.
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	hex.Encode(buf[9:13], id[4:6])
+	hex.Encode(buf[14:18], id[6:8])
+	hex.Encode(buf[19:23], id[8:10])
+	hex.Encode(buf[24:], id[10:])
+	buf[8], buf[13], buf[18], buf[23] = '-', '-', '-', '-'
+	return string(buf[:])
+}
+
+// idKey is the context key of the request ID.
+type idKey struct{}
+
+// FromContext returns the ID of the request context, or an empty
+// string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Handler is a http.Handler giving an ID to each request. The ID is
+// set in the request header forwarded to the upstreams, in the
+// response header, in the request context and in the bodies of the
+// error responses without one.
+type Handler struct {
+	header string
+	trust  bool
+	next   http.Handler
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Handler)(nil)
+
+// Option is a function used to modify
+// the handler behavior.
+type Option func(*Handler)
+
+// WithHeader sets the request and response header holding the ID,
+// DefaultHeader by default.
+func WithHeader(name string) Option {
+	return func(h *Handler) {
+		h.header = http.CanonicalHeaderKey(name)
+	}
+}
+
+// WithTrustIncoming keeps the ID sent by the client, when it is valid,
+// instead of generating a new one. It is meant for a proxy behind a
+// load balancer setting the ID.
+func WithTrustIncoming() Option {
+	return func(h *Handler) {
+		h.trust = true
+	}
+}
+
+// NewHandler creates a request ID middleware.
+func NewHandler(next http.Handler, opts ...Option) *Handler {
+	h := &Handler{header: DefaultHeader, next: next}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ServeHTTP is the `http.Handler` interface implementation.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	id := request.Header.Get(h.header)
+	if !h.trust || !valid(id) {
+		id = New()
+	}
+
+	request.Header.Set(h.header, id)
+	writer.Header().Set(h.header, id)
+	accesslog.SetRequestID(request.Context(), id)
+
+	w := &errorWriter{ResponseWriter: writer}
+	h.next.ServeHTTP(w, request.WithContext(context.WithValue(request.Context(), idKey{}, id)))
+
+	if w.pending && request.Method != http.MethodHead {
+		_, _ = fmt.Fprintf(writer, "%d %s\nrequest_id: %s\n", w.status, http.StatusText(w.status), id)
+	}
+}
+
+// valid checks that an incoming ID is made of visible ASCII
+// characters, without quotes and backslashes.
+func valid(id string) bool {
+	if len(id) == 0 || len(id) > maxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// errorWriter detects the error responses sent without body, to write
+// one holding the request ID.
+type errorWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+
+	// pending is set until a body is written for an error response.
+	pending bool
+}
+
+// WriteHeader is the "http.ResponseWriter" interface implementation.
+func (w *errorWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = statusCode, true
+
+		headers := w.Header()
+		if statusCode >= http.StatusBadRequest && len(headers.Get("Content-Type")) == 0 &&
+			len(headers.Get("Content-Length")) == 0 && len(headers.Get("Content-Encoding")) == 0 {
+			w.pending = true
+			headers.Set("Content-Type", "text/plain; charset=utf-8")
+			headers.Set("X-Content-Type-Options", "nosniff")
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write is the "http.ResponseWriter" interface implementation.
+func (w *errorWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	if len(p) > 0 {
+		w.pending = false
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush is the "http.Flusher" interface implementation.
+func (w *errorWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
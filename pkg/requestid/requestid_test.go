@@ -0,0 +1,143 @@
+package requestid
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var uuidv7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNew(t *testing.T) {
+	previous := New()
+	assert.Regexp(t, uuidv7, previous)
+
+	for i := 0; i < 100; i++ {
+		id := New()
+		assert.Regexp(t, uuidv7, id)
+		assert.NotEqual(t, previous, id)
+
+		// The timestamp prefix never decreases.
+		assert.True(t, id[:13] >= previous[:13])
+		previous = id
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		header   string
+		incoming string
+		want     string
+	}{
+		{name: "generated", header: DefaultHeader},
+		{name: "untrusted", header: DefaultHeader, incoming: "client-id"},
+		{name: "trusted", opts: []Option{WithTrustIncoming()}, header: DefaultHeader, incoming: "lb-42", want: "lb-42"},
+		{name: "trusted invalid", opts: []Option{WithTrustIncoming()}, header: DefaultHeader, incoming: "a b"},
+		{name: "custom header", opts: []Option{WithHeader("x-correlation-id"), WithTrustIncoming()}, header: "X-Correlation-Id", incoming: "c-1", want: "c-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var forwarded, fromContext string
+			h := NewHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				forwarded = request.Header.Get(test.header)
+				fromContext = FromContext(request.Context())
+			}), test.opts...)
+
+			request := httptest.NewRequest("GET", "/", nil)
+			if len(test.incoming) > 0 {
+				request.Header.Set(test.header, test.incoming)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			id := recorder.Header().Get(test.header)
+			if len(test.want) > 0 {
+				assert.Equal(t, test.want, id)
+			} else {
+				assert.Regexp(t, uuidv7, id)
+			}
+			assert.Equal(t, id, forwarded)
+			assert.Equal(t, id, fromContext)
+		})
+	}
+}
+
+func TestHandler_ErrorBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		next   http.HandlerFunc
+		want   string
+	}{
+		{
+			name:   "empty error",
+			method: "GET",
+			next: func(writer http.ResponseWriter, request *http.Request) {
+				writer.WriteHeader(http.StatusBadGateway)
+			},
+			want: "502 Bad Gateway\nrequest_id: lb-42\n",
+		},
+		{
+			name:   "error with body",
+			method: "GET",
+			next: func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte(`{"error":"bad"}`))
+			},
+			want: `{"error":"bad"}`,
+		},
+		{
+			name:   "HEAD",
+			method: "HEAD",
+			next: func(writer http.ResponseWriter, request *http.Request) {
+				writer.WriteHeader(http.StatusNotFound)
+			},
+		},
+		{
+			name:   "success",
+			method: "GET",
+			next: func(writer http.ResponseWriter, request *http.Request) {
+				writer.WriteHeader(http.StatusNoContent)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, "/", nil)
+			request.Header.Set(DefaultHeader, "lb-42")
+			recorder := httptest.NewRecorder()
+			NewHandler(test.next, WithTrustIncoming()).ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.want, recorder.Body.String())
+		})
+	}
+}
+
+func TestLogHook(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	logger.AddHook(LogHook{})
+
+	h := NewHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		logger.WithContext(request.Context()).Info("handled")
+	}), WithTrustIncoming())
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(DefaultHeader, "lb-42")
+	h.ServeHTTP(httptest.NewRecorder(), request)
+	logger.Info("outside")
+
+	assert.Equal(t, "level=info msg=handled request_id=lb-42\nlevel=info msg=outside\n", out.String())
+}
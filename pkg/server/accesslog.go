@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io"
+	"os"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+)
+
+// OpenAccessLog returns the line format and the output of an access
+// log configuration. The output must be closed by the caller.
+func OpenAccessLog(c *config.AccessLogConfig) (accesslog.Format, io.WriteCloser, error) {
+	format, err := accesslog.ParseFormat(c.Format, c.Fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch c.Output {
+	case "file":
+		f, err := accesslog.OpenRotatingFile(c.File.Path, int64(c.File.MaxSize)<<20, c.File.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		return format, f, nil
+
+	case "syslog":
+		tag := c.Syslog.Tag
+		if len(tag) == 0 {
+			tag = DefaultServiceName
+		}
+
+		w, err := accesslog.DialSyslog(c.Syslog.Network, c.Syslog.Address, tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return format, w, nil
+	}
+
+	return format, nopCloser{os.Stdout}, nil
+}
+
+// nopCloser is a writer whose Close method does nothing.
+type nopCloser struct {
+	io.Writer
+}
+
+// Close is the `io.Closer` interface implementation.
+func (nopCloser) Close() error {
+	return nil
+}
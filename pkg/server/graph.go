@@ -0,0 +1,391 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/accesslog"
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/metrics"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// Graph is the handler graph built from a configuration: the routes
+// with their middleware and the upstream pools.
+type Graph struct {
+	routes   []*Route
+	pools    map[string]*proxy.Pool
+	watchers []*watcher
+	cache    cache.Cache
+	metrics  *metrics.Metrics
+	tracer   *tracing.Tracer
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// mu protects the in-flight requests counter, see acquire.
+	mu       sync.Mutex
+	inFlight int
+	retired  bool
+	drained  chan struct{}
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Graph)(nil)
+
+// Route is a route of the graph.
+type Route struct {
+
+	// Name identifies the route.
+	Name string
+
+	// Host and Path are the matched request host and path prefix.
+	Host string
+	Path string
+
+	// Listeners are the names of the listeners serving the route, all
+	// of them when empty.
+	Listeners []string
+
+	// Proxy is the proxy handler of the route.
+	Proxy *proxy.Handler
+
+	// Split distributes the requests of the route between its upstream
+	// groups. It is nil when the route has a single upstream group.
+	Split *proxy.Split
+
+	// Handler is the proxy handler behind the route middleware.
+	Handler http.Handler
+}
+
+// Option is a function used to modify
+// the graph behavior.
+type Option func(*Graph)
+
+// WithCache sets the cache store of the routes with the cache
+// middleware. A new in-memory cache is used by default.
+func WithCache(c cache.Cache) Option {
+	return func(g *Graph) {
+		g.cache = c
+	}
+}
+
+// WithMetrics records the requests of the routes in the given metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(g *Graph) {
+		g.metrics = m
+	}
+}
+
+// WithTracer traces the requests of the routes with the given tracer.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(g *Graph) {
+		g.tracer = t
+	}
+}
+
+// Build creates the handler graph of a configuration. The discovery
+// is not started until Start is called, so a graph can be built to
+// check a configuration.
+func Build(c *config.Config, opts ...Option) (*Graph, error) {
+	g := &Graph{stop: make(chan struct{}), drained: make(chan struct{})}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if g.cache == nil {
+		g.cache = cache.NewInMemoryCache()
+	}
+
+	if err := g.buildPools(c); err != nil {
+		return nil, err
+	}
+
+	for _, rc := range c.Routes {
+		route, err := g.buildRoute(c, rc)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", rc.Name, err)
+		}
+		g.routes = append(g.routes, route)
+	}
+
+	// The routes with a host are checked first, then the longest
+	// prefixes.
+	sort.SliceStable(g.routes, func(i, j int) bool {
+		a, b := g.routes[i], g.routes[j]
+		if (len(a.Host) > 0) != (len(b.Host) > 0) {
+			return len(a.Host) > 0
+		}
+		return len(a.Path) > len(b.Path)
+	})
+
+	return g, nil
+}
+
+// Routes returns the routes, in matching order.
+func (g *Graph) Routes() []*Route {
+	return g.routes
+}
+
+// Pools returns the pools of the upstream groups.
+func (g *Graph) Pools() map[string]*proxy.Pool {
+	return g.pools
+}
+
+// Cache returns the cache store of the routes.
+func (g *Graph) Cache() cache.Cache {
+	return g.cache
+}
+
+// Start starts the upstream discovery.
+func (g *Graph) Start() {
+	for _, w := range g.watchers {
+		go w.provider.Watch(g.stop, w.pools.Apply)
+	}
+}
+
+// Close stops the upstream discovery. The in-flight requests are not
+// affected.
+func (g *Graph) Close() {
+	g.stopOnce.Do(func() {
+		close(g.stop)
+	})
+}
+
+// acquire counts an in-flight request of the graph. It returns false
+// once the graph is retired, the request has to be served by the
+// current graph then.
+func (g *Graph) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.retired {
+		return false
+	}
+	g.inFlight++
+	return true
+}
+
+// release ends an in-flight request counted by acquire.
+func (g *Graph) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.inFlight--
+	if g.retired && g.inFlight == 0 {
+		close(g.drained)
+	}
+}
+
+// retire stops the counting of new requests, and returns a channel
+// closed once the in-flight ones are finished.
+func (g *Graph) retire() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.retired {
+		g.retired = true
+		if g.inFlight == 0 {
+			close(g.drained)
+		}
+	}
+	return g.drained
+}
+
+// ServeHTTP is the `http.Handler` interface implementation. The
+// request is handled by the first matching route, or answered with a
+// 404 status code.
+func (g *Graph) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	g.serve(writer, request, "")
+}
+
+// Listener returns the handler of the requests received by the named
+// listener, only matching the routes it serves.
+func (g *Graph) Listener(name string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		g.serve(writer, request, name)
+	})
+}
+
+// serve routes a request received by the named listener, or by any
+// listener when empty.
+func (g *Graph) serve(writer http.ResponseWriter, request *http.Request, listener string) {
+	if route := g.match(request, listener); route != nil {
+		accesslog.SetRoute(request.Context(), route.Name)
+		route.Handler.ServeHTTP(writer, request)
+		return
+	}
+
+	logrus.WithContext(request.Context()).WithField("resource", request.URL.String()).Debug("No matching route")
+	writer.WriteHeader(http.StatusNotFound)
+}
+
+// match returns the route of the request, or nil.
+func (g *Graph) match(request *http.Request, listener string) *Route {
+	host := request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, route := range g.routes {
+		if len(listener) > 0 && !route.servedBy(listener) {
+			continue
+		}
+		if len(route.Host) > 0 && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if strings.HasPrefix(request.URL.Path, route.Path) {
+			return route
+		}
+	}
+
+	return nil
+}
+
+// servedBy reports whether the route is served by the named listener.
+func (r *Route) servedBy(listener string) bool {
+	if len(r.Listeners) == 0 {
+		return true
+	}
+	for _, name := range r.Listeners {
+		if name == listener {
+			return true
+		}
+	}
+	return false
+}
+
+// buildRoute creates the handler chain of a route.
+func (g *Graph) buildRoute(c *config.Config, rc *config.RouteConfig) (*Route, error) {
+	selector, split, err := g.selector(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []proxy.Option
+	if c.Transport.InsecureSkipVerify {
+		opts = append(opts, proxy.WithInsecure())
+	}
+	if g.metrics != nil {
+		opts = append(opts, proxy.WithMetrics(g.metrics.Proxy(rc.Name)))
+	}
+	if g.tracer != nil {
+		opts = append(opts, proxy.WithTracer(g.tracer))
+	}
+
+	if m := rc.Mirror; m != nil {
+		target, err := url.Parse(m.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		mirror := proxy.MirrorConfig{
+			Target:        target,
+			Percentage:    100,
+			MaxConcurrent: m.MaxConcurrent,
+			MaxBodySize:   m.MaxBodySize,
+			Timeout:       m.Timeout,
+		}
+		if m.Percentage != nil {
+			mirror.Percentage = *m.Percentage
+		}
+		if m.Compare != nil {
+			mirror.Compare = &proxy.CompareConfig{
+				Headers:     m.Compare.Headers,
+				IgnorePaths: m.Compare.IgnorePaths,
+				Routes:      m.Compare.Routes,
+				MaxBodySize: m.Compare.MaxBodySize,
+			}
+		}
+
+		opts = append(opts, proxy.WithMirror(mirror))
+	}
+
+	route := &Route{
+		Name:      rc.Name,
+		Host:      rc.Host,
+		Path:      rc.Path,
+		Listeners: rc.Listeners,
+		Proxy:     proxy.NewBalanced(selector, opts...),
+		Split:     split,
+	}
+
+	route.Handler, err = g.middleware(rc.Name, c.Middleware.Merge(rc.Middleware), route.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return route, nil
+}
+
+// selector creates the upstream selector of a route, and returns its
+// split when the route has several upstream groups.
+func (g *Graph) selector(rc *config.RouteConfig) (proxy.Selector, *proxy.Split, error) {
+	var selector proxy.Selector
+	var split *proxy.Split
+	if len(rc.Upstream) > 0 {
+		pool, ok := g.pools[rc.Upstream]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown upstream %q", rc.Upstream)
+		}
+		selector = pool
+	} else {
+		overrides := make([]proxy.Override, len(rc.Overrides))
+		for i, o := range rc.Overrides {
+			overrides[i] = proxy.Override(o)
+		}
+
+		split = proxy.NewSplit(overrides...)
+		for _, name := range sortedKeys(rc.Split) {
+			pool, ok := g.pools[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown upstream %q", name)
+			}
+			split.AddGroup(name, pool, rc.Split[name])
+		}
+		selector = split
+	}
+
+	if a := rc.Affinity; a != nil {
+		affinity := proxy.AffinityConfig{
+			CookieName: a.Cookie,
+			Secret:     []byte(a.Secret),
+			TTL:        a.TTL,
+			Path:       a.Path,
+			Domain:     a.Domain,
+			Secure:     a.Secure,
+			HTTPOnly:   a.HTTPOnly == nil || *a.HTTPOnly,
+		}
+
+		switch strings.ToLower(a.SameSite) {
+		case "lax":
+			affinity.SameSite = http.SameSiteLaxMode
+		case "strict":
+			affinity.SameSite = http.SameSiteStrictMode
+		case "none":
+			affinity.SameSite = http.SameSiteNoneMode
+		}
+
+		selector = proxy.NewSticky(selector, affinity)
+	}
+
+	return selector, split, nil
+}
+
+// sortedKeys returns the keys of the split weights in order.
+func sortedKeys(weights map[string]int) []string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
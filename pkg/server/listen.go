@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+)
+
+// Listen opens the socket of a listener: a TCP address, or a Unix
+// socket whose file is created with the configured mode. A stale
+// socket file, left by a process that did not close it, is replaced.
+func Listen(l *config.ListenerConfig) (net.Listener, error) {
+	path := l.SocketPath()
+	if len(path) == 0 {
+		return net.Listen("tcp", l.Address)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("listen unix %s: address already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.SocketMode) > 0 {
+		mode, err := l.FileMode()
+		if err == nil {
+			err = os.Chmod(path, mode)
+		}
+		if err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/clientcert"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+)
+
+// ListenerHandler returns the handler of the requests received by a
+// listener of the configuration: the redirect to HTTPS of a redirect
+// listener, or the routes of the listener with its HSTS header. The
+// routes receive the client certificate headers, set by the listener
+// only: the ones of every listener are removed from the requests.
+func ListenerHandler(c *config.Config, l *config.ListenerConfig, routes http.Handler) http.Handler {
+	if l.Redirect != nil {
+		return redirectHandler(*l.Redirect)
+	}
+
+	var strip []string
+	for i := range c.Listeners {
+		headers := clientCertHeaders(&c.Listeners[i])
+		strip = append(strip, headers.Subject, headers.SANs, headers.Fingerprint)
+	}
+
+	routes = clientcert.Forward(clientCertHeaders(l), routes, strip...)
+	if l.HSTS != nil {
+		return &hstsHandler{value: hstsValue(l.HSTS), next: routes}
+	}
+	return routes
+}
+
+// clientCertHeaders returns the headers the client certificates of a
+// listener are forwarded in, the default ones without client
+// authentication.
+func clientCertHeaders(l *config.ListenerConfig) clientcert.Headers {
+	headers := clientcert.DefaultHeaders
+	if l.TLS == nil || l.TLS.ClientAuth == nil {
+		return headers
+	}
+
+	c := l.TLS.ClientAuth.Headers
+	if len(c.Subject) > 0 {
+		headers.Subject = c.Subject
+	}
+	if len(c.SANs) > 0 {
+		headers.SANs = c.SANs
+	}
+	if len(c.Fingerprint) > 0 {
+		headers.Fingerprint = c.Fingerprint
+	}
+	return headers
+}
+
+// redirectHandler redirects the requests to the same URL with HTTPS.
+func redirectHandler(c config.RedirectConfig) http.Handler {
+	status := c.Status
+	if status == 0 {
+		status = http.StatusPermanentRedirect
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			// IPv6 address without port.
+			host = host[1 : len(host)-1]
+		}
+		if len(host) == 0 {
+			http.Error(writer, "Host header required", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if c.Port != 0 && c.Port != 443 {
+			host += ":" + strconv.Itoa(c.Port)
+		}
+
+		http.Redirect(writer, request, "https://"+host+request.URL.RequestURI(), status)
+	})
+}
+
+// hstsValue returns the Strict-Transport-Security header value.
+func hstsValue(c *config.HSTSConfig) string {
+	value := "max-age=" + strconv.FormatInt(int64(c.MaxAge.Seconds()), 10)
+	if c.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if c.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// hstsHandler sets the Strict-Transport-Security header of the
+// responses, replacing the one of the upstreams.
+type hstsHandler struct {
+	value string
+	next  http.Handler
+}
+
+// ServeHTTP is the `http.Handler` interface implementation.
+func (h *hstsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	h.next.ServeHTTP(&hstsWriter{ResponseWriter: writer, value: h.value}, request)
+}
+
+// hstsWriter sets the header before the response headers are written.
+type hstsWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+// WriteHeader is the "http.ResponseWriter" interface implementation.
+func (w *hstsWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Strict-Transport-Security", w.value)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write is the "http.ResponseWriter" interface implementation.
+func (w *hstsWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush is the "http.Flusher" interface implementation.
+func (w *hstsWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/basicauth"
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/clientcert"
+	"github.com/moutoum/http-reverse-proxy/pkg/compress"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/cors"
+	"github.com/moutoum/http-reverse-proxy/pkg/forwardauth"
+	"github.com/moutoum/http-reverse-proxy/pkg/jwt"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+)
+
+// DefaultJWKSRefresh is the default refresh interval of the JWKS keys.
+const DefaultJWKSRefresh = time.Hour
+
+// middleware puts the enabled middleware in front of the handler of
+// the named route.
+//
+// The compression is placed behind the cache, so the compressed
+// variants are cached. The CORS is the last one, so the preflight
+// requests are answered without authentication. The server span
+// covers the whole chain.
+func (g *Graph) middleware(route string, m config.MiddlewareConfig, h http.Handler) (http.Handler, error) {
+	var err error
+
+	if c := m.Compression; c != nil && !c.Disabled {
+		var opts []compress.Option
+		if len(c.Encodings) > 0 {
+			opts = append(opts, compress.WithEncodings(c.Encodings...))
+		}
+		if len(c.ContentTypes) > 0 {
+			opts = append(opts, compress.WithContentTypes(c.ContentTypes...))
+		}
+		if c.MinSize != nil {
+			opts = append(opts, compress.WithMinSize(*c.MinSize))
+		}
+
+		if h, err = compress.NewHandler(h, opts...); err != nil {
+			return nil, err
+		}
+	}
+
+	if c := m.Cache; c != nil && !c.Disabled {
+		// The routes share the cache storage, each one in its namespace.
+		opts := []cache.Option{cache.WithNamespace(route)}
+		if g.metrics != nil {
+			opts = append(opts, cache.WithMetrics(g.metrics.Cache(route)))
+		}
+		if g.tracer != nil {
+			opts = append(opts, cache.WithTracer(g.tracer))
+		}
+
+		h = cache.NewHandler(g.cache, h, opts...)
+	}
+
+	if b := m.BasicAuth; b != nil && !b.Disabled {
+		users, err := basicauth.NewFile(b.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []basicauth.Option
+		for _, r := range b.Routes {
+			realm := r.Realm
+			if len(realm) == 0 {
+				realm = basicauth.DefaultRealm
+			}
+			opts = append(opts, basicauth.WithRoute(r.Prefix, realm))
+		}
+		if len(b.Routes) == 0 && len(b.Realm) > 0 {
+			opts = append(opts, basicauth.WithRoute("/", b.Realm))
+		}
+
+		h = basicauth.NewHandler(users, h, opts...)
+	}
+
+	if f := m.ForwardAuth; f != nil && !f.Disabled {
+		address, err := url.Parse(f.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		requestHeaders := f.RequestHeaders
+		if len(requestHeaders) == 0 {
+			requestHeaders = []string{"Authorization", "Cookie"}
+		}
+
+		h = forwardauth.NewHandler(address, h,
+			forwardauth.WithRequestHeaders(requestHeaders...),
+			forwardauth.WithResponseHeaders(f.ResponseHeaders...),
+		)
+	}
+
+	if j := m.JWT; j != nil && !j.Disabled {
+		refresh := j.Refresh
+		if refresh <= 0 {
+			refresh = DefaultJWKSRefresh
+		}
+
+		keys, err := jwt.NewJWKS(j.JWKS, refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		validator := &jwt.Validator{
+			Keys:      keys,
+			Issuer:    j.Issuer,
+			Audiences: j.Audiences,
+			Leeway:    30 * time.Second,
+		}
+
+		var opts []jwt.Option
+		for _, r := range j.Require {
+			opts = append(opts, jwt.WithRequiredClaims(r.Prefix, r.Claims...))
+		}
+		for claim, header := range j.ForwardClaims {
+			opts = append(opts, jwt.WithClaimHeader(claim, header))
+		}
+		if j.StripToken {
+			opts = append(opts, jwt.WithStripToken())
+		}
+
+		h = jwt.NewHandler(validator, h, opts...)
+	}
+
+	if c := m.ClientCert; c != nil && !c.Disabled {
+		h = clientcert.NewHandler(h,
+			clientcert.WithCommonNames(c.CommonNames...),
+			clientcert.WithSANs(c.SANs...),
+		)
+	}
+
+	if c := m.CORS; c != nil && !c.Disabled {
+		if h, err = cors.NewHandler(c.Policy(), h); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.tracer != nil {
+		h = tracing.NewHandler(g.tracer, route, h)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/cache"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWatchInterval is the default check interval of the
+// configuration file.
+const DefaultWatchInterval = 2 * time.Second
+
+// DrainTimeout is how long the previous graph is kept after a reload
+// for its in-flight requests, before its discovery and its replaced
+// tracer are closed.
+const DrainTimeout = 30 * time.Second
+
+// Reloader serves the requests with the current handler graph, which
+// is replaced when the configuration is reloaded.
+//
+// A request is handled by the graph current when it arrived: the
+// in-flight requests finish on the previous graph after a reload, which
+// is closed once they are done.
+type Reloader struct {
+	load func() (*config.Config, error)
+	opts []Option
+
+	// mu serializes the reloads.
+	mu     sync.Mutex
+	config *config.Config
+	tracer *tracing.Tracer
+	graph  atomic.Value
+
+	// retiring counts the previous graphs not closed yet.
+	retiring sync.WaitGroup
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Reloader)(nil)
+
+// NewReloader builds and starts the handler graph of the configuration
+// returned by load. The function is called again on each reload.
+//
+// The options are applied to every graph. The cache store is shared by
+// the graphs, so the cached responses survive the reloads. The tracer
+// is created from the configuration, and replaced when it changes.
+func NewReloader(load func() (*config.Config, error), opts ...Option) (*Reloader, error) {
+	r := &Reloader{
+		load: load,
+		opts: append([]Option{WithCache(cache.NewInMemoryCache())}, opts...),
+	}
+
+	c, graph, tracer, err := r.build()
+	if err != nil {
+		return nil, err
+	}
+
+	r.config = c
+	r.tracer = tracer
+	r.graph.Store(graph)
+	graph.Start()
+	return r, nil
+}
+
+// Config returns the current configuration.
+func (r *Reloader) Config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// Graph returns the current handler graph.
+func (r *Reloader) Graph() *Graph {
+	return r.graph.Load().(*Graph)
+}
+
+// Reload loads, validates and builds the configuration, then swaps the
+// handler graph. On error, the current graph is kept. The health and
+// drain states of the upstreams are kept across the reloads.
+//
+// The listeners, the request IDs and the access log are not part of
+// the graph: their changes are only applied on restart.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, graph, tracer, err := r.build()
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(c.Listeners, r.config.Listeners) {
+		logrus.Warn("Listener changes are applied on restart only")
+	}
+	if !reflect.DeepEqual(c.AccessLog, r.config.AccessLog) || c.RequestID != r.config.RequestID {
+		logrus.Warn("Access log and request ID changes are applied on restart only")
+	}
+
+	previous := r.Graph()
+	inherit(graph, previous)
+	graph.Start()
+	r.graph.Store(graph)
+	r.config = c
+
+	var previousTracer *tracing.Tracer
+	if tracer != r.tracer {
+		previousTracer, r.tracer = r.tracer, tracer
+	}
+	r.retiring.Add(1)
+	go r.retire(previous, previousTracer)
+
+	logrus.WithField("routes", len(graph.Routes())).Info("Configuration reloaded")
+	return nil
+}
+
+// build loads and validates the configuration, and creates its graph
+// with the current tracer, or a new one if its configuration changed.
+func (r *Reloader) build() (*config.Config, *Graph, *tracing.Tracer, error) {
+	c, err := r.load()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	tracer := r.tracer
+	if r.config == nil || !reflect.DeepEqual(c.Tracing, r.config.Tracing) {
+		if tracer, err = NewTracer(c.Tracing); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	opts := append(append([]Option(nil), r.opts...), WithTracer(tracer))
+	graph, err := Build(c, opts...)
+	if err != nil {
+		if tracer != r.tracer {
+			_ = tracer.Close()
+		}
+		return nil, nil, nil, err
+	}
+
+	return c, graph, tracer, nil
+}
+
+// retire closes the previous graph, and its tracer if it was replaced,
+// once the in-flight requests of the graph are finished or after the
+// DrainTimeout, so their spans are still exported.
+func (r *Reloader) retire(graph *Graph, tracer *tracing.Tracer) {
+	defer r.retiring.Done()
+
+	select {
+	case <-graph.retire():
+	case <-time.After(DrainTimeout):
+		logrus.Warn("Previous configuration closed with in-flight requests")
+	}
+
+	graph.Close()
+	if tracer != nil {
+		if err := tracer.Close(); err != nil {
+			logrus.WithError(err).Error("Error while closing the previous tracer")
+		}
+	}
+}
+
+// inherit copies the health and drain states of the upstreams of the
+// previous graph that are still in the same groups.
+func inherit(graph, previous *Graph) {
+	for name, pool := range graph.pools {
+		old, ok := previous.pools[name]
+		if !ok {
+			continue
+		}
+
+		states := make(map[string]*proxy.Upstream)
+		for _, u := range old.Upstreams() {
+			states[u.URL.String()] = u
+		}
+
+		for _, u := range pool.Upstreams() {
+			if state, ok := states[u.URL.String()]; ok {
+				u.CopyState(state)
+			}
+		}
+	}
+}
+
+// Close stops the current graph, and flushes the tracer. The previous
+// graphs are waited for, see DrainTimeout.
+func (r *Reloader) Close() {
+	r.Graph().Close()
+	r.retiring.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.tracer.Close(); err != nil {
+		logrus.WithError(err).Error("Error while closing the tracer")
+	}
+}
+
+// ServeHTTP is the `http.Handler` interface implementation.
+func (r *Reloader) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	r.serve(writer, request, "")
+}
+
+// Listener returns the handler of the named listener, routing the
+// requests with the current graph.
+func (r *Reloader) Listener(name string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		r.serve(writer, request, name)
+	})
+}
+
+// serve routes a request with the current graph, counted as one of
+// its in-flight requests.
+func (r *Reloader) serve(writer http.ResponseWriter, request *http.Request, listener string) {
+	for {
+		// A retired graph has already been replaced.
+		graph := r.Graph()
+		if graph.acquire() {
+			defer graph.release()
+			graph.serve(writer, request, listener)
+			return
+		}
+	}
+}
+
+// WatchFile calls changed each time the modification time or the size
+// of the file changes, until stop is closed.
+func WatchFile(path string, interval time.Duration, stop <-chan struct{}, changed func()) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			logrus.WithError(err).WithField("path", path).Error("Error while checking configuration file")
+			continue
+		}
+
+		if info.ModTime().Equal(modTime) && info.Size() == size {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+
+		changed()
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/acme"
+	"github.com/moutoum/http-reverse-proxy/pkg/certstore"
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+)
+
+// CertificateStore loads the certificates of a TLS listener.
+func CertificateStore(c *config.TLSConfig) (*certstore.Store, error) {
+	var opts []certstore.Option
+	if len(c.Certificate) > 0 {
+		opts = append(opts, certstore.WithCertificate(c.Certificate, c.Key))
+	}
+	for _, pair := range c.Certificates {
+		opts = append(opts, certstore.WithCertificate(pair.Certificate, pair.Key))
+	}
+	if len(c.Directory) > 0 {
+		opts = append(opts, certstore.WithDirectory(c.Directory))
+	}
+	if len(c.Default) > 0 {
+		opts = append(opts, certstore.WithDefault(c.Default))
+	}
+
+	return certstore.New(opts...)
+}
+
+// ListenerTLS holds the certificates of a TLS listener: the ones of its
+// files, and the ones obtained with ACME.
+type ListenerTLS struct {
+
+	// Store holds the certificates of the files, nil without files.
+	Store *certstore.Store
+
+	// ACME obtains the certificates of its domains, nil when disabled.
+	ACME *acme.Manager
+
+	// ClientCAs verifies the client certificates, nil without client
+	// authentication.
+	ClientCAs *x509.CertPool
+
+	// ClientAuth is the policy of the client certificates.
+	ClientAuth tls.ClientAuthType
+}
+
+// Static implementation checker.
+var _ certstore.Lister = (*ListenerTLS)(nil)
+
+// NewListenerTLS loads the certificates of a TLS listener.
+func NewListenerTLS(c *config.TLSConfig) (*ListenerTLS, error) {
+	t := &ListenerTLS{}
+
+	if len(c.Certificate) > 0 || len(c.Certificates) > 0 || len(c.Directory) > 0 {
+		store, err := CertificateStore(c)
+		if err != nil {
+			return nil, err
+		}
+		t.Store = store
+	}
+
+	if c.ACME != nil {
+		manager, err := ACMEManager(c.ACME)
+		if err != nil {
+			return nil, err
+		}
+		t.ACME = manager
+	}
+
+	if a := c.ClientAuth; a != nil {
+		pool, err := certPool(a.CA)
+		if err != nil {
+			return nil, fmt.Errorf("client auth: %w", err)
+		}
+		t.ClientCAs = pool
+		t.ClientAuth = tls.VerifyClientCertIfGiven
+		if a.Mode == config.ClientAuthRequired {
+			t.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return t, nil
+}
+
+// ACMEManager creates the manager of the ACME certificates.
+func ACMEManager(c *config.ACMEConfig) (*acme.Manager, error) {
+	opts := []acme.Option{acme.WithEmail(c.Email), acme.WithRenewBefore(c.RenewBefore)}
+	if len(c.DirectoryURL) > 0 {
+		opts = append(opts, acme.WithDirectoryURL(c.DirectoryURL))
+	}
+	if len(c.Challenges) > 0 {
+		opts = append(opts, acme.WithChallenges(c.Challenges...))
+	}
+
+	if len(c.CARoot) > 0 {
+		pool, err := certPool(c.CARoot)
+		if err != nil {
+			return nil, fmt.Errorf("acme: %w", err)
+		}
+		opts = append(opts, acme.WithRootCAs(pool))
+	}
+
+	return acme.New(c.Storage, c.Domains, opts...), nil
+}
+
+// certPool reads the certificates of a PEM file.
+func certPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %q", file)
+	}
+	return pool, nil
+}
+
+// Config returns the TLS configuration of the listener server.
+func (t *ListenerTLS) Config() *tls.Config {
+	tlsConfig := &tls.Config{
+		GetCertificate: t.getCertificate,
+		ClientCAs:      t.ClientCAs,
+		ClientAuth:     t.ClientAuth,
+	}
+	if t.ACME == nil {
+		return tlsConfig
+	}
+
+	tlsConfig.NextProtos = t.ACME.NextProtos()
+	if t.ClientAuth != tls.NoClientCert {
+		// The CA validating a domain with the TLS-ALPN-01 challenge has
+		// no client certificate.
+		challenge := tlsConfig.Clone()
+		challenge.ClientCAs, challenge.ClientAuth = nil, tls.NoClientCert
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if acme.IsChallenge(hello) {
+				return challenge, nil
+			}
+			return nil, nil
+		}
+	}
+	return tlsConfig
+}
+
+// getCertificate returns the ACME certificate of the domains of the
+// ACME manager, and the certificate of the files otherwise.
+func (t *ListenerTLS) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if t.ACME != nil && (t.Store == nil || t.ACME.Handles(hello.ServerName)) {
+		return t.ACME.GetCertificate(hello)
+	}
+	return t.Store.GetCertificate(hello)
+}
+
+// Certificates is the `certstore.Lister` interface implementation.
+func (t *ListenerTLS) Certificates() []certstore.Info {
+	var infos []certstore.Info
+	if t.Store != nil {
+		infos = append(infos, t.Store.Certificates()...)
+	}
+	if t.ACME != nil {
+		infos = append(infos, t.ACME.Certificates()...)
+	}
+	return infos
+}
+
+// Watch reloads the certificate files when they change, until stop is
+// closed.
+func (t *ListenerTLS) Watch(interval time.Duration, stop <-chan struct{}) {
+	if t.Store != nil {
+		t.Store.Watch(interval, stop)
+	}
+}
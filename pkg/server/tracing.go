@@ -0,0 +1,55 @@
+package server
+
+import (
+	"os"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/tracing"
+)
+
+// DefaultServiceName is the service name of the spans.
+const DefaultServiceName = "proxy-server"
+
+// NewTracer creates the tracer of a configuration. It returns nil when
+// the tracing is disabled.
+func NewTracer(c config.TracingConfig) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch c.Exporter {
+	case "":
+		return nil, nil
+
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+
+	case "otlp":
+		serviceName := c.ServiceName
+		if len(serviceName) == 0 {
+			serviceName = DefaultServiceName
+		}
+
+		var opts []tracing.OTLPOption
+		for name, value := range c.Headers {
+			opts = append(opts, tracing.WithHeader(name, value))
+		}
+		exporter = tracing.NewOTLPExporter(c.Endpoint, serviceName, opts...)
+	}
+
+	var opts []tracing.Option
+	if c.SampleRate != nil {
+		opts = append(opts, tracing.WithSampler(tracing.RatioSampler(*c.SampleRate)))
+	}
+
+	if len(c.Propagation) > 0 {
+		propagators := make([]tracing.Propagator, len(c.Propagation))
+		for i, name := range c.Propagation {
+			p, err := tracing.ParsePropagator(name)
+			if err != nil {
+				return nil, err
+			}
+			propagators[i] = p
+		}
+		opts = append(opts, tracing.WithPropagators(propagators...))
+	}
+
+	return tracing.NewTracer(exporter, opts...), nil
+}
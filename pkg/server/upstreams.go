@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/moutoum/http-reverse-proxy/pkg/config"
+	"github.com/moutoum/http-reverse-proxy/pkg/discovery"
+	"github.com/moutoum/http-reverse-proxy/pkg/proxy"
+)
+
+// watcher is a discovery provider with the pools it updates.
+type watcher struct {
+	provider discovery.Provider
+	pools    *discovery.Pools
+}
+
+// buildPools creates the pools of the upstream groups, and the
+// discovery watchers updating them.
+func (g *Graph) buildPools(c *config.Config) error {
+	groups := make(map[string][]*proxy.Upstream)
+	for name, u := range c.Upstreams {
+		groups[name] = nil
+		if u == nil {
+			continue
+		}
+
+		for _, t := range u.Targets {
+			target, err := url.Parse(t.URL)
+			if err != nil {
+				return fmt.Errorf("upstream %q: %w", name, err)
+			}
+
+			upstream := proxy.NewUpstream(target)
+			upstream.Weight = t.Weight
+			groups[name] = append(groups[name], upstream)
+		}
+	}
+
+	var watchers []*watcher
+	owners := make(map[string]discovery.Provider)
+
+	// The groups of the discovery file replace the static targets.
+	if len(c.Discovery.File) > 0 {
+		file := discovery.NewFile(c.Discovery.File, c.Discovery.Interval)
+		discovered, err := file.Groups()
+		if err != nil {
+			return err
+		}
+
+		for name, upstreams := range discovered {
+			groups[name] = upstreams
+			owners[name] = file
+		}
+
+		watchers = append(watchers, &watcher{provider: file})
+	}
+
+	var dns *discovery.DNS
+	for _, name := range sortedNames(c.Upstreams) {
+		u := c.Upstreams[name]
+		if u == nil || len(u.DNS) == 0 {
+			continue
+		}
+
+		if _, ok := owners[name]; ok {
+			return fmt.Errorf("upstream %q is discovered twice", name)
+		}
+
+		if dns == nil {
+			var err error
+			if dns, err = discovery.NewDNS(c.Discovery.DNS.Server, c.Discovery.DNS.Interval); err != nil {
+				return err
+			}
+			watchers = append(watchers, &watcher{provider: dns})
+		}
+
+		for _, value := range u.DNS {
+			target, err := url.Parse(value)
+			if err != nil {
+				return fmt.Errorf("upstream %q: %w", name, err)
+			}
+			if err := dns.Add(name, target); err != nil {
+				return err
+			}
+		}
+		owners[name] = dns
+	}
+
+	if dns != nil {
+		discovered, err := dns.Groups()
+		if err != nil {
+			return err
+		}
+
+		for name, upstreams := range discovered {
+			groups[name] = upstreams
+		}
+	}
+
+	g.pools = make(map[string]*proxy.Pool, len(groups))
+	for name, upstreams := range groups {
+		lb := c.LoadBalancing
+		if u := c.Upstreams[name]; u != nil && u.LoadBalancing != nil {
+			lb = *u.LoadBalancing
+		}
+
+		pool, err := newPool(lb, upstreams)
+		if err != nil {
+			return fmt.Errorf("upstream %q: %w", name, err)
+		}
+		g.pools[name] = pool
+	}
+
+	for _, w := range watchers {
+		w.pools = discovery.NewPools()
+		for name, owner := range owners {
+			if owner == w.provider {
+				w.pools.Add(name, g.pools[name])
+			}
+		}
+	}
+
+	g.watchers = watchers
+	return nil
+}
+
+// newPool creates a pool with the configured load balancing.
+func newPool(lb config.LoadBalancingConfig, upstreams []*proxy.Upstream) (*proxy.Pool, error) {
+	pool := proxy.NewPool(upstreams...).WithFailTimeout(lb.MaxFails, lb.FailTimeout)
+
+	switch lb.Algorithm {
+	case "", "round-robin":
+		return pool, nil
+
+	case "consistent-hash":
+		hashKey := lb.HashKey
+		if len(hashKey) == 0 {
+			hashKey = "ip"
+		}
+
+		key, err := proxy.ParseHashKey(hashKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return pool.WithBalancer(proxy.NewConsistentHash(key, lb.VirtualNodes)), nil
+	}
+
+	return nil, fmt.Errorf("unknown load balancing algorithm %q", lb.Algorithm)
+}
+
+// sortedNames returns the names of the upstream groups in order.
+func sortedNames(upstreams map[string]*config.UpstreamConfig) []string {
+	names := make([]string, 0, len(upstreams))
+	for name := range upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
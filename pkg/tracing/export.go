@@ -0,0 +1,348 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBatchSize is the number of spans sent at once to the
+	// collector.
+	DefaultBatchSize = 512
+
+	// DefaultFlushInterval is the maximum time a span waits before
+	// being sent to the collector.
+	DefaultFlushInterval = 5 * time.Second
+
+	// maxQueueSize is the number of queued spans above which the new
+	// ones are dropped, when the collector is too slow.
+	maxQueueSize = 8 * DefaultBatchSize
+)
+
+// WriterExporter is an Exporter writing the spans as JSON lines, in
+// the OTLP span encoding.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter creates an exporter writing to w (e.g. os.Stdout).
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// Export is the `Exporter` interface implementation.
+func (e *WriterExporter) Export(span *SpanData) {
+	data, err := json.Marshal(newOTLPSpan(span))
+	if err != nil {
+		logrus.WithError(err).Error("Error while encoding span")
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).Error("Error while writing span")
+	}
+}
+
+// Close is the `Exporter` interface implementation.
+func (e *WriterExporter) Close() error {
+	return nil
+}
+
+// OTLPExporter is an Exporter sending the spans in batches to an
+// OpenTelemetry collector, with the OTLP/HTTP JSON protocol.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	headers     http.Header
+
+	mu    sync.Mutex
+	queue []*SpanData
+
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// OTLPOption is a function used to modify
+// the OTLP exporter behavior.
+type OTLPOption func(*OTLPExporter)
+
+// WithHeader adds a header to the export requests (e.g. an API key).
+func WithHeader(name, value string) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.headers.Add(name, value)
+	}
+}
+
+// WithHTTPClient sets the client of the export requests.
+func WithHTTPClient(client *http.Client) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.client = client
+	}
+}
+
+// NewOTLPExporter creates an exporter sending the spans to the traces
+// endpoint of a collector (e.g. "http://localhost:4318/v1/traces").
+// The spans are sent every DefaultFlushInterval, or as soon as
+// DefaultBatchSize spans are queued.
+func NewOTLPExporter(endpoint, serviceName string, opts ...OTLPOption) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		headers:     make(http.Header),
+		flush:       make(chan chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	go e.run()
+	return e
+}
+
+// Export is the `Exporter` interface implementation.
+func (e *OTLPExporter) Export(span *SpanData) {
+	e.mu.Lock()
+	if len(e.queue) >= maxQueueSize {
+		e.mu.Unlock()
+		logrus.Warn("Dropping span, the trace collector is too slow")
+		return
+	}
+	e.queue = append(e.queue, span)
+	full := len(e.queue) >= DefaultBatchSize
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.flush <- nil:
+		default:
+		}
+	}
+}
+
+// Flush sends the queued spans and waits for the end of the export.
+func (e *OTLPExporter) Flush() {
+	done := make(chan struct{})
+	select {
+	case e.flush <- done:
+		<-done
+	case <-e.done:
+	}
+}
+
+// Close is the `Exporter` interface implementation.
+func (e *OTLPExporter) Close() error {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	<-e.done
+	return nil
+}
+
+// run sends the queued spans periodically.
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.send()
+		case done := <-e.flush:
+			e.send()
+			if done != nil {
+				close(done)
+			}
+		case <-e.stop:
+			e.send()
+			return
+		}
+	}
+}
+
+// send exports the queued spans, in batches.
+func (e *OTLPExporter) send() {
+	for {
+		e.mu.Lock()
+		n := len(e.queue)
+		if n > DefaultBatchSize {
+			n = DefaultBatchSize
+		}
+		batch := e.queue[:n]
+		e.queue = e.queue[n:]
+		e.mu.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := e.post(batch); err != nil {
+			logrus.WithError(err).WithField("spans", len(batch)).Error("Error while exporting spans")
+		}
+	}
+}
+
+// post sends a batch of spans to the collector.
+func (e *OTLPExporter) post(batch []*SpanData) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		spans[i] = newOTLPSpan(span)
+	}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{newOTLPAttribute(String("service.name", e.serviceName))}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/moutoum/http-reverse-proxy"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("tracing: collector answered with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of the spans, the IDs being hexadecimal.
+//
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		TraceState        string          `json:"traceState,omitempty"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func newOTLPSpan(span *SpanData) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.SpanContext.TraceID.String(),
+		SpanID:            span.SpanContext.SpanID.String(),
+		TraceState:        span.SpanContext.TraceState,
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: span.Status, Message: span.Message},
+	}
+
+	if span.ParentSpanID.IsValid() {
+		s.ParentSpanID = span.ParentSpanID.String()
+	}
+
+	for _, attribute := range span.Attributes {
+		s.Attributes = append(s.Attributes, newOTLPAttribute(attribute))
+	}
+
+	return s
+}
+
+func newOTLPAttribute(attribute Attribute) otlpAttribute {
+	a := otlpAttribute{Key: attribute.Key}
+
+	switch v := attribute.Value.(type) {
+	case string:
+		a.Value.StringValue = &v
+	case bool:
+		a.Value.BoolValue = &v
+	case int:
+		i := strconv.Itoa(v)
+		a.Value.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &i
+	case float64:
+		a.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+
+	return a
+}
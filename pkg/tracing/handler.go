@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Handler is a http.Handler creating the server span of the requests,
+// child of the span context propagated by the client.
+type Handler struct {
+	tracer *Tracer
+	route  string
+	next   http.Handler
+}
+
+// Static implementation checker.
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler creates a tracing middleware for the named route.
+func NewHandler(tracer *Tracer, route string, next http.Handler) *Handler {
+	return &Handler{tracer: tracer, route: route, next: next}
+}
+
+// ServeHTTP is the `http.Handler` interface implementation.
+func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ctx := h.tracer.Extract(request.Context(), request.Header)
+	ctx, span := h.tracer.Start(ctx, request.Method+" "+h.route, SpanKindServer,
+		String("http.method", request.Method),
+		String("http.target", request.URL.RequestURI()),
+		String("http.host", request.Host),
+		String("http.route", h.route),
+		String("http.user_agent", request.UserAgent()),
+		String("net.peer.addr", request.RemoteAddr),
+	)
+	defer span.End()
+
+	w := &statusWriter{ResponseWriter: writer, status: http.StatusOK}
+	h.next.ServeHTTP(w, request.WithContext(ctx))
+
+	span.SetAttributes(Int("http.status_code", w.status))
+	if w.status >= http.StatusInternalServerError {
+		span.SetStatus(StatusError, strconv.Itoa(w.status)+" "+http.StatusText(w.status))
+	}
+}
+
+// statusWriter records the response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader is the "http.ResponseWriter" interface implementation.
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = statusCode, true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write is the "http.ResponseWriter" interface implementation.
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush is the "http.Flusher" interface implementation.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
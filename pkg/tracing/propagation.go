@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Propagator reads and writes the span context in the request
+// headers.
+type Propagator interface {
+
+	// Extract returns the span context of the headers, if any.
+	Extract(header http.Header) (SpanContext, bool)
+
+	// Inject writes the span context in the headers.
+	Inject(sc SpanContext, header http.Header)
+}
+
+// ParsePropagator returns the propagator of a format name: "w3c" or
+// "b3".
+func ParsePropagator(name string) (Propagator, error) {
+	switch strings.ToLower(name) {
+	case "w3c", "tracecontext":
+		return W3C{}, nil
+	case "b3":
+		return B3{}, nil
+	}
+
+	return nil, fmt.Errorf("tracing: unknown propagation format %q", name)
+}
+
+// Extract returns a context holding the span context of the headers,
+// read with the first propagator able to.
+func (t *Tracer) Extract(ctx context.Context, header http.Header) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	for _, p := range t.propagators {
+		if sc, ok := p.Extract(header); ok {
+			return ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+
+	return ctx
+}
+
+// Inject writes the span context of the context in the headers, with
+// all the propagators.
+func (t *Tracer) Inject(ctx context.Context, header http.Header) {
+	if t == nil {
+		return
+	}
+
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	for _, p := range t.propagators {
+		p.Inject(sc, header)
+	}
+}
+
+// W3C is the Propagator of the W3C Trace Context headers,
+// "traceparent" and "tracestate".
+//
+// See https://www.w3.org/TR/trace-context/
+type W3C struct{}
+
+// Extract is the `Propagator` interface implementation.
+func (W3C) Extract(header http.Header) (SpanContext, bool) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(header.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+
+	// The version 00 has exactly four fields, the next ones may add
+	// some.
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !sc.IsValid() {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return sc, false
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	sc.TraceState = strings.Join(header.Values("tracestate"), ",")
+	return sc, true
+}
+
+// Inject is the `Propagator` interface implementation.
+func (W3C) Inject(sc SpanContext, header http.Header) {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	header.Set("traceparent", "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	header.Del("tracestate")
+	if len(sc.TraceState) > 0 {
+		header.Set("tracestate", sc.TraceState)
+	}
+}
+
+// B3 is the Propagator of the Zipkin B3 headers. Both the single "b3"
+// header and the multiple "X-B3-*" headers are extracted, the multiple
+// headers are injected.
+//
+// See https://github.com/openzipkin/b3-propagation
+type B3 struct{}
+
+// Extract is the `Propagator` interface implementation.
+func (B3) Extract(header http.Header) (SpanContext, bool) {
+	if single := header.Get("b3"); len(single) > 0 {
+		return extractB3Single(single)
+	}
+
+	var sc SpanContext
+	if !decodeB3TraceID(&sc.TraceID, header.Get("X-B3-TraceId")) ||
+		!decodeHex(sc.SpanID[:], header.Get("X-B3-SpanId")) || !sc.IsValid() {
+		return sc, false
+	}
+
+	sampled := header.Get("X-B3-Sampled")
+	sc.Sampled = sampled == "1" || strings.EqualFold(sampled, "true") || header.Get("X-B3-Flags") == "1"
+	return sc, true
+}
+
+// extractB3Single reads a "{TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}"
+// header.
+func extractB3Single(value string) (SpanContext, bool) {
+	var sc SpanContext
+
+	parts := strings.Split(value, "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return sc, false
+	}
+
+	if !decodeB3TraceID(&sc.TraceID, parts[0]) || !decodeHex(sc.SpanID[:], parts[1]) || !sc.IsValid() {
+		return sc, false
+	}
+
+	if len(parts) > 2 {
+		sc.Sampled = parts[2] == "1" || parts[2] == "d"
+	}
+	return sc, true
+}
+
+// Inject is the `Propagator` interface implementation.
+func (B3) Inject(sc SpanContext, header http.Header) {
+	sampled := "0"
+	if sc.Sampled {
+		sampled = "1"
+	}
+
+	header.Del("b3")
+	header.Del("X-B3-ParentSpanId")
+	header.Del("X-B3-Flags")
+	header.Set("X-B3-TraceId", sc.TraceID.String())
+	header.Set("X-B3-SpanId", sc.SpanID.String())
+	header.Set("X-B3-Sampled", sampled)
+}
+
+// decodeB3TraceID decodes a 64 or 128 bits trace ID.
+func decodeB3TraceID(id *TraceID, value string) bool {
+	if len(value) == 16 {
+		value = strings.Repeat("0", 16) + value
+	}
+	return decodeHex(id[:], value)
+}
+
+// decodeHex decodes a lower case hexadecimal value of the exact size
+// of dst.
+func decodeHex(dst []byte, value string) bool {
+	if len(value) != 2*len(dst) || strings.ToLower(value) != value {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(value))
+	return err == nil
+}
@@ -0,0 +1,335 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the hexadecimal form of the ID.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid checks that the ID is not zero.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a span in a trace.
+type SpanID [8]byte
+
+// String returns the hexadecimal form of the ID.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid checks that the ID is not zero.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span propagated to the other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+
+	// Sampled is set when the trace is recorded.
+	Sampled bool
+
+	// TraceState is the vendor data of the W3C "tracestate" header.
+	TraceState string
+}
+
+// IsValid checks that the trace and span IDs are set.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID.IsValid() && s.SpanID.IsValid()
+}
+
+// SpanKind is the role of a span in the trace.
+type SpanKind int
+
+// The span kinds, numbered as in OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span, numbered as in OTLP.
+type StatusCode int
+
+// The span status codes.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key/value pair describing a span. The value is a
+// string, a bool, an int, an int64 or a float64.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is a finished span, as given to the exporters.
+type SpanData struct {
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	Status       StatusCode
+	Message      string
+}
+
+// Span is an operation of a trace. The methods of a nil span do
+// nothing, so the code can be traced unconditionally.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the propagated part of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil || !s.data.SpanContext.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Attributes = append(s.data.Attributes, attributes...)
+	s.mu.Unlock()
+}
+
+// SetStatus sets the status of the span, with an optional message
+// for the errors.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Status, s.data.Message = code, message
+	s.mu.Unlock()
+}
+
+// End finishes the span and exports it if the trace is sampled.
+// Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.exporter.Export(&data)
+	}
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an integer attribute.
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Exporter sends the finished spans to a tracing backend.
+type Exporter interface {
+
+	// Export queues or sends a span. It must not block for long, as it
+	// is called at the end of the traced operations.
+	Export(span *SpanData)
+
+	// Close sends the queued spans and releases the resources.
+	Close() error
+}
+
+// Sampler decides if a new trace is recorded.
+type Sampler interface {
+
+	// ShouldSample returns true if the trace is recorded.
+	ShouldSample(traceID TraceID) bool
+}
+
+// RatioSampler samples a fraction of the traces, from 0 (none) to 1
+// (all), depending on their ID.
+type RatioSampler float64
+
+// ShouldSample is the `Sampler` interface implementation.
+func (r RatioSampler) ShouldSample(traceID TraceID) bool {
+	switch {
+	case r >= 1:
+		return true
+	case r <= 0:
+		return false
+	}
+
+	// The low bits of the ID are random with W3C and B3.
+	bound := uint64(float64(r) * (1 << 63))
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < bound
+}
+
+// Tracer creates the spans. The methods of a nil tracer return nil
+// spans and do not propagate anything.
+type Tracer struct {
+	exporter    Exporter
+	sampler     Sampler
+	propagators []Propagator
+}
+
+// Option is a function used to modify
+// the tracer behavior.
+type Option func(*Tracer)
+
+// WithSampler sets the sampler of the new traces. The traces are
+// recorded when their parent is, or otherwise all of them by default.
+func WithSampler(s Sampler) Option {
+	return func(t *Tracer) {
+		t.sampler = s
+	}
+}
+
+// WithPropagators sets the header formats extracted and injected.
+// The W3C and B3 formats are used by default.
+func WithPropagators(propagators ...Propagator) Option {
+	return func(t *Tracer) {
+		t.propagators = propagators
+	}
+}
+
+// NewTracer creates a tracer exporting the sampled spans with the
+// given exporter.
+func NewTracer(exporter Exporter, opts ...Option) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		sampler:     RatioSampler(1),
+		propagators: []Propagator{W3C{}, B3{}},
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Start creates a span, child of the span of the context if any, and
+// returns a context holding it.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	span := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: time.Now()}}
+	sc := &span.data.SpanContext
+	sc.SpanID = newSpanID()
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sampler.ShouldSample(sc.TraceID)
+	}
+
+	span.SetAttributes(attributes...)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Close flushes the exporter.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.Close()
+}
+
+// spanKey is the context key of the current span.
+type spanKey struct{}
+
+// remoteKey is the context key of the extracted span context.
+type remoteKey struct{}
+
+// SpanFromContext returns the current span of the context, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the span context of the current
+// span, or the one extracted from the incoming request.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteSpanContext returns a context holding an extracted
+// span context, the parent of the next started span.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
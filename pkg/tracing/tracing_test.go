@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestPropagators_Extract(t *testing.T) {
+	tests := []struct {
+		name       string
+		propagator Propagator
+		headers    map[string]string
+		want       string
+		sampled    bool
+		ok         bool
+	}{
+		{
+			name:       "W3C",
+			propagator: W3C{},
+			headers:    map[string]string{"traceparent": "00-" + traceID + "-" + spanID + "-01", "tracestate": "congo=t61rcWkgMzE"},
+			want:       traceID,
+			sampled:    true,
+			ok:         true,
+		},
+		{
+			name:       "W3C not sampled",
+			propagator: W3C{},
+			headers:    map[string]string{"traceparent": "00-" + traceID + "-" + spanID + "-00"},
+			want:       traceID,
+			ok:         true,
+		},
+		{
+			name:       "W3C future version",
+			propagator: W3C{},
+			headers:    map[string]string{"traceparent": "01-" + traceID + "-" + spanID + "-01-extra"},
+			want:       traceID,
+			sampled:    true,
+			ok:         true,
+		},
+		{
+			name:       "W3C zero trace ID",
+			propagator: W3C{},
+			headers:    map[string]string{"traceparent": "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01"},
+		},
+		{
+			name:       "W3C upper case",
+			propagator: W3C{},
+			headers:    map[string]string{"traceparent": "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01"},
+		},
+		{
+			name:       "W3C invalid version",
+			propagator: W3C{},
+			headers:    map[string]string{"traceparent": "ff-" + traceID + "-" + spanID + "-01"},
+		},
+		{
+			name:       "B3 multiple headers",
+			propagator: B3{},
+			headers:    map[string]string{"X-B3-TraceId": traceID, "X-B3-SpanId": spanID, "X-B3-Sampled": "1"},
+			want:       traceID,
+			sampled:    true,
+			ok:         true,
+		},
+		{
+			name:       "B3 64 bits trace ID",
+			propagator: B3{},
+			headers:    map[string]string{"X-B3-TraceId": traceID[16:], "X-B3-SpanId": spanID},
+			want:       strings.Repeat("0", 16) + traceID[16:],
+			ok:         true,
+		},
+		{
+			name:       "B3 debug",
+			propagator: B3{},
+			headers:    map[string]string{"X-B3-TraceId": traceID, "X-B3-SpanId": spanID, "X-B3-Flags": "1"},
+			want:       traceID,
+			sampled:    true,
+			ok:         true,
+		},
+		{
+			name:       "B3 single header",
+			propagator: B3{},
+			headers:    map[string]string{"b3": traceID + "-" + spanID + "-1-05e3ac9a4f6e3b90"},
+			want:       traceID,
+			sampled:    true,
+			ok:         true,
+		},
+		{
+			name:       "B3 single header deny",
+			propagator: B3{},
+			headers:    map[string]string{"b3": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make(http.Header)
+			for name, value := range tt.headers {
+				header.Set(name, value)
+			}
+
+			sc, ok := tt.propagator.Extract(header)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.want, sc.TraceID.String())
+				assert.Equal(t, spanID, sc.SpanID.String())
+				assert.Equal(t, tt.sampled, sc.Sampled)
+				assert.Equal(t, tt.headers["tracestate"], sc.TraceState)
+			}
+		})
+	}
+}
+
+func TestTracer_Propagation(t *testing.T) {
+	exporter := NewWriterExporter(&bytes.Buffer{})
+	tracer := NewTracer(exporter, WithSampler(RatioSampler(0)))
+
+	// The sampling decision of the client is kept.
+	incoming := http.Header{}
+	incoming.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	incoming.Set("tracestate", "congo=t61rcWkgMzE")
+
+	ctx := tracer.Extract(context.Background(), incoming)
+	ctx, span := tracer.Start(ctx, "test", SpanKindServer)
+	assert.Equal(t, traceID, span.SpanContext().TraceID.String())
+	assert.NotEqual(t, spanID, span.SpanContext().SpanID.String())
+	assert.True(t, span.SpanContext().Sampled)
+
+	outgoing := http.Header{}
+	outgoing.Set("b3", "stale")
+	tracer.Inject(ctx, outgoing)
+	assert.Equal(t, "00-"+traceID+"-"+span.SpanContext().SpanID.String()+"-01", outgoing.Get("traceparent"))
+	assert.Equal(t, "congo=t61rcWkgMzE", outgoing.Get("tracestate"))
+	assert.Equal(t, traceID, outgoing.Get("X-B3-TraceId"))
+	assert.Equal(t, span.SpanContext().SpanID.String(), outgoing.Get("X-B3-SpanId"))
+	assert.Equal(t, "1", outgoing.Get("X-B3-Sampled"))
+	assert.Empty(t, outgoing.Get("b3"))
+
+	// The new traces are sampled by the tracer.
+	_, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	assert.False(t, root.SpanContext().Sampled)
+	assert.True(t, root.SpanContext().IsValid())
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, span := tracer.Start(context.Background(), "test", SpanKindInternal)
+	assert.Nil(t, span)
+	span.SetAttributes(String("key", "value"))
+	span.SetStatus(StatusError, "error")
+	span.End()
+
+	header := http.Header{}
+	tracer.Inject(ctx, header)
+	assert.Empty(t, header)
+	assert.NoError(t, tracer.Close())
+}
+
+func TestRatioSampler(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if RatioSampler(0.25).ShouldSample(newTraceID()) {
+			sampled++
+		}
+	}
+
+	assert.InDelta(t, 2500, sampled, 250)
+	assert.True(t, RatioSampler(1).ShouldSample(TraceID{}))
+	assert.False(t, RatioSampler(0).ShouldSample(newTraceID()))
+}
+
+func TestWriterExporter(t *testing.T) {
+	var b bytes.Buffer
+	tracer := NewTracer(NewWriterExporter(&b))
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer, String("http.method", "GET"))
+	_, child := tracer.Start(ctx, "child", SpanKindClient, Int("http.status_code", 502), Bool("retry", false))
+	child.SetStatus(StatusError, "bad gateway")
+	child.End()
+	child.End()
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	var span otlpSpan
+	if assert.NoError(t, json.Unmarshal([]byte(lines[0]), &span)) {
+		assert.Equal(t, "child", span.Name)
+		assert.Equal(t, SpanKindClient, span.Kind)
+		assert.Equal(t, parent.SpanContext().TraceID.String(), span.TraceID)
+		assert.Equal(t, parent.SpanContext().SpanID.String(), span.ParentSpanID)
+		assert.Equal(t, otlpStatus{Code: StatusError, Message: "bad gateway"}, span.Status)
+		assert.Contains(t, lines[0], `{"key":"http.status_code","value":{"intValue":"502"}}`)
+		assert.Contains(t, lines[0], `{"key":"retry","value":{"boolValue":false}}`)
+	}
+
+	assert.Contains(t, lines[1], `"name":"parent"`)
+	assert.NotContains(t, lines[1], "parentSpanId")
+}